@@ -0,0 +1,400 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/arduino/aws-s3-integration/app/exporter"
+	"github.com/arduino/aws-s3-integration/business/thingselector"
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/arduino/aws-s3-integration/internal/keytemplate"
+	"github.com/arduino/aws-s3-integration/internal/parameters"
+)
+
+const (
+	// Namespace of parameters of deployments without stack, kept for backward compatibility
+	LegacyPrefix = "/arduino/s3-importer"
+	// Namespace of per stack parameters
+	StackPrefix = "/arduino/s3-exporter/" + parameters.StackName
+)
+
+// Parameter names, relative to namespace prefix
+const (
+	ApiKeyParam              = "/iot/api-key"
+	ApiSecretParam           = "/iot/api-secret"
+	OrgIdParam               = "/iot/org-id"
+	TagsParam                = "/iot/filter/tags"
+	ThingIdsParam            = "/iot/filter/thing-ids"
+	DeviceIdsParam           = "/iot/filter/device-ids"
+	ThingNamesParam          = "/iot/filter/thing-names"
+	ResolutionParam          = "/iot/samples-resolution"
+	SchedulingParam          = "/iot/scheduling"
+	DestinationBucketParam   = "/destination-bucket"
+	DestinationDirParam      = "/destination-directory"
+	AggregationStatParam     = "/iot/aggregation-statistic"
+	AlignWithTimeWindowParam = "/iot/align_with_time_window"
+	EnableCompressionParam   = "/enable_compression"
+	OutputFormatParam        = "/output_format"
+	EnableWatermarkParam     = "/enable_watermark"
+	KeyTemplateParam         = "/destination-key-template"
+	OutputSplitParam         = "/output_split"
+	StreamingUploadParam     = "/enable_streaming_upload"
+	ErrorPolicyParam         = "/error_policy"
+	MaxFailedThingsParam     = "/error_max_failed_things"
+	PropertyRulesParam       = "/iot/property-rules"
+	PropertyFilterParam      = "/iot/filter/properties"
+	ExpandComplexValuesParam = "/expand_complex_values"
+	TenantsParam             = "/tenants"
+	FanOutQueueParam         = "/fanout_queue_url"
+	FanOutShardSizeParam     = "/fanout_shard_size"
+
+	// Resolution in seconds, available only in legacy namespace
+	legacyResolutionSecondsParam = "/iot/samples-resolution-seconds"
+)
+
+// Parameters available in legacy namespace. Other parameters keep their defaults when no stack is configured.
+var legacyParams = map[string]bool{
+	ApiKeyParam:                  true,
+	ApiSecretParam:               true,
+	OrgIdParam:                   true,
+	TagsParam:                    true,
+	ResolutionParam:              true,
+	SchedulingParam:              true,
+	DestinationBucketParam:       true,
+	legacyResolutionSecondsParam: true,
+}
+
+// Supported parameters, used to detect unknown keys in configuration files and secrets
+var knownParams = map[string]bool{
+	ApiKeyParam:                  true,
+	ApiSecretParam:               true,
+	OrgIdParam:                   true,
+	TagsParam:                    true,
+	ThingIdsParam:                true,
+	DeviceIdsParam:               true,
+	ThingNamesParam:              true,
+	ResolutionParam:              true,
+	SchedulingParam:              true,
+	DestinationBucketParam:       true,
+	DestinationDirParam:          true,
+	AggregationStatParam:         true,
+	AlignWithTimeWindowParam:     true,
+	EnableCompressionParam:       true,
+	OutputFormatParam:            true,
+	EnableWatermarkParam:         true,
+	KeyTemplateParam:             true,
+	OutputSplitParam:             true,
+	StreamingUploadParam:         true,
+	ErrorPolicyParam:             true,
+	MaxFailedThingsParam:         true,
+	PropertyRulesParam:           true,
+	PropertyFilterParam:          true,
+	ExpandComplexValuesParam:     true,
+	TenantsParam:                 true,
+	FanOutQueueParam:             true,
+	FanOutShardSizeParam:         true,
+	legacyResolutionSecondsParam: true,
+}
+
+const (
+	DefaultResolutionSeconds = 300
+	DefaultTimeWindowMinutes = 60
+	DefaultAggregationStat   = "AVG"
+)
+
+// Config is the validated exporter configuration
+type Config struct {
+	Stack             string
+	ApiKey            string
+	ApiSecret         string
+	OrgID             string
+	DestinationBucket string
+	// Local directory where files are written instead of destination bucket, supported by command line exporter
+	DestinationDirectory string
+	ResolutionSeconds    int
+	TimeWindowMinutes    int
+	AggregationStats     []string
+	// Tenant profiles exported by multi-tenant deployments. When set, things are exported for each tenant
+	// with its credentials, instead of using ApiKey, ApiSecret and OrgID.
+	Tenants []exporter.Tenant
+	// SQS queue of fan-out executions: when set, scheduled and backfill executions enqueue shards of things,
+	// exported by workers triggered by the queue
+	FanOutQueueURL  string
+	FanOutShardSize int
+	exporter.Options
+}
+
+// Load reads configuration of the given stack from provider and validates it.
+// All invalid or missing parameters are reported by the returned error.
+func Load(ctx context.Context, provider Provider, stack string) (*Config, error) {
+	l := &loader{ctx: ctx, provider: provider, stack: stack}
+	tenants, err := exporter.ParseTenants(l.string(TenantsParam, ""))
+	if err != nil {
+		l.invalid(TenantsParam, err)
+	}
+	cfg := &Config{
+		Stack:                stack,
+		OrgID:                l.string(OrgIdParam, ""),
+		DestinationDirectory: l.string(DestinationDirParam, ""),
+		ResolutionSeconds:    l.resolution(),
+		TimeWindowMinutes:    l.timeWindow(),
+		Tenants:              tenants,
+	}
+	if len(tenants) == 0 {
+		cfg.ApiKey = l.required(ApiKeyParam)
+		cfg.ApiSecret = l.required(ApiSecretParam)
+		if cfg.DestinationDirectory == "" {
+			cfg.DestinationBucket = l.required(DestinationBucketParam)
+		} else if cfg.DestinationBucket = l.string(DestinationBucketParam, ""); cfg.DestinationBucket != "" {
+			l.invalid(DestinationDirParam, errors.New("destination directory is alternative to destination bucket"))
+		}
+	} else {
+		cfg.ApiKey = l.string(ApiKeyParam, "")
+		cfg.ApiSecret = l.string(ApiSecretParam, "")
+		cfg.DestinationBucket = l.string(DestinationBucketParam, "")
+		if err := validateTenantDestinations(tenants, cfg.DestinationBucket); err != nil {
+			l.invalid(TenantsParam, err)
+		}
+		if cfg.DestinationDirectory != "" {
+			l.invalid(DestinationDirParam, errors.New("tenants are exported only to destination buckets"))
+		}
+	}
+
+	if cfg.AggregationStats, err = tsextractor.ParseAggregationStatistics(l.string(AggregationStatParam, DefaultAggregationStat)); err != nil {
+		l.invalid(AggregationStatParam, err)
+	}
+
+	cfg.Selector, err = thingselector.New(l.string(ThingIdsParam, ""), l.string(DeviceIdsParam, ""), l.string(ThingNamesParam, ""), l.string(TagsParam, ""))
+	if err != nil {
+		l.invalid(selectorParam(err), err)
+	}
+	cfg.AlignTimeWindow = l.bool(AlignWithTimeWindowParam)
+	cfg.Compress = l.bool(EnableCompressionParam)
+	cfg.Watermark = l.bool(EnableWatermarkParam)
+	cfg.StreamUpload = l.bool(StreamingUploadParam)
+	cfg.ExpandComplexValues = l.bool(ExpandComplexValuesParam)
+	cfg.StackName = stack
+
+	cfg.OutputFormat = l.string(OutputFormatParam, tsextractor.OutputFormatCSV)
+	if !tsextractor.IsSupportedOutputFormat(cfg.OutputFormat) {
+		l.invalid(OutputFormatParam, fmt.Errorf("unsupported output format: %s", cfg.OutputFormat))
+	}
+	if cfg.StreamUpload && cfg.OutputFormat == tsextractor.OutputFormatCSVWide {
+		// Wide rows are buffered in memory until the whole time window is extracted
+		l.invalid(StreamingUploadParam, errors.New("csv_wide output format does not support streaming upload"))
+	}
+	if cfg.KeyTemplate, err = keytemplate.Parse(l.string(KeyTemplateParam, keytemplate.PresetDefault)); err != nil {
+		l.invalid(KeyTemplateParam, err)
+	}
+	if cfg.OutputSplit, err = exporter.ParseOutputSplit(l.string(OutputSplitParam, "")); err != nil {
+		l.invalid(OutputSplitParam, err)
+	}
+	if cfg.ErrorPolicy, err = exporter.ParseErrorPolicy(l.string(ErrorPolicyParam, exporter.ErrorPolicyFail), l.string(MaxFailedThingsParam, "")); err != nil {
+		l.invalid(ErrorPolicyParam, err)
+	}
+	if cfg.PropertyRules, err = tsextractor.ParsePropertyRules(l.string(PropertyRulesParam, "")); err != nil {
+		l.invalid(PropertyRulesParam, err)
+	}
+	if cfg.PropertyFilter, err = tsextractor.ParsePropertyFilter(l.string(PropertyFilterParam, "")); err != nil {
+		l.invalid(PropertyFilterParam, err)
+	}
+	cfg.FanOutQueueURL = l.string(FanOutQueueParam, "")
+	cfg.FanOutShardSize = l.shardSize()
+	if cfg.FanOutQueueURL != "" && cfg.OutputSplit.IsEnabled() {
+		l.invalid(FanOutQueueParam, errors.New("fan-out execution does not support output split"))
+	}
+
+	if len(l.errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errors.Join(l.errs...))
+	}
+	return cfg, nil
+}
+
+// selectorParam returns the parameter of the thing selector criterion reported by err
+func selectorParam(err error) string {
+	var criterionErr *thingselector.CriterionError
+	if !errors.As(err, &criterionErr) {
+		return TagsParam
+	}
+	switch criterionErr.Criterion {
+	case thingselector.CriterionThingIDs:
+		return ThingIdsParam
+	case thingselector.CriterionDeviceIDs:
+		return DeviceIdsParam
+	case thingselector.CriterionNames:
+		return ThingNamesParam
+	}
+	return TagsParam
+}
+
+// validateTenantDestinations checks that every tenant has a destination bucket and that tenants do not share the same destination
+func validateTenantDestinations(tenants []exporter.Tenant, defaultBucket string) error {
+	destinations := map[string]string{}
+	for _, tenant := range tenants {
+		bucket := tenant.DestinationBucket
+		if bucket == "" {
+			bucket = defaultBucket
+		}
+		if bucket == "" {
+			return fmt.Errorf("tenant %s: destination bucket is not configured", tenant.Name)
+		}
+		destination := bucket + "/" + tenant.DestinationPrefix
+		if other, ok := destinations[destination]; ok {
+			return fmt.Errorf("tenants %s and %s have the same destination: %s", other, tenant.Name, destination)
+		}
+		destinations[destination] = tenant.Name
+	}
+	return nil
+}
+
+// ParseResolution converts a resolution label (raw, 1 minute, 5 minutes, 15 minutes, 1 hour) to seconds
+func ParseResolution(label string) (int, error) {
+	switch label {
+	case "raw":
+		return -1, nil
+	case "1 minute":
+		return 60, nil
+	case "5 minutes":
+		return 300, nil
+	case "15 minutes":
+		return 900, nil
+	case "1 hour":
+		return 3600, nil
+	}
+	return 0, fmt.Errorf("unsupported resolution: %s", label)
+}
+
+// ParseScheduling converts a scheduling label (5 minutes, 15 minutes, 1 hour, 1 day) to the time window in minutes
+func ParseScheduling(label string) (int, error) {
+	switch label {
+	case "5 minutes":
+		return 5, nil
+	case "15 minutes":
+		return 15, nil
+	case "1 hour":
+		return 60, nil
+	case "1 day":
+		return 24 * 60, nil
+	}
+	return 0, fmt.Errorf("unsupported scheduling: %s", label)
+}
+
+// loader reads parameters from a provider, collecting errors
+type loader struct {
+	ctx      context.Context
+	provider Provider
+	stack    string
+	errs     []error
+}
+
+// Namespace returns the prefix of parameters of the given stack, or the legacy prefix without stack
+func Namespace(stack string) string {
+	if stack == "" {
+		return LegacyPrefix
+	}
+	return parameters.ResolveParameter(StackPrefix, stack)
+}
+
+func (l *loader) invalid(param string, err error) {
+	l.errs = append(l.errs, fmt.Errorf("parameter %s: %w", l.provider.Describe(param), err))
+}
+
+// lookup returns the value of a parameter. Missing and empty parameters are not set.
+func (l *loader) lookup(param string) (string, bool) {
+	value, found, err := l.provider.Lookup(l.ctx, param)
+	if err != nil {
+		l.invalid(param, err)
+		return "", false
+	}
+	return value, found && value != ""
+}
+
+func (l *loader) required(param string) string {
+	value, ok := l.lookup(param)
+	if !ok {
+		l.invalid(param, errors.New("required parameter is missing"))
+	}
+	return value
+}
+
+func (l *loader) string(param, defaultValue string) string {
+	if value, ok := l.lookup(param); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func (l *loader) bool(param string) bool {
+	value, ok := l.lookup(param)
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		l.invalid(param, fmt.Errorf("%s is not true or false", value))
+	}
+	return enabled
+}
+
+func (l *loader) resolution() int {
+	// Legacy deployments can configure resolution in seconds
+	if l.stack == "" {
+		if seconds, ok := l.lookup(legacyResolutionSecondsParam); ok {
+			resolution, err := strconv.Atoi(seconds)
+			if err != nil || (resolution != -1 && (resolution < 60 || resolution > 3600)) {
+				l.invalid(legacyResolutionSecondsParam, errors.New("resolution must be -1 (raw) or between 60 and 3600 seconds"))
+			}
+			return resolution
+		}
+	}
+	label, ok := l.lookup(ResolutionParam)
+	if !ok {
+		return DefaultResolutionSeconds
+	}
+	resolution, err := ParseResolution(label)
+	if err != nil {
+		l.invalid(ResolutionParam, err)
+	}
+	return resolution
+}
+
+func (l *loader) shardSize() int {
+	value, ok := l.lookup(FanOutShardSizeParam)
+	if !ok {
+		return exporter.DefaultShardSize
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		l.invalid(FanOutShardSizeParam, fmt.Errorf("%s is not a positive number", value))
+	}
+	return size
+}
+
+func (l *loader) timeWindow() int {
+	label, ok := l.lookup(SchedulingParam)
+	if !ok {
+		return DefaultTimeWindowMinutes
+	}
+	minutes, err := ParseScheduling(label)
+	if err != nil {
+		l.invalid(SchedulingParam, err)
+	}
+	return minutes
+}
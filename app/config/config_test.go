@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/arduino/aws-s3-integration/app/exporter"
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapReader is a Reader backed by a map of full parameter names
+type mapReader map[string]string
+
+func (m mapReader) Lookup(_ context.Context, param string) (string, bool, error) {
+	value, ok := m[param]
+	if value == "fail" {
+		return "", false, errors.New("access denied")
+	}
+	return value, ok, nil
+}
+
+func TestLoad_stackDefaults(t *testing.T) {
+	cfg, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":        "key",
+		"/arduino/s3-exporter/test/iot/api-secret":     "secret",
+		"/arduino/s3-exporter/test/destination-bucket": "bucket",
+		"/arduino/s3-exporter/test/iot/filter/tags":    "",
+	}, "test"), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", cfg.ApiKey)
+	assert.Equal(t, "bucket", cfg.DestinationBucket)
+	assert.Equal(t, DefaultResolutionSeconds, cfg.ResolutionSeconds)
+	assert.Equal(t, DefaultTimeWindowMinutes, cfg.TimeWindowMinutes)
+	assert.Equal(t, []string{"AVG"}, cfg.AggregationStats)
+	assert.Equal(t, tsextractor.OutputFormatCSV, cfg.OutputFormat)
+	assert.Equal(t, exporter.ErrorPolicyFail, cfg.ErrorPolicy.Mode)
+	assert.Equal(t, "test", cfg.StackName)
+	assert.True(t, cfg.Selector.IsEmpty())
+	assert.NotNil(t, cfg.KeyTemplate)
+}
+
+func TestLoad_stack(t *testing.T) {
+	cfg, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":                    "key",
+		"/arduino/s3-exporter/test/iot/api-secret":                 "secret",
+		"/arduino/s3-exporter/test/destination-bucket":             "bucket",
+		"/arduino/s3-exporter/test/iot/samples-resolution":         "raw",
+		"/arduino/s3-exporter/test/iot/scheduling":                 "1 day",
+		"/arduino/s3-exporter/test/iot/aggregation-statistic":      "MAX,MIN",
+		"/arduino/s3-exporter/test/output_format":                  "jsonl",
+		"/arduino/s3-exporter/test/enable_compression":             "true",
+		"/arduino/s3-exporter/test/error_policy":                   "skip",
+		"/arduino/s3-exporter/test/error_max_failed_things":        "10%",
+		"/arduino/s3-exporter/test/iot/filter/tags":                "site=turin",
+		"/arduino/s3-exporter/test/iot/align_with_time_window":     "false",
+		"/arduino/s3-exporter/test/iot/samples-resolution-seconds": "60",
+	}, "test"), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, cfg.ResolutionSeconds)
+	assert.Equal(t, 24*60, cfg.TimeWindowMinutes)
+	assert.Equal(t, []string{"MAX", "MIN"}, cfg.AggregationStats)
+	assert.Equal(t, tsextractor.OutputFormatJSONL, cfg.OutputFormat)
+	assert.True(t, cfg.Compress)
+	assert.False(t, cfg.AlignTimeWindow)
+	assert.Equal(t, 10.0, cfg.ErrorPolicy.MaxFailedPercent)
+	assert.Equal(t, []map[string]string{{"site": "turin"}}, cfg.Selector.TagGroups)
+}
+
+func TestLoad_legacyNamespace(t *testing.T) {
+	cfg, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-importer/iot/api-key":                    "key",
+		"/arduino/s3-importer/iot/api-secret":                 "secret",
+		"/arduino/s3-importer/destination-bucket":             "bucket",
+		"/arduino/s3-importer/iot/samples-resolution-seconds": "900",
+		"/arduino/s3-importer/iot/samples-resolution":         "1 minute",
+		"/arduino/s3-importer/output_format":                  "unknown",
+	}, ""), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 900, cfg.ResolutionSeconds)
+	// Per stack parameters are not read from legacy namespace
+	assert.Equal(t, tsextractor.OutputFormatCSV, cfg.OutputFormat)
+	assert.Equal(t, "", cfg.StackName)
+}
+
+func TestLoad_errorsAreAggregated(t *testing.T) {
+	_, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":            "key",
+		"/arduino/s3-exporter/test/iot/api-secret":         "fail",
+		"/arduino/s3-exporter/test/iot/samples-resolution": "2 minutes",
+		"/arduino/s3-exporter/test/output_format":          "xml",
+		"/arduino/s3-exporter/test/enable_watermark":       "yes",
+	}, "test"), "test")
+	assert.Error(t, err)
+	for _, expected := range []string{
+		"parameter /arduino/s3-exporter/test/iot/api-secret: access denied",
+		"parameter /arduino/s3-exporter/test/destination-bucket: required parameter is missing",
+		"parameter /arduino/s3-exporter/test/iot/samples-resolution: unsupported resolution: 2 minutes",
+		"parameter /arduino/s3-exporter/test/output_format: unsupported output format: xml",
+		"parameter /arduino/s3-exporter/test/enable_watermark: yes is not true or false",
+	} {
+		assert.Contains(t, err.Error(), expected)
+	}
+}
+
+func TestLoad_tenants(t *testing.T) {
+	tenants := `[{"name": "acme", "api-key": "k1", "api-secret": "s1"}, {"name": "globex", "api-key": "k2", "api-secret": "s2", "destination-bucket": "globex"}]`
+	cfg, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/tenants":            tenants,
+		"/arduino/s3-exporter/test/destination-bucket": "bucket",
+	}, "test"), "test")
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Tenants, 2)
+	assert.Equal(t, "", cfg.ApiKey)
+
+	// Tenants without own bucket require default one, destinations must be different
+	_, err = Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/tenants": tenants,
+	}, "test"), "test")
+	assert.EqualError(t, err, "invalid configuration: parameter /arduino/s3-exporter/test/tenants: tenant acme: destination bucket is not configured")
+	_, err = Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/tenants":            `[{"name": "acme", "api-key": "k1", "api-secret": "s1"}, {"name": "globex", "api-key": "k2", "api-secret": "s2", "destination-prefix": "acme"}]`,
+		"/arduino/s3-exporter/test/destination-bucket": "bucket",
+	}, "test"), "test")
+	assert.EqualError(t, err, "invalid configuration: parameter /arduino/s3-exporter/test/tenants: tenants acme and globex have the same destination: bucket/acme")
+}
+
+func TestLoad_fanOut(t *testing.T) {
+	cfg, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":        "key",
+		"/arduino/s3-exporter/test/iot/api-secret":     "secret",
+		"/arduino/s3-exporter/test/destination-bucket": "bucket",
+		"/arduino/s3-exporter/test/fanout_queue_url":   "https://sqs.eu-west-1.amazonaws.com/123456789012/shards",
+	}, "test"), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sqs.eu-west-1.amazonaws.com/123456789012/shards", cfg.FanOutQueueURL)
+	assert.Equal(t, exporter.DefaultShardSize, cfg.FanOutShardSize)
+
+	_, err = Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":        "key",
+		"/arduino/s3-exporter/test/iot/api-secret":     "secret",
+		"/arduino/s3-exporter/test/destination-bucket": "bucket",
+		"/arduino/s3-exporter/test/fanout_queue_url":   "https://sqs.eu-west-1.amazonaws.com/123456789012/shards",
+		"/arduino/s3-exporter/test/fanout_shard_size":  "0",
+		"/arduino/s3-exporter/test/output_split":       "thing",
+	}, "test"), "test")
+	assert.ErrorContains(t, err, "parameter /arduino/s3-exporter/test/fanout_shard_size: 0 is not a positive number")
+	assert.ErrorContains(t, err, "parameter /arduino/s3-exporter/test/fanout_queue_url: fan-out execution does not support output split")
+}
+
+func TestLoad_wideLayoutWithStreaming(t *testing.T) {
+	_, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":             "key",
+		"/arduino/s3-exporter/test/iot/api-secret":          "secret",
+		"/arduino/s3-exporter/test/destination-bucket":      "bucket",
+		"/arduino/s3-exporter/test/output_format":           "csv_wide",
+		"/arduino/s3-exporter/test/enable_streaming_upload": "true",
+	}, "test"), "test")
+	assert.EqualError(t, err, "invalid configuration: parameter /arduino/s3-exporter/test/enable_streaming_upload: csv_wide output format does not support streaming upload")
+}
+
+func TestLoad_selectorErrors(t *testing.T) {
+	_, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":            "key",
+		"/arduino/s3-exporter/test/iot/api-secret":         "secret",
+		"/arduino/s3-exporter/test/destination-bucket":     "bucket",
+		"/arduino/s3-exporter/test/iot/filter/thing-names": "/(/",
+	}, "test"), "test")
+	assert.ErrorContains(t, err, "parameter /arduino/s3-exporter/test/iot/filter/thing-names: ")
+
+	_, err = Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":        "key",
+		"/arduino/s3-exporter/test/iot/api-secret":     "secret",
+		"/arduino/s3-exporter/test/destination-bucket": "bucket",
+		"/arduino/s3-exporter/test/iot/filter/tags":    "site",
+	}, "test"), "test")
+	assert.ErrorContains(t, err, "parameter /arduino/s3-exporter/test/iot/filter/tags: invalid tag filter site")
+}
+
+func TestLoad_destinationDirectory(t *testing.T) {
+	cfg, err := Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":           "key",
+		"/arduino/s3-exporter/test/iot/api-secret":        "secret",
+		"/arduino/s3-exporter/test/destination-directory": "/data/arduino",
+	}, "test"), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/arduino", cfg.DestinationDirectory)
+	assert.Equal(t, "", cfg.DestinationBucket)
+
+	_, err = Load(context.Background(), NewSSMProvider(mapReader{
+		"/arduino/s3-exporter/test/iot/api-key":           "key",
+		"/arduino/s3-exporter/test/iot/api-secret":        "secret",
+		"/arduino/s3-exporter/test/destination-directory": "/data/arduino",
+		"/arduino/s3-exporter/test/destination-bucket":    "bucket",
+	}, "test"), "test")
+	assert.EqualError(t, err, "invalid configuration: parameter /arduino/s3-exporter/test/destination-directory: destination directory is alternative to destination bucket")
+}
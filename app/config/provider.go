@@ -0,0 +1,222 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Provider provides configuration parameters by name, relative to stack namespace (for example /iot/api-key)
+type Provider interface {
+	// Lookup returns the value of a parameter, reporting whether it is set
+	Lookup(ctx context.Context, param string) (string, bool, error)
+	// Describe returns where a parameter is read from (for example its SSM name), to report invalid values
+	Describe(param string) string
+}
+
+// Reader reads parameters by full name, reporting whether they exist (for example SSM parameters client)
+type Reader interface {
+	Lookup(ctx context.Context, param string) (string, bool, error)
+}
+
+// SecretReader reads the value of a secret by name or ARN (for example Secrets Manager client)
+type SecretReader interface {
+	ReadSecret(ctx context.Context, secretID string) (string, error)
+}
+
+// ssmProvider reads parameters of a stack namespace from SSM Parameter Store
+type ssmProvider struct {
+	reader Reader
+	stack  string
+}
+
+// NewSSMProvider returns a provider reading parameters of the given stack. Without stack, the legacy namespace
+// is used and only parameters available in legacy deployments are read.
+func NewSSMProvider(reader Reader, stack string) Provider {
+	return &ssmProvider{reader: reader, stack: stack}
+}
+
+func (p *ssmProvider) Lookup(ctx context.Context, param string) (string, bool, error) {
+	if p.stack == "" && !legacyParams[param] {
+		return "", false, nil
+	}
+	return p.reader.Lookup(ctx, p.Describe(param))
+}
+
+func (p *ssmProvider) Describe(param string) string {
+	return Namespace(p.stack) + param
+}
+
+// Prefix of environment variables read by env provider
+const EnvPrefix = "ARDUINO_EXPORTER_"
+
+// envProvider reads parameters from environment variables
+type envProvider struct {
+	lookupEnv func(string) (string, bool)
+}
+
+// NewEnvProvider returns a provider reading parameters from environment variables (see EnvName), using lookupEnv (for example os.LookupEnv)
+func NewEnvProvider(lookupEnv func(string) (string, bool)) Provider {
+	return &envProvider{lookupEnv: lookupEnv}
+}
+
+// EnvName returns the environment variable of a parameter: ARDUINO_EXPORTER_ followed by parameter name
+// in uppercase, with '/' and '-' replaced by '_' (for example /iot/api-key is ARDUINO_EXPORTER_IOT_API_KEY)
+func EnvName(param string) string {
+	name := strings.NewReplacer("/", "_", "-", "_").Replace(strings.TrimPrefix(param, "/"))
+	return EnvPrefix + strings.ToUpper(name)
+}
+
+func (p *envProvider) Lookup(_ context.Context, param string) (string, bool, error) {
+	value, found := p.lookupEnv(EnvName(param))
+	return value, found, nil
+}
+
+func (p *envProvider) Describe(param string) string {
+	return EnvName(param)
+}
+
+// valuesProvider provides parameters read from a configuration file or a secret, keyed by name without leading '/' (for example iot/api-key)
+type valuesProvider struct {
+	values map[string]string
+	source string
+}
+
+func newValuesProvider(values map[string]string, source string) (Provider, error) {
+	unknown := []string{}
+	for key := range values {
+		if !knownParams["/"+key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters in %s: %s", source, strings.Join(unknown, ", "))
+	}
+	return &valuesProvider{values: values, source: source}, nil
+}
+
+// NewFileProvider returns a provider reading parameters from a YAML file, for example:
+//
+//	iot/api-key: <key>
+//	iot/samples-resolution: 15 minutes
+//	destination-bucket: my-bucket
+func NewFileProvider(path string) (Provider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	values := map[string]string{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return newValuesProvider(values, "config file "+path)
+}
+
+// NewSecretProvider returns a provider reading parameters from a secret, whose value is a JSON object
+// keyed like configuration files (for example {"iot/api-key": "<key>", "iot/api-secret": "<secret>"}).
+// Secret is read once, when provider is created.
+func NewSecretProvider(ctx context.Context, reader SecretReader, secretID string) (Provider, error) {
+	secret, err := reader.ReadSecret(ctx, secretID)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s: %w", secretID, err)
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object", secretID)
+	}
+	values := make(map[string]string, len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case []any, map[string]any:
+			// JSON parameters, like tenants, can be set as JSON values
+			encoded, _ := json.Marshal(v)
+			values[key] = string(encoded)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return newValuesProvider(values, "secret "+secretID)
+}
+
+func (p *valuesProvider) Lookup(_ context.Context, param string) (string, bool, error) {
+	value, found := p.values[strings.TrimPrefix(param, "/")]
+	return value, found, nil
+}
+
+func (p *valuesProvider) Describe(param string) string {
+	return strings.TrimPrefix(param, "/") + " in " + p.source
+}
+
+// chainProvider reads each parameter from the first provider where it is set
+type chainProvider struct {
+	providers []Provider
+
+	mu     sync.Mutex
+	source map[string]Provider
+}
+
+// NewChain returns a provider reading each parameter from the first of providers setting it to a not empty value.
+// Providers are given by precedence, for example: environment variables, configuration file, Secrets Manager, SSM.
+func NewChain(providers ...Provider) Provider {
+	return &chainProvider{providers: providers, source: map[string]Provider{}}
+}
+
+func (c *chainProvider) Lookup(ctx context.Context, param string) (string, bool, error) {
+	for _, provider := range c.providers {
+		value, found, err := provider.Lookup(ctx, param)
+		if err != nil {
+			c.setSource(param, provider)
+			return "", false, err
+		}
+		if found && value != "" {
+			c.setSource(param, provider)
+			return value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (c *chainProvider) setSource(param string, provider Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.source[param] = provider
+}
+
+// Describe describes the provider a parameter was read from, or all providers when it is not set
+func (c *chainProvider) Describe(param string) string {
+	c.mu.Lock()
+	provider, ok := c.source[param]
+	c.mu.Unlock()
+	if ok {
+		return provider.Describe(param)
+	}
+	names := make([]string, 0, len(c.providers))
+	for _, provider := range c.providers {
+		names = append(names, provider.Describe(param))
+	}
+	return strings.Join(names, " or ")
+}
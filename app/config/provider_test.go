@@ -0,0 +1,117 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecrets is a SecretReader backed by a map of secret values
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) ReadSecret(_ context.Context, secretID string) (string, error) {
+	secret, ok := f[secretID]
+	if !ok {
+		return "", errors.New("secret not found")
+	}
+	return secret, nil
+}
+
+func fakeEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "ARDUINO_EXPORTER_IOT_API_KEY", EnvName(ApiKeyParam))
+	assert.Equal(t, "ARDUINO_EXPORTER_IOT_FILTER_THING_IDS", EnvName(ThingIdsParam))
+	assert.Equal(t, "ARDUINO_EXPORTER_ENABLE_COMPRESSION", EnvName(EnableCompressionParam))
+}
+
+func TestFileProvider(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("iot/api-key: key\nenable_compression: true\n"), 0644))
+
+	provider, err := NewFileProvider(file)
+	assert.NoError(t, err)
+	value, found, err := provider.Lookup(context.Background(), ApiKeyParam)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "key", value)
+	value, _, _ = provider.Lookup(context.Background(), EnableCompressionParam)
+	assert.Equal(t, "true", value)
+	_, found, _ = provider.Lookup(context.Background(), ApiSecretParam)
+	assert.False(t, found)
+
+	assert.NoError(t, os.WriteFile(file, []byte("iot/api-key: key\napi-secret: secret\n"), 0644))
+	_, err = NewFileProvider(file)
+	assert.EqualError(t, err, "unknown parameters in config file "+file+": api-secret")
+}
+
+func TestSecretProvider(t *testing.T) {
+	ctx := context.Background()
+	secrets := fakeSecrets{
+		"exporter":     `{"iot/api-key": "key", "iot/api-secret": "secret", "enable_compression": true, "tenants": [{"name": "acme"}]}`,
+		"not-a-object": `secret`,
+	}
+
+	provider, err := NewSecretProvider(ctx, secrets, "exporter")
+	assert.NoError(t, err)
+	value, found, _ := provider.Lookup(ctx, ApiSecretParam)
+	assert.True(t, found)
+	assert.Equal(t, "secret", value)
+	value, _, _ = provider.Lookup(ctx, EnableCompressionParam)
+	assert.Equal(t, "true", value)
+	value, _, _ = provider.Lookup(ctx, TenantsParam)
+	assert.Equal(t, `[{"name":"acme"}]`, value)
+
+	_, err = NewSecretProvider(ctx, secrets, "not-a-object")
+	assert.EqualError(t, err, "secret not-a-object is not a JSON object")
+	_, err = NewSecretProvider(ctx, secrets, "missing")
+	assert.EqualError(t, err, "reading secret missing: secret not found")
+}
+
+func TestChain_precedence(t *testing.T) {
+	ctx := context.Background()
+	secret, err := NewSecretProvider(ctx, fakeSecrets{"exporter": `{"iot/api-key": "secret-key", "iot/api-secret": "secret"}`}, "exporter")
+	assert.NoError(t, err)
+	provider := NewChain(
+		NewEnvProvider(fakeEnv(map[string]string{
+			"ARDUINO_EXPORTER_IOT_API_KEY":        "env-key",
+			"ARDUINO_EXPORTER_ENABLE_COMPRESSION": "",
+			"ARDUINO_EXPORTER_OUTPUT_FORMAT":      "xml",
+		})),
+		secret,
+		NewSSMProvider(mapReader{
+			"/arduino/s3-exporter/test/iot/api-key":        "ssm-key",
+			"/arduino/s3-exporter/test/iot/api-secret":     "ssm-secret",
+			"/arduino/s3-exporter/test/destination-bucket": "bucket",
+			"/arduino/s3-exporter/test/enable_compression": "true",
+		}, "test"),
+	)
+
+	cfg, err := Load(ctx, provider, "test")
+	assert.EqualError(t, err, "invalid configuration: parameter ARDUINO_EXPORTER_OUTPUT_FORMAT: unsupported output format: xml")
+	assert.Nil(t, cfg)
+
+	value, _, _ := provider.Lookup(ctx, ApiKeyParam)
+	assert.Equal(t, "env-key", value)
+	value, _, _ = provider.Lookup(ctx, ApiSecretParam)
+	assert.Equal(t, "secret", value)
+	assert.Equal(t, "iot/api-secret in secret exporter", provider.Describe(ApiSecretParam))
+	value, _, _ = provider.Lookup(ctx, DestinationBucketParam)
+	assert.Equal(t, "bucket", value)
+	// Empty values do not override lower precedence providers
+	value, _, _ = provider.Lookup(ctx, EnableCompressionParam)
+	assert.Equal(t, "true", value)
+
+	_, found, _ := provider.Lookup(ctx, OrgIdParam)
+	assert.False(t, found)
+	assert.Equal(t, "ARDUINO_EXPORTER_IOT_ORG_ID or iot/org-id in secret exporter or /arduino/s3-exporter/test/iot/org-id", provider.Describe(OrgIdParam))
+}
@@ -0,0 +1,109 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"context"
+
+	"github.com/arduino/aws-s3-integration/business/thingselector"
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+// thingDiscovery lists selected things in background, in batches. Things are streamed to the first export
+// while they are discovered, so that extraction starts before the whole fleet is listed. Following exports
+// (for example, other time windows) reuse discovered things.
+type thingDiscovery struct {
+	things    chan iotclient.ArduinoThing
+	done      chan struct{}
+	cancel    context.CancelFunc
+	streamed  bool
+	thingsMap map[string]iotclient.ArduinoThing
+	err       error
+}
+
+// discoverThings starts the discovery of selected things. When thingIDs is not empty, selection is restricted to given things.
+func (s *samplesExporter) discoverThings(ctx context.Context, thingIDs []string) *thingDiscovery {
+	if len(thingIDs) > 0 {
+		s.logger.Infoln("Exporting selected things: ", thingIDs)
+	} else {
+		thingIDs = nil
+	}
+	if !s.selector.IsEmpty() {
+		s.logger.Infoln("Filtering things linked to configured account: ", s.selector.String())
+	} else if thingIDs == nil {
+		s.logger.Infoln("Importing all things linked to configured account")
+	}
+	if s.propertyFilter != nil {
+		s.logger.Infoln("Filtering thing properties: ", s.propertyFilter.String())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	d := &thingDiscovery{
+		things:    make(chan iotclient.ArduinoThing, thingselector.PropertiesBatchSize),
+		done:      make(chan struct{}),
+		cancel:    cancel,
+		thingsMap: make(map[string]iotclient.ArduinoThing),
+	}
+	go func() {
+		defer close(d.done)
+		defer close(d.things)
+		d.err = s.selector.Discover(ctx, s.iotClient, thingIDs, thingselector.PropertiesBatchSize, func(things []iotclient.ArduinoThing) error {
+			for _, thing := range things {
+				// Excluded properties are never fetched, nor reported in manifests
+				thing = s.propertyFilter.ApplyToThing(thing)
+				s.logger.Infoln("  Thing: ", thing.Id, thing.Name)
+				d.thingsMap[thing.Id] = thing
+				select {
+				case d.things <- thing:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if d.err != nil {
+			s.logger.Error("Error discovering things: ", d.err)
+		}
+	}()
+	return d
+}
+
+// stream returns the channel of things to export. First call receives things while they are discovered.
+func (d *thingDiscovery) stream() <-chan iotclient.ArduinoThing {
+	if !d.streamed {
+		d.streamed = true
+		return d.things
+	}
+	thingsMap, _ := d.wait()
+	return tsextractor.ThingsChannel(thingsMap)
+}
+
+// wait waits for discovery completion and returns discovered things. Things not consumed by an export are discarded.
+func (d *thingDiscovery) wait() (map[string]iotclient.ArduinoThing, error) {
+	d.streamed = true
+	for range d.things {
+	}
+	<-d.done
+	return d.thingsMap, d.err
+}
+
+// stop interrupts discovery, if still running, and returns the number of discovered things
+func (d *thingDiscovery) stop() int {
+	d.cancel()
+	thingsMap, _ := d.wait()
+	return len(thingsMap)
+}
@@ -16,27 +16,88 @@
 package exporter
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"time"
 
+	"github.com/arduino/aws-s3-integration/business/thingselector"
 	"github.com/arduino/aws-s3-integration/business/tsextractor"
 	"github.com/arduino/aws-s3-integration/internal/iot"
+	"github.com/arduino/aws-s3-integration/internal/keytemplate"
 	"github.com/arduino/aws-s3-integration/internal/s3"
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/arduino/aws-s3-integration/internal/state"
 	"github.com/arduino/aws-s3-integration/internal/utils"
-	iotclient "github.com/arduino/iot-client-go/v2"
 	"github.com/sirupsen/logrus"
 )
 
+// Value of thing placeholders in object keys of files containing all things
+const allThings = "all"
+
+// Max number of missed time windows recovered by a single execution, when watermark is enabled
+const maxCatchUpWindows = 24
+
+// Suffix of the marker object uploaded next to partial data, when partial error policy is configured
+const partialMarkerSuffix = ".partial.json"
+
+type partialMarker struct {
+	From         time.Time                  `json:"from"`
+	To           time.Time                  `json:"to"`
+	FailedThings []tsextractor.ThingFailure `json:"failed_things"`
+}
+
 type samplesExporter struct {
-	iotClient             *iot.Client
+	iotClient             iot.API
 	logger                *logrus.Entry
-	tagsF                 *string
+	selector              *thingselector.Selector
 	compress              bool
 	enableAlignTimeWindow bool
+	outputFormat          string
+	enableWatermark       bool
+	stackName             string
+	keyTemplate           *keytemplate.Template
+	outputSplit           OutputSplit
+	streamUpload          bool
+	errorPolicy           ErrorPolicy
+	propertyRules         tsextractor.PropertyRules
+	propertyFilter        *tsextractor.PropertyFilter
+	expandComplexValues   bool
+	// Shard exported by fan-out workers, nil otherwise
+	shard *ShardMessage
 }
 
-func New(key, secret, orgid string, tagsF *string, compress, enableAlignTimeWindow bool, logger *logrus.Entry) (*samplesExporter, error) {
+// Options configure what is exported and how exported data is written to destination
+type Options struct {
+	// Things to export
+	Selector *thingselector.Selector
+	// Compress csv and jsonl files with gzip
+	Compress bool
+	// Align data extraction with time window
+	AlignTimeWindow bool
+	OutputFormat    string
+	// Persist last exported window and recover missed ones
+	Watermark bool
+	// Name of the stack, isolating watermark state
+	StackName   string
+	KeyTemplate *keytemplate.Template
+	OutputSplit OutputSplit
+	// Stream data to destination, without local temporary files
+	StreamUpload        bool
+	ErrorPolicy         ErrorPolicy
+	PropertyRules       tsextractor.PropertyRules
+	PropertyFilter      *tsextractor.PropertyFilter
+	ExpandComplexValues bool
+}
+
+func New(key, secret, orgid string, options Options, logger *logrus.Entry) (*samplesExporter, error) {
+	if err := options.OutputSplit.validateKeyTemplate(options.KeyTemplate); err != nil {
+		return nil, err
+	}
+
 	iotcl, err := iot.NewClient(key, secret, orgid)
 	if err != nil {
 		return nil, err
@@ -45,74 +106,417 @@ func New(key, secret, orgid string, tagsF *string, compress, enableAlignTimeWind
 	return &samplesExporter{
 		iotClient:             iotcl,
 		logger:                logger,
-		tagsF:                 tagsF,
-		compress:              compress,
-		enableAlignTimeWindow: enableAlignTimeWindow,
+		selector:              options.Selector,
+		compress:              options.Compress,
+		enableAlignTimeWindow: options.AlignTimeWindow,
+		outputFormat:          options.OutputFormat,
+		enableWatermark:       options.Watermark,
+		stackName:             options.StackName,
+		keyTemplate:           options.KeyTemplate,
+		outputSplit:           options.OutputSplit,
+		streamUpload:          options.StreamUpload,
+		errorPolicy:           options.ErrorPolicy,
+		propertyRules:         options.PropertyRules,
+		propertyFilter:        options.PropertyFilter,
+		expandComplexValues:   options.ExpandComplexValues,
 	}, nil
 }
 
+// StartExporter exports last time window, or every time window not yet exported when watermark is enabled.
+// Returned report summarizes exported windows and failed things, also when an error is returned.
 func (s *samplesExporter) StartExporter(
 	ctx context.Context,
 	resolution, timeWindowMinutes int,
-	destinationS3Bucket string,
-	aggregationStat string) error {
+	destination s3.API,
+	aggregationStats []string) (*Report, error) {
 
-	if s.tagsF != nil {
-		s.logger.Infoln("Filtering things linked to configured account using tags: ", *s.tagsF)
-	} else {
-		s.logger.Infoln("Importing all things linked to configured account")
-	}
+	report := newReport()
+	// Things are discovered while first time window is exported
+	discovery := s.discoverThings(ctx, nil)
+	defer func() { report.Things = discovery.stop() }()
+
+	// Extract data points from thing and push to destination
+	tsextractorClient := tsextractor.New(s.iotClient, s.logger, s.propertyRules, s.propertyFilter != nil, s.expandComplexValues)
 
-	things, err := s.iotClient.ThingList(ctx, nil, nil, true, utils.ParseTags(s.tagsF))
+	windows, watermarkStore, err := s.pendingWindows(ctx, destination, resolution, timeWindowMinutes)
 	if err != nil {
-		return err
+		report.Status = StatusFailed
+		return report, err
 	}
-	thingsMap := make(map[string]iotclient.ArduinoThing, len(things))
-	for _, thing := range things {
-		s.logger.Infoln("  Thing: ", thing.Id, thing.Name)
-		thingsMap[thing.Id] = thing
+	if len(windows) == 0 {
+		s.logger.Infoln("No complete time window to export")
+		if _, err := discovery.wait(); err != nil {
+			report.Status = StatusFailed
+			return report, err
+		}
+		return report, nil
 	}
+	for _, window := range windows {
+		failures, err := s.exportTimeWindow(ctx, tsextractorClient, destination, window, discovery, resolution, aggregationStats)
+		if err != nil {
+			report.fail(window, err)
+			return report, err
+		}
+		report.addWindow(window, failures)
+		if watermarkStore != nil {
+			if err := watermarkStore.Save(ctx, window.To); err != nil {
+				s.logger.Error("Error saving export watermark: ", err)
+				report.Status = StatusFailed
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// pendingWindows returns the time windows to export: last time window, or every time window not yet exported
+// when watermark is enabled. Returned watermark store is nil when watermark is not enabled.
+func (s *samplesExporter) pendingWindows(
+	ctx context.Context,
+	destination s3.API,
+	resolution, timeWindowMinutes int) ([]tsextractor.TimeWindow, *state.WatermarkStore, error) {
+
+	// Resume from last exported time window, if watermark is enabled
+	var watermarkStore *state.WatermarkStore
+	var watermark *time.Time
+	var err error
+	if s.enableWatermark {
+		watermarkStore = state.NewWatermarkStore(destination, s.stackName)
+		watermark, err = watermarkStore.Load(ctx)
+		if err != nil {
+			s.logger.Error("Error reading export watermark: ", err)
+			return nil, nil, err
+		}
+		if watermark != nil {
+			s.logger.Infoln("Data already exported up to:", *watermark)
+		} else {
+			s.logger.Infoln("No export watermark found, exporting last time window")
+		}
+	}
+
+	windows := tsextractor.ComputeTimeWindows(resolution, timeWindowMinutes, s.enableAlignTimeWindow, watermark, maxCatchUpWindows)
+	if len(windows) > 1 {
+		s.logger.Infof("Recovering %d time windows, from %s to %s\n", len(windows), windows[0].From, windows[len(windows)-1].To)
+	}
+	return windows, watermarkStore, nil
+}
+
+// StartBackfill exports the given [from, to] time range, split in windows of timeWindowMinutes.
+// Export watermark is not affected by backfill executions.
+func (s *samplesExporter) StartBackfill(
+	ctx context.Context,
+	from, to time.Time,
+	thingIDs []string,
+	resolution, timeWindowMinutes int,
+	destination s3.API,
+	aggregationStats []string) (*Report, error) {
 
-	// Extract data points from thing and push to S3
-	tsextractorClient := tsextractor.New(s.iotClient, s.logger)
+	report := newReport()
+	discovery := s.discoverThings(ctx, thingIDs)
+	defer func() { report.Things = discovery.stop() }()
 
-	// Open s3 output writer
-	s3cl, err := s3.NewS3Client(destinationS3Bucket)
+	tsextractorClient := tsextractor.New(s.iotClient, s.logger, s.propertyRules, s.propertyFilter != nil, s.expandComplexValues)
+
+	windows := tsextractor.SplitTimeWindows(from, to, timeWindowMinutes)
+	s.logger.Infof("Backfilling %d time windows, from %s to %s\n", len(windows), from, to)
+	if len(windows) == 0 {
+		if _, err := discovery.wait(); err != nil {
+			report.Status = StatusFailed
+			return report, err
+		}
+	}
+	for _, window := range windows {
+		failures, err := s.exportTimeWindow(ctx, tsextractorClient, destination, window, discovery, resolution, aggregationStats)
+		if err != nil {
+			report.fail(window, err)
+			return report, err
+		}
+		report.addWindow(window, failures)
+	}
+
+	return report, nil
+}
+
+// exportTimeWindow exports a time window, applying the error policy. It returns things failures tolerated by the policy.
+func (s *samplesExporter) exportTimeWindow(
+	ctx context.Context,
+	tsextractorClient *tsextractor.TsExtractor,
+	destination s3.API,
+	window tsextractor.TimeWindow,
+	discovery *thingDiscovery,
+	resolution int,
+	aggregationStats []string) ([]tsextractor.ThingFailure, error) {
+
+	var failures []tsextractor.ThingFailure
+	var err error
+	if s.outputSplit.IsEnabled() {
+		// Split output always relies on local files, as a stream per thing would be kept open for the whole window
+		failures, err = s.exportTimeWindowSplit(ctx, tsextractorClient, destination, window, discovery, resolution, aggregationStats)
+	} else if s.streamUpload {
+		failures, err = s.exportTimeWindowStream(ctx, tsextractorClient, destination, window, discovery, resolution, aggregationStats)
+	} else {
+		failures, err = s.exportTimeWindowToFile(ctx, tsextractorClient, destination, window, discovery, resolution, aggregationStats)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if writer, from, err := tsextractorClient.ExportTSToFile(ctx, timeWindowMinutes, thingsMap, resolution, aggregationStat, s.enableAlignTimeWindow); err != nil {
-		if writer != nil {
-			writer.Close()
-			defer writer.Delete()
+	if len(failures) > 0 {
+		s.logger.Warnf("Time window from %s to %s exported without samples of %d things\n", window.From, window.To, len(failures))
+		if s.errorPolicy.Mode == ErrorPolicyPartial {
+			if err := s.markPartial(ctx, destination, window, failures); err != nil {
+				return nil, err
+			}
 		}
+	}
+	return failures, nil
+}
+
+func (s *samplesExporter) exportTimeWindowToFile(
+	ctx context.Context,
+	tsextractorClient *tsextractor.TsExtractor,
+	destination s3.API,
+	window tsextractor.TimeWindow,
+	discovery *thingDiscovery,
+	resolution int,
+	aggregationStats []string) ([]tsextractor.ThingFailure, error) {
+
+	stats := s.newStats()
+	writer, err := tsextractorClient.ExportTSWindowToFile(ctx, window.From, window.To, discovery.stream(), resolution, aggregationStats, s.outputFormat, stats)
+	var closeErr error
+	if writer != nil {
+		// Close completes the file (for example parquet footer), so a failure makes it unusable
+		closeErr = writer.Close()
+		defer writer.Delete()
+	}
+	thingsMap, discoveryErr := discovery.wait()
+	if discoveryErr != nil {
+		return nil, discoveryErr
+	}
+	failures, err := s.errorPolicy.apply(err, len(thingsMap))
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close output file: %w", closeErr)
+	}
+	if err != nil {
 		s.logger.Error("Error aligning time series samples: ", err)
-		return err
+		return nil, err
+	}
+
+	object, err := s.uploadFile(ctx, destination, writer, s.allThingsKeyValues(window))
+	if err != nil {
+		return nil, err
+	}
+	manifest := s.newManifest(window, resolution, aggregationStats, thingsMap, stats, failures, allThingsIncluded)
+	if err := s.uploadManifest(ctx, destination, manifest, object); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// exportTimeWindowStream exports the time window piping samples through the compressor straight to destination,
+// without any local file
+func (s *samplesExporter) exportTimeWindowStream(
+	ctx context.Context,
+	tsextractorClient *tsextractor.TsExtractor,
+	destination s3.API,
+	window tsextractor.TimeWindow,
+	discovery *thingDiscovery,
+	resolution int,
+	aggregationStats []string) ([]tsextractor.ThingFailure, error) {
+
+	destinationKey := s.objectKey(s.allThingsKeyValues(window))
+
+	reader, pipeWriter := io.Pipe()
+	uploaded := newChecksumReader(reader)
+	uploadResult := make(chan error, 1)
+	go func() {
+		s.logger.Infof("Streaming samples to bucket %s/%s\n", destination.DestinationBucket(), destinationKey)
+		err := destination.WriteStream(ctx, destinationKey, uploaded)
+		// Unblock extraction in case upload terminated early
+		reader.CloseWithError(err)
+		uploadResult <- err
+	}()
+
+	var out io.Writer = pipeWriter
+	var compressor *gzip.Writer
+	if s.isCompressed() {
+		compressor = gzip.NewWriter(pipeWriter)
+		out = compressor
+	}
+	stats := s.newStats()
+	err := tsextractorClient.ExportTSWindowToStream(ctx, window.From, window.To, discovery.stream(), resolution, aggregationStats, s.outputFormat, out, stats)
+	// Partially listed things are not uploaded
+	thingsMap, discoveryErr := discovery.wait()
+	if discoveryErr != nil {
+		err = discoveryErr
+	}
+	failures, err := s.errorPolicy.apply(err, len(thingsMap))
+	if err == nil && compressor != nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		// Abort upload
+		pipeWriter.CloseWithError(err)
 	} else {
-		writer.Close()
-		defer writer.Delete()
+		pipeWriter.Close()
+	}
+	uploadErr := <-uploadResult
 
-		fileToUpload := writer.GetFilePath()
-		destinationKeyFormat := "%s/%s.csv"
-		if s.compress {
-			s.logger.Infof("Compressing file: %s\n", fileToUpload)
-			compressedFile, err := utils.GzipFileCompression(fileToUpload)
-			if err != nil {
-				return err
-			}
-			fileToUpload = compressedFile
-			s.logger.Infof("Generated compressed file: %s\n", fileToUpload)
-			destinationKeyFormat = "%s/%s.csv.gz"
-			defer func(f string) { os.Remove(f) }(fileToUpload)
+	if err != nil {
+		s.logger.Error("Error aligning time series samples: ", err)
+		return nil, err
+	}
+	if uploadErr != nil {
+		return nil, uploadErr
+	}
+	manifest := s.newManifest(window, resolution, aggregationStats, thingsMap, stats, failures, allThingsIncluded)
+	if err := s.uploadManifest(ctx, destination, manifest, uploaded.object(destinationKey)); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// exportTimeWindowSplit exports the time window to one file per thing or per tag value
+func (s *samplesExporter) exportTimeWindowSplit(
+	ctx context.Context,
+	tsextractorClient *tsextractor.TsExtractor,
+	destination s3.API,
+	window tsextractor.TimeWindow,
+	discovery *thingDiscovery,
+	resolution int,
+	aggregationStats []string) ([]tsextractor.ThingFailure, error) {
+
+	// Partitions are computed from all things, so split export waits for discovery completion
+	thingsMap, err := discovery.wait()
+	if err != nil {
+		return nil, err
+	}
+	partition := s.outputSplit.PartitionFunc(thingsMap)
+	stats := s.newStats()
+	// Partition files are closed by extraction, to bound open files, but uploaded once the error policy is applied
+	writer, err := tsextractorClient.ExportTSWindowToFiles(ctx, window.From, window.To, thingsMap, resolution, aggregationStats, s.outputFormat, partition, stats)
+	defer writer.Delete()
+	failures, err := s.errorPolicy.apply(err, len(thingsMap))
+	if err != nil {
+		s.logger.Error("Error aligning time series samples: ", err)
+		return nil, err
+	}
+
+	for key, partitionWriter := range writer.Writers() {
+		values := s.allThingsKeyValues(window)
+		if s.outputSplit.ByThing {
+			values.ThingID = key
+			values.ThingName = thingsMap[key].Name
+		} else {
+			values.TagValue = key
+		}
+		object, err := s.uploadFile(ctx, destination, partitionWriter, values)
+		if err != nil {
+			return nil, err
+		}
+		inPartition := func(thingID string) bool {
+			return partition(samples.Sample{ThingID: thingID}) == key
+		}
+		manifest := s.newManifest(window, resolution, aggregationStats, thingsMap, stats, failures, inPartition)
+		if err := s.uploadManifest(ctx, destination, manifest, object); err != nil {
+			return nil, err
 		}
+	}
+	return failures, nil
+}
+
+// markPartial uploads, next to the time window data, a marker object listing things missing from exported data
+func (s *samplesExporter) markPartial(ctx context.Context, destination s3.API, window tsextractor.TimeWindow, failures []tsextractor.ThingFailure) error {
+	markerKey := s.objectKey(s.allThingsKeyValues(window)) + partialMarkerSuffix
+
+	content, err := json.MarshalIndent(partialMarker{
+		From:         window.From,
+		To:           window.To,
+		FailedThings: failures,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Marking time window as partial: %s/%s\n", destination.DestinationBucket(), markerKey)
+	return destination.WriteObject(ctx, markerKey, content)
+}
 
-		destinationKey := fmt.Sprintf(destinationKeyFormat, from.Format("2006-01-02"), from.Format("2006-01-02-15-04"))
-		s.logger.Infof("Uploading file %s to bucket %s/%s\n", fileToUpload, s3cl.DestinationBucket(), destinationKey)
-		if err := s3cl.WriteFile(ctx, destinationKey, fileToUpload); err != nil {
-			return err
+func (s *samplesExporter) allThingsKeyValues(window tsextractor.TimeWindow) keytemplate.Values {
+	return keytemplate.Values{
+		Stack:     s.stackName,
+		From:      window.From,
+		To:        window.To,
+		ThingID:   allThings,
+		ThingName: allThings,
+		TagValue:  allThings,
+	}
+}
+
+func (s *samplesExporter) uploadFile(ctx context.Context, destination s3.API, writer samples.Writer, values keytemplate.Values) (ManifestObject, error) {
+	fileToUpload := writer.GetFilePath()
+	if s.isCompressed() {
+		s.logger.Infof("Compressing file: %s\n", fileToUpload)
+		compressedFile, err := utils.GzipFileCompression(fileToUpload)
+		if err != nil {
+			return ManifestObject{}, err
 		}
+		fileToUpload = compressedFile
+		s.logger.Infof("Generated compressed file: %s\n", fileToUpload)
+		defer func(f string) { os.Remove(f) }(fileToUpload)
+	}
+
+	checksum, size, err := utils.FileChecksum(fileToUpload)
+	if err != nil {
+		return ManifestObject{}, err
+	}
+
+	destinationKey := s.objectKey(values)
+	s.logger.Infof("Uploading file %s to bucket %s/%s\n", fileToUpload, destination.DestinationBucket(), destinationKey)
+	if err := destination.WriteFile(ctx, destinationKey, fileToUpload); err != nil {
+		return ManifestObject{}, err
+	}
+	return ManifestObject{Key: destinationKey, Size: size, SHA256: checksum}, nil
+}
+
+// objectKey renders the destination key of a data object. Objects of fan-out shards are numbered, for example
+// 2024-10-01/2024-10-01-10-00.shard-0002-of-0010.csv
+func (s *samplesExporter) objectKey(values keytemplate.Values) string {
+	values.Ext = s.extension()
+	if s.shard == nil {
+		return s.keyTemplate.Render(values)
+	}
+	shard := fmt.Sprintf("shard-%04d-of-%04d", s.shard.Shard, s.shard.Shards)
+	if !s.keyTemplate.Uses(keytemplate.Ext) {
+		return s.keyTemplate.Render(values) + "." + shard
 	}
+	values.Ext = shard + "." + values.Ext
+	return s.keyTemplate.Render(values)
+}
+
+// newStats returns the stats of exported samples, counting rows as written by the output format
+func (s *samplesExporter) newStats() *samples.Stats {
+	if s.outputFormat == tsextractor.OutputFormatCSVWide {
+		return samples.NewTimestampStats()
+	}
+	return samples.NewStats()
+}
 
-	return nil
+// isCompressed reports if output files are gzip compressed. Parquet files are already compressed internally.
+func (s *samplesExporter) isCompressed() bool {
+	return s.compress && s.outputFormat != tsextractor.OutputFormatParquet
+}
+
+func (s *samplesExporter) extension() string {
+	ext := tsextractor.OutputFormatCSV
+	switch s.outputFormat {
+	case tsextractor.OutputFormatParquet:
+		return tsextractor.OutputFormatParquet
+	case tsextractor.OutputFormatJSONL:
+		ext = tsextractor.OutputFormatJSONL
+	}
+	if s.isCompressed() {
+		return ext + ".gz"
+	}
+	return ext
 }
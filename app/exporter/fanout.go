@@ -0,0 +1,244 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/arduino/aws-s3-integration/internal/s3"
+	"github.com/arduino/aws-s3-integration/internal/sqs"
+)
+
+// Default number of things exported by a fan-out worker
+const DefaultShardSize = 100
+
+// ShardMessage is enqueued by fan-out coordinators: it requests the export of a time window for a batch of things
+type ShardMessage struct {
+	// Tenant of exported things, in multi-tenant deployments
+	Tenant     string    `json:"tenant,omitempty"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Resolution int       `json:"resolution"`
+	// Shard number, from 1 to Shards
+	Shard    int      `json:"shard"`
+	Shards   int      `json:"shards"`
+	ThingIDs []string `json:"thing_ids"`
+}
+
+// ParseShardMessage parses and validates a shard message
+func ParseShardMessage(body string) (ShardMessage, error) {
+	var message ShardMessage
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		return message, fmt.Errorf("invalid shard message: %w", err)
+	}
+	if !message.From.Before(message.To) || message.Shard < 1 || message.Shard > message.Shards || len(message.ThingIDs) == 0 {
+		return message, errors.New("invalid shard message: " + body)
+	}
+	return message, nil
+}
+
+// FanOut configures fan-out executions: time windows are split in shards of things, which are enqueued
+// to be exported by parallel workers
+type FanOut struct {
+	Queue     sqs.API
+	ShardSize int
+	// Tenant name, set in shard messages of multi-tenant deployments
+	Tenant string
+}
+
+// StartCoordinator enqueues shards of last time window, or of every time window not yet enqueued when watermark is enabled.
+// Watermark tracks enqueued windows: shards failing export are retried by the queue.
+func (s *samplesExporter) StartCoordinator(
+	ctx context.Context,
+	resolution, timeWindowMinutes int,
+	destination s3.API,
+	fanOut FanOut) (*Report, error) {
+
+	report := newReport()
+	windows, watermarkStore, err := s.pendingWindows(ctx, destination, resolution, timeWindowMinutes)
+	if err == nil {
+		err = s.enqueueShards(ctx, windows, nil, resolution, fanOut, report)
+	}
+	if err == nil && watermarkStore != nil && len(windows) > 0 {
+		if err = watermarkStore.Save(ctx, windows[len(windows)-1].To); err != nil {
+			s.logger.Error("Error saving export watermark: ", err)
+		}
+	}
+	if err != nil {
+		report.Status = StatusFailed
+		return report, err
+	}
+	return report, nil
+}
+
+// StartBackfillCoordinator enqueues shards of the given [from, to] time range, split in windows of timeWindowMinutes
+func (s *samplesExporter) StartBackfillCoordinator(
+	ctx context.Context,
+	from, to time.Time,
+	thingIDs []string,
+	resolution, timeWindowMinutes int,
+	fanOut FanOut) (*Report, error) {
+
+	report := newReport()
+	windows := tsextractor.SplitTimeWindows(from, to, timeWindowMinutes)
+	s.logger.Infof("Backfilling %d time windows, from %s to %s\n", len(windows), from, to)
+	if err := s.enqueueShards(ctx, windows, thingIDs, resolution, fanOut, report); err != nil {
+		report.Status = StatusFailed
+		return report, err
+	}
+	return report, nil
+}
+
+// enqueueShards lists selected things and enqueues a message for each time window and batch of things
+func (s *samplesExporter) enqueueShards(
+	ctx context.Context,
+	windows []tsextractor.TimeWindow,
+	thingIDs []string,
+	resolution int,
+	fanOut FanOut,
+	report *Report) error {
+
+	if len(windows) == 0 {
+		s.logger.Infoln("No complete time window to export")
+		return nil
+	}
+	s.logger.Infoln("Listing things, selection:", s.selector.String())
+	ids, err := s.selector.ListIDs(ctx, s.iotClient, thingIDs)
+	if err != nil {
+		return err
+	}
+	report.Things = len(ids)
+	if len(ids) == 0 {
+		s.logger.Warn("No things to export")
+		return nil
+	}
+	slices.Sort(ids)
+
+	shardSize := fanOut.ShardSize
+	if shardSize <= 0 {
+		shardSize = DefaultShardSize
+	}
+	shards := (len(ids) + shardSize - 1) / shardSize
+	messages := make([]string, 0, len(windows)*shards)
+	for _, window := range windows {
+		for shard := 0; shard < shards; shard++ {
+			content, err := json.Marshal(ShardMessage{
+				Tenant:     fanOut.Tenant,
+				From:       window.From,
+				To:         window.To,
+				Resolution: resolution,
+				Shard:      shard + 1,
+				Shards:     shards,
+				ThingIDs:   ids[shard*shardSize : min((shard+1)*shardSize, len(ids))],
+			})
+			if err != nil {
+				return err
+			}
+			messages = append(messages, string(content))
+		}
+	}
+	s.logger.Infof("Enqueuing %d shards of %d things, for %d time windows\n", len(messages), shardSize, len(windows))
+	if err := fanOut.Queue.SendMessages(ctx, messages); err != nil {
+		return err
+	}
+	report.EnqueuedShards = len(messages)
+	return nil
+}
+
+// ExportShard exports the time window of a shard for its things, to objects numbered after the shard.
+// When all shards of the time window are exported, the time window manifest is uploaded, listing objects of every shard.
+func (s *samplesExporter) ExportShard(
+	ctx context.Context,
+	message ShardMessage,
+	destination s3.API,
+	aggregationStats []string) (*Report, error) {
+
+	if s.outputSplit.IsEnabled() {
+		return nil, errors.New("output split is not supported by fan-out executions")
+	}
+	shardExporter := *s
+	shardExporter.shard = &message
+	shardExporter.logger = s.logger.WithField("shard", fmt.Sprintf("%d/%d", message.Shard, message.Shards))
+
+	report := newReport()
+	discovery := shardExporter.discoverThings(ctx, message.ThingIDs)
+	defer func() { report.Things = discovery.stop() }()
+
+	tsextractorClient := tsextractor.New(s.iotClient, shardExporter.logger, s.propertyRules, s.propertyFilter != nil, s.expandComplexValues)
+	window := tsextractor.TimeWindow{From: message.From, To: message.To}
+	failures, err := shardExporter.exportTimeWindow(ctx, tsextractorClient, destination, window, discovery, message.Resolution, aggregationStats)
+	if err != nil {
+		report.fail(window, err)
+		return report, err
+	}
+	report.addWindow(window, failures)
+
+	if err := s.mergeShardManifests(ctx, destination, message); err != nil {
+		report.Status = StatusFailed
+		return report, err
+	}
+	return report, nil
+}
+
+// mergeShardManifests is the final step of fan-out executions. When manifests of all shards of the time window
+// are uploaded, it uploads the manifest of the time window, listing things and objects of every shard.
+// Shards are checked by every worker after its export, so that the last completed shard uploads the manifest.
+func (s *samplesExporter) mergeShardManifests(ctx context.Context, destination s3.API, message ShardMessage) error {
+	values := s.allThingsKeyValues(tsextractor.TimeWindow{From: message.From, To: message.To})
+
+	var merged *Manifest
+	for shard := 1; shard <= message.Shards; shard++ {
+		shardExporter := *s
+		shardExporter.shard = &ShardMessage{Shard: shard, Shards: message.Shards}
+		content, err := destination.ReadObject(ctx, shardExporter.objectKey(values)+manifestSuffix)
+		if errors.Is(err, s3.ErrObjectNotFound) {
+			s.logger.Infof("Shard %d/%d not exported yet, time window manifest is uploaded by last exported shard\n", shard, message.Shards)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		manifest := &Manifest{}
+		if err := json.Unmarshal(content, manifest); err != nil {
+			return fmt.Errorf("invalid manifest of shard %d: %w", shard, err)
+		}
+		if merged == nil {
+			merged = manifest
+			continue
+		}
+		merged.Complete = merged.Complete && manifest.Complete
+		merged.Things = append(merged.Things, manifest.Things...)
+		merged.SkippedThings = append(merged.SkippedThings, manifest.SkippedThings...)
+		merged.Objects = append(merged.Objects, manifest.Objects...)
+	}
+	slices.SortFunc(merged.Things, func(a, b ManifestThing) int { return strings.Compare(a.ThingID, b.ThingID) })
+	slices.SortFunc(merged.SkippedThings, func(a, b SkippedThing) int { return strings.Compare(a.ThingID, b.ThingID) })
+
+	content, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestKey := s.objectKey(values) + manifestSuffix
+	s.logger.Infof("All %d shards exported, uploading time window manifest to bucket %s/%s\n", message.Shards, destination.DestinationBucket(), manifestKey)
+	return destination.WriteObject(ctx, manifestKey, content)
+}
@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	iotMocks "github.com/arduino/aws-s3-integration/internal/iot/mocks"
+	"github.com/arduino/aws-s3-integration/internal/keytemplate"
+	"github.com/arduino/aws-s3-integration/internal/localfs"
+	"github.com/arduino/aws-s3-integration/internal/sqs"
+	iotclient "github.com/arduino/iot-client-go/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseShardMessage(t *testing.T) {
+	message, err := ParseShardMessage(`{"from": "2024-10-01T10:00:00Z", "to": "2024-10-01T11:00:00Z", "resolution": 300, "shard": 2, "shards": 3, "thing_ids": ["t1"]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, message.Shard)
+	assert.Equal(t, []string{"t1"}, message.ThingIDs)
+
+	for _, body := range []string{
+		`not json`,
+		`{"from": "2024-10-01T11:00:00Z", "to": "2024-10-01T10:00:00Z", "shard": 1, "shards": 1, "thing_ids": ["t1"]}`,
+		`{"from": "2024-10-01T10:00:00Z", "to": "2024-10-01T11:00:00Z", "shard": 4, "shards": 3, "thing_ids": ["t1"]}`,
+		`{"from": "2024-10-01T10:00:00Z", "to": "2024-10-01T11:00:00Z", "shard": 1, "shards": 1, "thing_ids": []}`,
+	} {
+		_, err := ParseShardMessage(body)
+		assert.Error(t, err, body)
+	}
+}
+
+func TestFanOut_coordinatorAndWorkers(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.NewEntry(logrus.New())
+	from := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	property := []iotclient.ArduinoProperty{{Id: "p1", Name: "temperature", Type: "FLOAT"}}
+	thingA := iotclient.ArduinoThing{Id: "a", Name: "thing-a", Properties: property}
+	thingB := iotclient.ArduinoThing{Id: "b", Name: "thing-b"}
+	thingC := iotclient.ArduinoThing{Id: "c", Name: "thing-c", Properties: property}
+
+	iotcl := iotMocks.NewAPI(t)
+	// Coordinator lists thing IDs only
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return([]iotclient.ArduinoThing{{Id: "c"}, {Id: "a"}, {Id: "b"}}, false, nil).Once()
+	// Workers discover things of their shard
+	iotcl.On("ThingList", mock.Anything, []string{"a", "b"}, (*string)(nil), false, map[string]string(nil)).Return([]iotclient.ArduinoThing{{Id: "a"}, {Id: "b"}}, false, nil)
+	iotcl.On("ThingList", mock.Anything, []string{"a", "b"}, (*string)(nil), true, map[string]string(nil)).Return([]iotclient.ArduinoThing{thingA, thingB}, false, nil)
+	iotcl.On("ThingList", mock.Anything, []string{"c"}, (*string)(nil), false, map[string]string(nil)).Return([]iotclient.ArduinoThing{{Id: "c"}}, false, nil)
+	iotcl.On("ThingList", mock.Anything, []string{"c"}, (*string)(nil), true, map[string]string(nil)).Return([]iotclient.ArduinoThing{thingC}, false, nil)
+	for _, thingID := range []string{"a", "c"} {
+		iotcl.On("GetTimeSeriesByThing", mock.Anything, thingID, from, to, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+			Responses: []iotclient.ArduinoSeriesResponse{
+				{Query: "property.p1", Times: []time.Time{from}, Values: []float64{20}, CountValues: 1},
+			},
+		}, false, nil)
+	}
+
+	keyTemplate, err := keytemplate.Parse(keytemplate.PresetDefault)
+	assert.NoError(t, err)
+	exporter := &samplesExporter{
+		iotClient:    iotcl,
+		logger:       logger,
+		outputFormat: tsextractor.OutputFormatCSV,
+		keyTemplate:  keyTemplate,
+		errorPolicy:  ErrorPolicy{Mode: ErrorPolicyFail},
+	}
+	outputDir := t.TempDir()
+	destination, err := localfs.NewDirectory(outputDir)
+	assert.NoError(t, err)
+
+	queue := sqs.NewMemoryQueue()
+	report, err := exporter.StartBackfillCoordinator(ctx, from, to, nil, 300, 60, FanOut{Queue: queue, ShardSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Things)
+	assert.Equal(t, 2, report.EnqueuedShards)
+	assert.Equal(t, 2, queue.Len())
+
+	windowManifest := filepath.Join(outputDir, filepath.FromSlash(exporter.objectKey(exporter.allThingsKeyValues(tsextractor.TimeWindow{From: from, To: to}))+manifestSuffix))
+	for i, record := range queue.Receive(10) {
+		message, err := ParseShardMessage(record.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, message.Shard)
+
+		report, err := exporter.ExportShard(ctx, message, destination, []string{"AVG"})
+		assert.NoError(t, err)
+		assert.Equal(t, StatusSuccess, report.Status)
+		assert.Equal(t, 1, report.ExportedWindows)
+
+		// Time window manifest is uploaded by last exported shard
+		_, err = os.Stat(windowManifest)
+		assert.Equal(t, i == 1, err == nil)
+	}
+
+	content, err := os.ReadFile(windowManifest)
+	assert.NoError(t, err)
+	manifest := Manifest{}
+	assert.NoError(t, json.Unmarshal(content, &manifest))
+	assert.True(t, manifest.Complete)
+	assert.Equal(t, []ManifestThing{
+		{ThingID: "a", ThingName: "thing-a", PropertyIDs: []string{"p1"}, Rows: 1},
+		{ThingID: "c", ThingName: "thing-c", PropertyIDs: []string{"p1"}, Rows: 1},
+	}, manifest.Things)
+	assert.Equal(t, []SkippedThing{{ThingID: "b", ThingName: "thing-b", Reason: skippedNoProperties}}, manifest.SkippedThings)
+	assert.Len(t, manifest.Objects, 2)
+	assert.Contains(t, manifest.Objects[0].Key, ".shard-0001-of-0002.csv")
+	assert.Contains(t, manifest.Objects[1].Key, ".shard-0002-of-0002.csv")
+	for _, object := range manifest.Objects {
+		assert.FileExists(t, filepath.Join(outputDir, filepath.FromSlash(object.Key)))
+	}
+}
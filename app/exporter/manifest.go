@@ -0,0 +1,163 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/arduino/aws-s3-integration/internal/s3"
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/arduino/aws-s3-integration/version"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+// Suffix of the manifest object uploaded next to every data object
+const manifestSuffix = ".manifest.json"
+
+const skippedNoProperties = "thing has no properties"
+
+// Manifest describes the content of an exported data object, so that loaders can check completeness before ingesting it
+type Manifest struct {
+	ExporterVersion   string           `json:"exporter_version"`
+	From              time.Time        `json:"from"`
+	To                time.Time        `json:"to"`
+	ResolutionSeconds int              `json:"resolution_seconds,omitempty"`
+	Aggregations      []string         `json:"aggregations"`
+	Format            string           `json:"format"`
+	Complete          bool             `json:"complete"`
+	Things            []ManifestThing  `json:"things"`
+	SkippedThings     []SkippedThing   `json:"skipped_things,omitempty"`
+	Objects           []ManifestObject `json:"objects"`
+}
+
+type ManifestThing struct {
+	ThingID     string   `json:"thing_id"`
+	ThingName   string   `json:"thing_name"`
+	PropertyIDs []string `json:"property_ids"`
+	Rows        int      `json:"rows"`
+}
+
+type SkippedThing struct {
+	ThingID   string `json:"thing_id"`
+	ThingName string `json:"thing_name"`
+	Reason    string `json:"reason"`
+}
+
+type ManifestObject struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// newManifest builds the manifest of an exported object, including things accepted by the include function
+func (s *samplesExporter) newManifest(
+	window tsextractor.TimeWindow,
+	resolution int,
+	aggregationStats []string,
+	thingsMap map[string]iotclient.ArduinoThing,
+	stats *samples.Stats,
+	failures []tsextractor.ThingFailure,
+	include func(thingID string) bool) *Manifest {
+
+	manifest := &Manifest{
+		ExporterVersion: version.Version(),
+		From:            window.From,
+		To:              window.To,
+		Aggregations:    s.propertyRules.Statistics(aggregationStats),
+		Format:          s.outputFormat,
+		Complete:        true,
+		Things:          []ManifestThing{},
+	}
+	if resolution > 0 {
+		manifest.ResolutionSeconds = resolution
+	} else {
+		manifest.Aggregations = []string{"raw"}
+	}
+
+	failed := make(map[string]string, len(failures))
+	for _, failure := range failures {
+		failed[failure.ThingID] = failure.Error
+	}
+	for id, thing := range thingsMap {
+		if !include(id) {
+			continue
+		}
+		if reason, ok := failed[id]; ok {
+			manifest.Complete = false
+			manifest.SkippedThings = append(manifest.SkippedThings, SkippedThing{ThingID: id, ThingName: thing.Name, Reason: reason})
+			continue
+		}
+		if len(thing.Properties) == 0 {
+			manifest.SkippedThings = append(manifest.SkippedThings, SkippedThing{ThingID: id, ThingName: thing.Name, Reason: skippedNoProperties})
+			continue
+		}
+		rows, propertyIDs := stats.Thing(id)
+		if propertyIDs == nil {
+			propertyIDs = []string{}
+		}
+		manifest.Things = append(manifest.Things, ManifestThing{ThingID: id, ThingName: thing.Name, PropertyIDs: propertyIDs, Rows: rows})
+	}
+	slices.SortFunc(manifest.Things, func(a, b ManifestThing) int { return strings.Compare(a.ThingID, b.ThingID) })
+	slices.SortFunc(manifest.SkippedThings, func(a, b SkippedThing) int { return strings.Compare(a.ThingID, b.ThingID) })
+	return manifest
+}
+
+// uploadManifest uploads the manifest of the given data object next to it
+func (s *samplesExporter) uploadManifest(ctx context.Context, destination s3.API, manifest *Manifest, object ManifestObject) error {
+	manifest.Objects = []ManifestObject{object}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestKey := object.Key + manifestSuffix
+	s.logger.Infof("Uploading manifest to bucket %s/%s\n", destination.DestinationBucket(), manifestKey)
+	return destination.WriteObject(ctx, manifestKey, content)
+}
+
+func allThingsIncluded(string) bool {
+	return true
+}
+
+// checksumReader computes size and checksum of streamed content
+type checksumReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func newChecksumReader(reader io.Reader) *checksumReader {
+	return &checksumReader{reader: reader, hash: sha256.New()}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.hash.Write(p[:n])
+	c.size += int64(n)
+	return n, err
+}
+
+func (c *checksumReader) object(key string) ManifestObject {
+	return ManifestObject{Key: key, Size: c.size, SHA256: hex.EncodeToString(c.hash.Sum(nil))}
+}
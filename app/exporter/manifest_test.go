@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	iotclient "github.com/arduino/iot-client-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest_content(t *testing.T) {
+	exporter := &samplesExporter{outputFormat: tsextractor.OutputFormatCSV}
+	window := tsextractor.TimeWindow{
+		From: time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC),
+	}
+	property := []iotclient.ArduinoProperty{{Id: "p1", Name: "temperature", Type: "FLOAT"}}
+	thingsMap := map[string]iotclient.ArduinoThing{
+		"t1": {Id: "t1", Name: "thing1", Properties: property},
+		"t2": {Id: "t2", Name: "thing2", Properties: property},
+		"t3": {Id: "t3", Name: "thing3"},
+		"t4": {Id: "t4", Name: "thing4", Properties: property},
+	}
+
+	stats := samples.NewStats()
+	assert.NoError(t, stats.Write([]samples.Sample{
+		{ThingID: "t1", PropertyID: "p2"},
+		{ThingID: "t1", PropertyID: "p1"},
+		{ThingID: "t1", PropertyID: "p1"},
+	}))
+	failures := []tsextractor.ThingFailure{{ThingID: "t2", ThingName: "thing2", Error: "rate limited"}}
+
+	manifest := exporter.newManifest(window, 300, []string{"AVG"}, thingsMap, stats, failures, allThingsIncluded)
+	assert.Equal(t, window.From, manifest.From)
+	assert.Equal(t, window.To, manifest.To)
+	assert.Equal(t, 300, manifest.ResolutionSeconds)
+	assert.Equal(t, []string{"AVG"}, manifest.Aggregations)
+	assert.Equal(t, "csv", manifest.Format)
+	assert.NotEmpty(t, manifest.ExporterVersion)
+	assert.False(t, manifest.Complete)
+	assert.Equal(t, []ManifestThing{
+		{ThingID: "t1", ThingName: "thing1", PropertyIDs: []string{"p1", "p2"}, Rows: 3},
+		{ThingID: "t4", ThingName: "thing4", PropertyIDs: []string{}, Rows: 0},
+	}, manifest.Things)
+	assert.Equal(t, []SkippedThing{
+		{ThingID: "t2", ThingName: "thing2", Reason: "rate limited"},
+		{ThingID: "t3", ThingName: "thing3", Reason: skippedNoProperties},
+	}, manifest.SkippedThings)
+
+	// Raw data, single partition
+	manifest = exporter.newManifest(window, -1, nil, thingsMap, stats, nil, func(thingID string) bool { return thingID == "t1" })
+	assert.True(t, manifest.Complete)
+	assert.Equal(t, []string{"raw"}, manifest.Aggregations)
+	assert.Zero(t, manifest.ResolutionSeconds)
+	assert.Len(t, manifest.Things, 1)
+	assert.Empty(t, manifest.SkippedThings)
+}
+
+func TestManifest_wideRows(t *testing.T) {
+	exporter := &samplesExporter{outputFormat: tsextractor.OutputFormatCSVWide}
+	window := tsextractor.TimeWindow{
+		From: time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC),
+	}
+	thingsMap := map[string]iotclient.ArduinoThing{
+		"t1": {Id: "t1", Name: "thing1", Properties: []iotclient.ArduinoProperty{{Id: "p1"}, {Id: "p2"}}},
+	}
+
+	// Samples of the same timestamp are written in a single wide row
+	stats := exporter.newStats()
+	assert.NoError(t, stats.Write([]samples.Sample{
+		{ThingID: "t1", PropertyID: "p1", Time: window.From},
+		{ThingID: "t1", PropertyID: "p2", Time: window.From},
+		{ThingID: "t1", PropertyID: "p1", Time: window.From.Add(5 * time.Minute)},
+	}))
+	manifest := exporter.newManifest(window, 300, []string{"AVG"}, thingsMap, stats, nil, allThingsIncluded)
+	assert.Equal(t, []ManifestThing{{ThingID: "t1", ThingName: "thing1", PropertyIDs: []string{"p1", "p2"}, Rows: 2}}, manifest.Things)
+}
+
+func TestManifest_streamChecksum(t *testing.T) {
+	content := strings.Repeat("timestamp,thing_id\n", 1000)
+	reader := newChecksumReader(strings.NewReader(content))
+	_, err := io.Copy(io.Discard, reader)
+	assert.NoError(t, err)
+
+	expected := sha256.Sum256([]byte(content))
+	object := reader.object("2024-10-01/2024-10-01-10-00.csv")
+	assert.Equal(t, "2024-10-01/2024-10-01-10-00.csv", object.Key)
+	assert.Equal(t, int64(len(content)), object.Size)
+	assert.Equal(t, hex.EncodeToString(expected[:]), object.SHA256)
+}
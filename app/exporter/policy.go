@@ -0,0 +1,118 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+)
+
+const (
+	// ErrorPolicyFail fails the whole run if any thing fails. Nothing is uploaded for the time window.
+	ErrorPolicyFail = "fail"
+	// ErrorPolicyPartial uploads samples of the other things, marking the time window as partial.
+	ErrorPolicyPartial = "partial"
+	// ErrorPolicySkip skips failing things, as long as their number does not exceed the configured threshold.
+	ErrorPolicySkip = "skip"
+)
+
+// ErrorPolicy defines how failures of single things affect the export
+type ErrorPolicy struct {
+	Mode string
+	// Max number of failed things tolerated by skip policy
+	MaxFailedThings int
+	// Max percentage of failed things tolerated by skip policy, used when greater than 0
+	MaxFailedPercent float64
+}
+
+// ParseErrorPolicy parses error policy mode (fail, partial, skip) and, for skip policy, the required threshold
+// of tolerated failures, as number of things (for example 5) or percentage of exported things (for example 10%)
+func ParseErrorPolicy(mode, threshold string) (ErrorPolicy, error) {
+	mode = strings.TrimSpace(mode)
+	threshold = strings.TrimSpace(threshold)
+	switch mode {
+	case "", ErrorPolicyFail:
+		return ErrorPolicy{Mode: ErrorPolicyFail}, nil
+	case ErrorPolicyPartial:
+		return ErrorPolicy{Mode: ErrorPolicyPartial}, nil
+	case ErrorPolicySkip:
+		policy := ErrorPolicy{Mode: ErrorPolicySkip}
+		if threshold == "" {
+			return ErrorPolicy{}, errors.New("skip error policy requires a failed things threshold")
+		}
+		if percent, ok := strings.CutSuffix(threshold, "%"); ok {
+			value, err := strconv.ParseFloat(percent, 64)
+			if err != nil || value < 0 || value > 100 {
+				return ErrorPolicy{}, fmt.Errorf("invalid failed things threshold: %s", threshold)
+			}
+			policy.MaxFailedPercent = value
+			return policy, nil
+		}
+		value, err := strconv.Atoi(threshold)
+		if err != nil || value < 0 {
+			return ErrorPolicy{}, fmt.Errorf("invalid failed things threshold: %s", threshold)
+		}
+		policy.MaxFailedThings = value
+		return policy, nil
+	}
+	return ErrorPolicy{}, fmt.Errorf("invalid error policy: %s. Supported values: fail, partial, skip", mode)
+}
+
+func (p ErrorPolicy) String() string {
+	if p.Mode == "" {
+		return ErrorPolicyFail
+	}
+	if p.Mode != ErrorPolicySkip {
+		return p.Mode
+	}
+	if p.MaxFailedPercent > 0 {
+		return fmt.Sprintf("%s (max %s%% failed things)", p.Mode, strconv.FormatFloat(p.MaxFailedPercent, 'f', -1, 64))
+	}
+	return fmt.Sprintf("%s (max %d failed things)", p.Mode, p.MaxFailedThings)
+}
+
+// apply checks the extraction result against the policy. When export can go on, it returns failed things to report.
+func (p ErrorPolicy) apply(err error, thingsCount int) ([]tsextractor.ThingFailure, error) {
+	if err == nil {
+		return nil, nil
+	}
+	var exportErr *tsextractor.ExportError
+	if !errors.As(err, &exportErr) {
+		return nil, err
+	}
+	switch p.Mode {
+	case ErrorPolicyPartial:
+		return exportErr.Failures, nil
+	case ErrorPolicySkip:
+		if failed, max := len(exportErr.Failures), p.maxFailures(thingsCount); failed > max {
+			return nil, fmt.Errorf("%d failed things exceed threshold of %d: %w", failed, max, err)
+		}
+		return exportErr.Failures, nil
+	}
+	return nil, err
+}
+
+func (p ErrorPolicy) maxFailures(thingsCount int) int {
+	if p.MaxFailedPercent > 0 {
+		return int(math.Floor(float64(thingsCount) * p.MaxFailedPercent / 100))
+	}
+	return p.MaxFailedThings
+}
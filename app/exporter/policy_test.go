@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	policy, err := ParseErrorPolicy("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ErrorPolicyFail, policy.Mode)
+
+	policy, err = ParseErrorPolicy("partial", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ErrorPolicyPartial, policy.Mode)
+
+	policy, err = ParseErrorPolicy("skip", "3")
+	assert.NoError(t, err)
+	assert.Equal(t, ErrorPolicy{Mode: ErrorPolicySkip, MaxFailedThings: 3}, policy)
+
+	policy, err = ParseErrorPolicy("skip", "12.5%")
+	assert.NoError(t, err)
+	assert.Equal(t, ErrorPolicy{Mode: ErrorPolicySkip, MaxFailedPercent: 12.5}, policy)
+
+	// Skip policy without threshold would behave as fail policy
+	_, err = ParseErrorPolicy("skip", "")
+	assert.EqualError(t, err, "skip error policy requires a failed things threshold")
+
+	for _, threshold := range []string{"-1", "abc", "120%"} {
+		_, err = ParseErrorPolicy("skip", threshold)
+		assert.Error(t, err, threshold)
+	}
+	_, err = ParseErrorPolicy("ignore", "")
+	assert.Error(t, err)
+}
+
+func TestErrorPolicy_apply(t *testing.T) {
+	failures := []tsextractor.ThingFailure{
+		{ThingID: "t1", ThingName: "thing1", Error: "boom"},
+		{ThingID: "t2", ThingName: "thing2", Error: "boom"},
+	}
+	exportErr := &tsextractor.ExportError{Failures: failures}
+
+	// Generic errors always fail the run
+	genericErr := errors.New("disk full")
+	for _, policy := range []ErrorPolicy{{Mode: ErrorPolicyFail}, {Mode: ErrorPolicyPartial}, {Mode: ErrorPolicySkip, MaxFailedThings: 10}} {
+		_, err := policy.apply(genericErr, 10)
+		assert.ErrorIs(t, err, genericErr)
+
+		reported, err := policy.apply(nil, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, reported)
+	}
+
+	_, err := ErrorPolicy{Mode: ErrorPolicyFail}.apply(exportErr, 10)
+	assert.ErrorIs(t, err, exportErr)
+
+	reported, err := ErrorPolicy{Mode: ErrorPolicyPartial}.apply(exportErr, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, failures, reported)
+
+	reported, err = ErrorPolicy{Mode: ErrorPolicySkip, MaxFailedThings: 2}.apply(exportErr, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, failures, reported)
+
+	_, err = ErrorPolicy{Mode: ErrorPolicySkip, MaxFailedThings: 1}.apply(exportErr, 10)
+	assert.ErrorIs(t, err, exportErr)
+
+	reported, err = ErrorPolicy{Mode: ErrorPolicySkip, MaxFailedPercent: 20}.apply(exportErr, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, failures, reported)
+
+	_, err = ErrorPolicy{Mode: ErrorPolicySkip, MaxFailedPercent: 10}.apply(exportErr, 10)
+	assert.Error(t, err)
+}
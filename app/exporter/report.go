@@ -0,0 +1,84 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"errors"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusPartial = "partial"
+	StatusFailed  = "failed"
+)
+
+// Report summarizes an export run
+type Report struct {
+	Status          string        `json:"status"`
+	ExportedWindows int           `json:"exported_windows"`
+	Things          int           `json:"things"`
+	FailedThings    []FailedThing `json:"failed_things,omitempty"`
+	// Shards enqueued by fan-out coordinators
+	EnqueuedShards int `json:"enqueued_shards,omitempty"`
+}
+
+// FailedThing is a thing whose samples are missing from the exported time window
+type FailedThing struct {
+	tsextractor.ThingFailure
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+func newReport() *Report {
+	return &Report{Status: StatusSuccess}
+}
+
+func (r *Report) addWindow(window tsextractor.TimeWindow, failures []tsextractor.ThingFailure) {
+	r.ExportedWindows++
+	r.addFailures(window, failures)
+	if len(failures) > 0 {
+		r.Status = StatusPartial
+	}
+}
+
+func (r *Report) addFailures(window tsextractor.TimeWindow, failures []tsextractor.ThingFailure) {
+	for _, failure := range failures {
+		r.FailedThings = append(r.FailedThings, FailedThing{ThingFailure: failure, From: window.From, To: window.To})
+	}
+}
+
+// Merge adds exported windows, things and failures of another run, keeping the worst status
+func (r *Report) Merge(other *Report) {
+	r.ExportedWindows += other.ExportedWindows
+	r.Things += other.Things
+	r.EnqueuedShards += other.EnqueuedShards
+	r.FailedThings = append(r.FailedThings, other.FailedThings...)
+	if other.Status == StatusFailed || (other.Status == StatusPartial && r.Status == StatusSuccess) {
+		r.Status = other.Status
+	}
+}
+
+// fail marks the run as failed, reporting failed things of the given window, if any
+func (r *Report) fail(window tsextractor.TimeWindow, err error) {
+	r.Status = StatusFailed
+	var exportErr *tsextractor.ExportError
+	if errors.As(err, &exportErr) {
+		r.addFailures(window, exportErr.Failures)
+	}
+}
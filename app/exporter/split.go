@@ -0,0 +1,97 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arduino/aws-s3-integration/internal/keytemplate"
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+const (
+	splitByThing     = "thing"
+	splitByTagPrefix = "tag:"
+
+	// Partition of things without the split tag
+	untaggedPartition = "untagged"
+)
+
+// OutputSplit defines how samples of a time window are split in multiple files.
+// By default, a single file contains samples of all things.
+type OutputSplit struct {
+	ByThing bool
+	TagKey  string
+}
+
+// ParseOutputSplit parses the split configuration: empty or 'none', 'thing' or 'tag:<tag key>'
+func ParseOutputSplit(value string) (OutputSplit, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "" || value == "none":
+		return OutputSplit{}, nil
+	case value == splitByThing:
+		return OutputSplit{ByThing: true}, nil
+	case strings.HasPrefix(value, splitByTagPrefix) && len(value) > len(splitByTagPrefix):
+		return OutputSplit{TagKey: strings.TrimPrefix(value, splitByTagPrefix)}, nil
+	}
+	return OutputSplit{}, fmt.Errorf("invalid output split: %s. Supported values: none, thing, tag:<key>", value)
+}
+
+func (o OutputSplit) IsEnabled() bool {
+	return o.ByThing || o.TagKey != ""
+}
+
+func (o OutputSplit) String() string {
+	switch {
+	case o.ByThing:
+		return splitByThing
+	case o.TagKey != "":
+		return splitByTagPrefix + o.TagKey
+	}
+	return "none"
+}
+
+// validateKeyTemplate checks that split files get distinct keys
+func (o OutputSplit) validateKeyTemplate(keyTemplate *keytemplate.Template) error {
+	// Thing names are not unique, so files of things with the same name would overwrite each other
+	if o.ByThing && !keyTemplate.Uses(keytemplate.ThingID) {
+		return errors.New("key template must contain {thing_id} when output is split by thing")
+	}
+	if o.TagKey != "" && !keyTemplate.Uses(keytemplate.TagValue) {
+		return errors.New("key template must contain {tag_value} when output is split by tag")
+	}
+	return nil
+}
+
+func (o OutputSplit) PartitionFunc(thingsMap map[string]iotclient.ArduinoThing) samples.PartitionFunc {
+	if o.ByThing {
+		return func(sample samples.Sample) string {
+			return sample.ThingID
+		}
+	}
+	return func(sample samples.Sample) string {
+		if value, ok := thingsMap[sample.ThingID].Tags[o.TagKey]; ok && value != nil {
+			if tagValue := fmt.Sprintf("%v", value); tagValue != "" {
+				return tagValue
+			}
+		}
+		return untaggedPartition
+	}
+}
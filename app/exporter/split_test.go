@@ -0,0 +1,27 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/arduino/aws-s3-integration/internal/keytemplate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputSplit_validateKeyTemplate(t *testing.T) {
+	parse := func(template string) *keytemplate.Template {
+		parsed, err := keytemplate.Parse(template)
+		assert.NoError(t, err)
+		return parsed
+	}
+	byThing := OutputSplit{ByThing: true}
+	assert.NoError(t, byThing.validateKeyTemplate(parse("{date}/{thing_id}/{from}.{ext}")))
+	assert.NoError(t, byThing.validateKeyTemplate(parse("{date}/{thing_name}-{thing_id}/{from}.{ext}")))
+	// Things with the same name would overwrite each other files
+	assert.EqualError(t, byThing.validateKeyTemplate(parse("{date}/{thing_name}/{from}.{ext}")), "key template must contain {thing_id} when output is split by thing")
+
+	byTag := OutputSplit{TagKey: "site"}
+	assert.NoError(t, byTag.validateKeyTemplate(parse("{date}/{tag_value}/{from}.{ext}")))
+	assert.EqualError(t, byTag.validateKeyTemplate(parse("{date}/{from}.{ext}")), "key template must contain {tag_value} when output is split by tag")
+
+	assert.NoError(t, OutputSplit{}.validateKeyTemplate(parse(keytemplate.PresetDefault)))
+}
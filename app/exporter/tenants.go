@@ -0,0 +1,147 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var tenantNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Tenant is an Arduino Cloud organization exported by a multi-tenant deployment
+type Tenant struct {
+	Name      string `json:"name"`
+	ApiKey    string `json:"api-key"`
+	ApiSecret string `json:"api-secret"`
+	OrgID     string `json:"org-id,omitempty"`
+	// Tags filter of exported things, with the same syntax of tags parameter
+	Tags string `json:"tags,omitempty"`
+	// Destination bucket, when different from the default one
+	DestinationBucket string `json:"destination-bucket,omitempty"`
+	// Prefix of object keys, defaulting to tenant name
+	DestinationPrefix string `json:"destination-prefix,omitempty"`
+}
+
+// ParseTenants parses a JSON list of tenant profiles, for example:
+//
+//	[{"name": "acme", "api-key": "<key>", "api-secret": "<secret>", "org-id": "<org>", "tags": "site=turin"}]
+//
+// Tenants are identified by a unique name. Objects of each tenant are stored under its destination prefix, defaulting to its name.
+func ParseTenants(value string) ([]Tenant, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal([]byte(value), &tenants); err != nil {
+		return nil, fmt.Errorf("invalid tenants: %w", err)
+	}
+	names := map[string]bool{}
+	for i := range tenants {
+		tenant := &tenants[i]
+		if !tenantNameRegexp.MatchString(tenant.Name) {
+			return nil, fmt.Errorf("invalid tenant name %q: letters, digits, '-' and '_' are allowed", tenant.Name)
+		}
+		if names[tenant.Name] {
+			return nil, fmt.Errorf("duplicated tenant: %s", tenant.Name)
+		}
+		names[tenant.Name] = true
+		if tenant.ApiKey == "" || tenant.ApiSecret == "" {
+			return nil, fmt.Errorf("tenant %s: api-key and api-secret are required", tenant.Name)
+		}
+		tenant.DestinationPrefix = strings.Trim(tenant.DestinationPrefix, "/")
+		if tenant.DestinationPrefix == "" {
+			tenant.DestinationPrefix = tenant.Name
+		}
+	}
+	return tenants, nil
+}
+
+// TenantReport reports export of a single tenant
+type TenantReport struct {
+	Tenant string `json:"tenant"`
+	*Report
+	Error string `json:"error,omitempty"`
+}
+
+// TenantsReport summarizes export of all tenants
+type TenantsReport struct {
+	*Report
+	Tenants []TenantReport `json:"tenants"`
+}
+
+// FailedTenants returns names of tenants whose export failed
+func (r *TenantsReport) FailedTenants() []string {
+	failed := []string{}
+	for _, tenant := range r.Tenants {
+		if tenant.Status == StatusFailed {
+			failed = append(failed, tenant.Tenant)
+		}
+	}
+	return failed
+}
+
+// TenantExport exports data of a tenant
+type TenantExport func(ctx context.Context, tenant Tenant, logger *logrus.Entry) (*Report, error)
+
+// ExportTenants exports tenants one after the other. Failures are isolated: a failed tenant does not stop export
+// of the others and it is reported with its error. Returned error lists failed tenants, if any.
+// Overall status is failed when all tenants fail, partial when some of them fail or are exported partially.
+func ExportTenants(ctx context.Context, tenants []Tenant, export TenantExport, logger *logrus.Entry) (*TenantsReport, error) {
+	report := &TenantsReport{Report: newReport(), Tenants: make([]TenantReport, 0, len(tenants))}
+	for _, tenant := range tenants {
+		tenantLogger := logger.WithField("tenant", tenant.Name)
+		tenantLogger.Infoln("------ Exporting tenant", tenant.Name)
+
+		tenantReport := TenantReport{Tenant: tenant.Name}
+		var err error
+		if err = ctx.Err(); err == nil {
+			tenantReport.Report, err = export(ctx, tenant, tenantLogger)
+		}
+		if tenantReport.Report == nil {
+			tenantReport.Report = newReport()
+		}
+		if err != nil {
+			tenantLogger.Error("Error exporting tenant: ", err)
+			tenantReport.Status = StatusFailed
+			tenantReport.Error = err.Error()
+		}
+		report.ExportedWindows += tenantReport.ExportedWindows
+		report.Things += tenantReport.Things
+		report.Tenants = append(report.Tenants, tenantReport)
+	}
+
+	failed := report.FailedTenants()
+	for _, tenant := range report.Tenants {
+		if tenant.Status != StatusSuccess {
+			report.Status = StatusPartial
+		}
+	}
+	if len(failed) > 0 && len(failed) == len(report.Tenants) {
+		report.Status = StatusFailed
+	}
+	if len(failed) > 0 {
+		return report, errors.New("export failed for tenants: " + strings.Join(failed, ", "))
+	}
+	return report, nil
+}
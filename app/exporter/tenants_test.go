@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTenants(t *testing.T) {
+	tenants, err := ParseTenants(`[
+		{"name": "acme", "api-key": "k1", "api-secret": "s1", "org-id": "org", "tags": "site=turin"},
+		{"name": "globex", "api-key": "k2", "api-secret": "s2", "destination-bucket": "globex-data", "destination-prefix": "/exports/"}
+	]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Tenant{
+		{Name: "acme", ApiKey: "k1", ApiSecret: "s1", OrgID: "org", Tags: "site=turin", DestinationPrefix: "acme"},
+		{Name: "globex", ApiKey: "k2", ApiSecret: "s2", DestinationBucket: "globex-data", DestinationPrefix: "exports"},
+	}, tenants)
+
+	tenants, err = ParseTenants("")
+	assert.NoError(t, err)
+	assert.Nil(t, tenants)
+
+	for value, expected := range map[string]string{
+		`{"name": "acme"}`: "invalid tenants: json: cannot unmarshal object into Go value of type []exporter.Tenant",
+		`[{"name": "acme corp", "api-key": "k", "api-secret": "s"}]`:                                                 `invalid tenant name "acme corp": letters, digits, '-' and '_' are allowed`,
+		`[{"name": "acme", "api-key": "k", "api-secret": "s"}, {"name": "acme", "api-key": "k", "api-secret": "s"}]`: "duplicated tenant: acme",
+		`[{"name": "acme", "api-key": "k"}]`:                                                                         "tenant acme: api-key and api-secret are required",
+	} {
+		_, err := ParseTenants(value)
+		assert.EqualError(t, err, expected)
+	}
+}
+
+func TestExportTenants_isolatedFailures(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	tenants := []Tenant{{Name: "acme"}, {Name: "globex"}, {Name: "initech"}}
+
+	var exported []string
+	report, err := ExportTenants(context.Background(), tenants, func(ctx context.Context, tenant Tenant, logger *logrus.Entry) (*Report, error) {
+		exported = append(exported, tenant.Name)
+		switch tenant.Name {
+		case "acme":
+			return &Report{Status: StatusSuccess, ExportedWindows: 1, Things: 3}, nil
+		case "globex":
+			return nil, errors.New("invalid credentials")
+		}
+		return &Report{Status: StatusPartial, ExportedWindows: 1, Things: 2}, nil
+	}, logger)
+
+	assert.EqualError(t, err, "export failed for tenants: globex")
+	assert.Equal(t, []string{"acme", "globex", "initech"}, exported)
+	assert.Equal(t, StatusPartial, report.Status)
+	assert.Equal(t, 2, report.ExportedWindows)
+	assert.Equal(t, 5, report.Things)
+	assert.Equal(t, StatusSuccess, report.Tenants[0].Status)
+	assert.Equal(t, StatusFailed, report.Tenants[1].Status)
+	assert.Equal(t, "invalid credentials", report.Tenants[1].Error)
+	assert.Equal(t, StatusPartial, report.Tenants[2].Status)
+}
+
+func TestExportTenants_allFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := ExportTenants(ctx, []Tenant{{Name: "acme"}, {Name: "globex"}}, func(ctx context.Context, tenant Tenant, logger *logrus.Entry) (*Report, error) {
+		t.Fatal("tenants are not exported after context is canceled")
+		return nil, nil
+	}, logrus.NewEntry(logrus.New()))
+
+	assert.EqualError(t, err, "export failed for tenants: acme, globex")
+	assert.Equal(t, StatusFailed, report.Status)
+	assert.Equal(t, []string{"acme", "globex"}, report.FailedTenants())
+}
@@ -0,0 +1,287 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package thingselector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/arduino/aws-s3-integration/internal/iot"
+	"github.com/arduino/aws-s3-integration/internal/pattern"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+// Number of things whose properties are fetched with a single request
+const PropertiesBatchSize = 50
+
+// Max number of attempts of rate limited requests
+const retryCount = 8
+
+// Selector selects the things to export. Every configured criterion must be satisfied: a thing is selected when
+// it is one of the thing IDs, it is attached to one of the devices, its name matches one of the name patterns
+// and its tags match one of the tag groups.
+type Selector struct {
+	ThingIDs  []string
+	DeviceIDs []string
+	Names     []string
+	// Tag groups are in OR, tags of a group are in AND
+	TagGroups []map[string]string
+
+	names []pattern.Pattern
+}
+
+// Selector criteria, reported by CriterionError
+const (
+	CriterionThingIDs  = "thing ids"
+	CriterionDeviceIDs = "device ids"
+	CriterionNames     = "names"
+	CriterionTags      = "tags"
+)
+
+// CriterionError is returned by New when the value of a criterion is invalid
+type CriterionError struct {
+	Criterion string
+	Err       error
+}
+
+func (e *CriterionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CriterionError) Unwrap() error {
+	return e.Err
+}
+
+// New builds a selector from configuration values:
+//   - thingIDs, deviceIDs and names are comma separated lists. Names are wildcard patterns or regular expressions enclosed in slashes,
+//     that can contain commas (for example /^sensor-[0-9]{2,4}$/).
+//   - tags is a list of tag groups separated by '|', each group with syntax tag=value,tag2=value2 (for example site=turin,env=prod|site=milan)
+//
+// Empty values are not applied. Invalid values are reported with a *CriterionError.
+func New(thingIDs, deviceIDs, names, tags string) (*Selector, error) {
+	s := &Selector{
+		ThingIDs:  splitList(thingIDs),
+		DeviceIDs: splitList(deviceIDs),
+		Names:     splitPatterns(names),
+	}
+	var err error
+	if s.names, err = pattern.ParseList(s.Names); err != nil {
+		return nil, &CriterionError{Criterion: CriterionNames, Err: err}
+	}
+	if s.TagGroups, err = parseTagGroups(tags); err != nil {
+		return nil, &CriterionError{Criterion: CriterionTags, Err: err}
+	}
+	return s, nil
+}
+
+func splitList(value string) []string {
+	return uniqueItems(strings.Split(value, ","))
+}
+
+// splitPatterns splits a comma separated list of name patterns, keeping together the commas of regular expressions
+func splitPatterns(value string) []string {
+	var items []string
+	pending := ""
+	open := false
+	for _, item := range strings.Split(value, ",") {
+		if open {
+			item = pending + "," + item
+		}
+		// Regular expression is not complete until its closing slash
+		trimmed := strings.TrimSpace(item)
+		open = strings.HasPrefix(trimmed, "/") && (len(trimmed) == 1 || !strings.HasSuffix(trimmed, "/"))
+		if open {
+			pending = item
+			continue
+		}
+		items = append(items, item)
+	}
+	if open {
+		// Unterminated regular expression is reported by pattern parsing
+		items = append(items, pending)
+	}
+	return uniqueItems(items)
+}
+
+// uniqueItems returns trimmed not empty items, without duplicates
+func uniqueItems(items []string) []string {
+	var list []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" && !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseTagGroups(value string) ([]map[string]string, error) {
+	var groups []map[string]string
+	for _, group := range strings.Split(value, "|") {
+		if strings.TrimSpace(group) == "" {
+			continue
+		}
+		tags := map[string]string{}
+		for _, tag := range strings.Split(group, ",") {
+			if strings.TrimSpace(tag) == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(tag, "=")
+			key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+			if !ok || key == "" || val == "" {
+				return nil, fmt.Errorf("invalid tag filter %s. Syntax: tag=value,tag2=value2", tag)
+			}
+			tags[key] = val
+		}
+		groups = append(groups, tags)
+	}
+	return groups, nil
+}
+
+// IsEmpty reports whether all things are selected
+func (s *Selector) IsEmpty() bool {
+	return s == nil || (len(s.ThingIDs) == 0 && len(s.DeviceIDs) == 0 && len(s.Names) == 0 && len(s.TagGroups) == 0)
+}
+
+func (s *Selector) String() string {
+	if s.IsEmpty() {
+		return "all things"
+	}
+	parts := []string{}
+	if len(s.ThingIDs) > 0 {
+		parts = append(parts, "thing ids "+strings.Join(s.ThingIDs, ","))
+	}
+	if len(s.DeviceIDs) > 0 {
+		parts = append(parts, "device ids "+strings.Join(s.DeviceIDs, ","))
+	}
+	if len(s.Names) > 0 {
+		parts = append(parts, "names "+strings.Join(s.Names, ","))
+	}
+	if len(s.TagGroups) > 0 {
+		groups := make([]string, 0, len(s.TagGroups))
+		for _, group := range s.TagGroups {
+			groups = append(groups, formatTags(group))
+		}
+		parts = append(parts, "tags "+strings.Join(groups, " | "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, val := range tags {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Discover streams the selected things, with their properties, to the found function.
+// Things are listed without properties first: thing IDs, a single device ID and tag groups are pushed to the API
+// (one request per tag group), while other criteria are applied to listed things.
+// Properties are then fetched for batches of batchSize things, so that responses stay small also with large fleets.
+func (s *Selector) Discover(ctx context.Context, iotcl iot.API, thingIDs []string, batchSize int, found func([]iotclient.ArduinoThing) error) error {
+	ids, err := s.ListIDs(ctx, iotcl, thingIDs)
+	if err != nil {
+		return err
+	}
+	if batchSize <= 0 {
+		batchSize = PropertiesBatchSize
+	}
+	for start := 0; start < len(ids); start += batchSize {
+		batch := ids[start:min(start+batchSize, len(ids))]
+		things, err := thingList(ctx, iotcl, batch, nil, true, nil)
+		if err != nil {
+			return err
+		}
+		if err := found(things); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListIDs lists IDs of the selected things, without fetching their properties.
+// When thingIDs is not empty, selection is restricted to given things.
+func (s *Selector) ListIDs(ctx context.Context, iotcl iot.API, thingIDs []string) ([]string, error) {
+	if s == nil {
+		s = &Selector{}
+	}
+
+	ids := s.ThingIDs
+	if len(thingIDs) > 0 {
+		ids = thingIDs
+	}
+	var device *string
+	if len(s.DeviceIDs) == 1 {
+		device = &s.DeviceIDs[0]
+	}
+	groups := s.TagGroups
+	if len(groups) == 0 {
+		groups = []map[string]string{nil}
+	}
+
+	selected := []string{}
+	seen := map[string]bool{}
+	for _, tags := range groups {
+		things, err := thingList(ctx, iotcl, ids, device, false, tags)
+		if err != nil {
+			return nil, err
+		}
+		for _, thing := range things {
+			if seen[thing.Id] || !s.matches(thing) {
+				continue
+			}
+			if len(thingIDs) > 0 && !slices.Contains(thingIDs, thing.Id) {
+				continue
+			}
+			seen[thing.Id] = true
+			selected = append(selected, thing.Id)
+		}
+	}
+	return selected, nil
+}
+
+// thingList lists things, retrying rate limited requests: client waits for backoff before next call
+func thingList(ctx context.Context, iotcl iot.API, ids []string, device *string, props bool, tags map[string]string) ([]iotclient.ArduinoThing, error) {
+	var things []iotclient.ArduinoThing
+	var retry bool
+	var err error
+	for i := 0; i < retryCount; i++ {
+		things, retry, err = iotcl.ThingList(ctx, ids, device, props, tags)
+		if !retry {
+			break
+		}
+	}
+	return things, err
+}
+
+// matches checks criteria not applied by the API
+func (s *Selector) matches(thing iotclient.ArduinoThing) bool {
+	if len(s.ThingIDs) > 0 && !slices.Contains(s.ThingIDs, thing.Id) {
+		return false
+	}
+	// A single device is filtered by the API
+	if len(s.DeviceIDs) > 1 && (thing.DeviceId == nil || !slices.Contains(s.DeviceIDs, *thing.DeviceId)) {
+		return false
+	}
+	if len(s.names) > 0 && !pattern.MatchAny(s.names, thing.Name) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package thingselector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	iotMocks "github.com/arduino/aws-s3-integration/internal/iot/mocks"
+	iotclient "github.com/arduino/iot-client-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func toPtr(val string) *string {
+	return &val
+}
+
+func thingIDs(things []iotclient.ArduinoThing) []string {
+	ids := []string{}
+	for _, thing := range things {
+		ids = append(ids, thing.Id)
+	}
+	return ids
+}
+
+// discover collects the things discovered by the selector, with their properties
+func discover(ctx context.Context, s *Selector, iotcl *iotMocks.API, ids []string) ([]iotclient.ArduinoThing, error) {
+	things := []iotclient.ArduinoThing{}
+	err := s.Discover(ctx, iotcl, ids, PropertiesBatchSize, func(batch []iotclient.ArduinoThing) error {
+		things = append(things, batch...)
+		return nil
+	})
+	return things, err
+}
+
+// mockProperties mocks requests of things properties, returning requested test things with a property
+func mockProperties(iotcl *iotMocks.API) {
+	iotcl.On("ThingList", mock.Anything, mock.Anything, (*string)(nil), true, map[string]string(nil)).Return(
+		func(_ context.Context, ids []string, _ *string, _ bool, _ map[string]string) ([]iotclient.ArduinoThing, bool, error) {
+			things := []iotclient.ArduinoThing{}
+			for _, thing := range testThings {
+				if slices.Contains(ids, thing.Id) {
+					thing.Properties = []iotclient.ArduinoProperty{{Id: thing.Id + "-p", Name: "value", Type: "FLOAT"}}
+					things = append(things, thing)
+				}
+			}
+			return things, false, nil
+		})
+}
+
+var testThings = []iotclient.ArduinoThing{
+	{Id: "t1", Name: "boiler-turin", DeviceId: toPtr("d1")},
+	{Id: "t2", Name: "boiler-milan", DeviceId: toPtr("d2")},
+	{Id: "t3", Name: "pump-turin", DeviceId: toPtr("d3")},
+	{Id: "t4", Name: "test-bench"},
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(" t1, t2,t1 ", "d1", "boiler-*,/^pump-[a-z]+$/", "site=turin, env=prod | site=milan")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t1", "t2"}, s.ThingIDs)
+	assert.Equal(t, []string{"d1"}, s.DeviceIDs)
+	assert.Equal(t, []string{"boiler-*", "/^pump-[a-z]+$/"}, s.Names)
+	assert.Equal(t, []map[string]string{{"site": "turin", "env": "prod"}, {"site": "milan"}}, s.TagGroups)
+	assert.Equal(t, "thing ids t1,t2; device ids d1; names boiler-*,/^pump-[a-z]+$/; tags env=prod,site=turin | site=milan", s.String())
+
+	// Commas of regular expressions do not separate patterns
+	s, err = New("", "", "/^boiler-[a-z]{4,5}$/, pump-*,/a,b/", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/^boiler-[a-z]{4,5}$/", "pump-*", "/a,b/"}, s.Names)
+
+	s, err = New("", "", "", "")
+	assert.NoError(t, err)
+	assert.True(t, s.IsEmpty())
+	assert.Equal(t, "all things", s.String())
+
+	var criterionErr *CriterionError
+	_, err = New("", "", "/(/", "")
+	assert.ErrorAs(t, err, &criterionErr)
+	assert.Equal(t, CriterionNames, criterionErr.Criterion)
+	_, err = New("", "", "", "site")
+	assert.ErrorAs(t, err, &criterionErr)
+	assert.Equal(t, CriterionTags, criterionErr.Criterion)
+	_, err = New("", "", "", "site=,env=prod")
+	assert.Error(t, err)
+}
+
+func TestSelect_allThings(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return(testThings, false, nil)
+
+	s, err := New("", "", "", "")
+	assert.NoError(t, err)
+	things, err := discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t1", "t2", "t3", "t4"}, thingIDs(things))
+	assert.Len(t, things[0].Properties, 1)
+
+	// Nil selector selects all things too
+	things, err = discover(ctx, nil, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Len(t, things, 4)
+}
+
+func TestSelect_tagGroupsInOr(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string{"site": "turin"}).Return([]iotclient.ArduinoThing{testThings[0], testThings[2]}, false, nil)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string{"type": "boiler"}).Return([]iotclient.ArduinoThing{testThings[0], testThings[1]}, false, nil)
+
+	s, err := New("", "", "", "site=turin|type=boiler")
+	assert.NoError(t, err)
+	things, err := discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	// Things matching more groups are listed once
+	assert.ElementsMatch(t, []string{"t1", "t2", "t3"}, thingIDs(things))
+}
+
+func TestSelect_namesAndDevices(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return(testThings, false, nil)
+
+	s, err := New("", "", "boiler-*,/^test/", "")
+	assert.NoError(t, err)
+	things, err := discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t1", "t2", "t4"}, thingIDs(things))
+
+	// More devices are filtered on listed things
+	s, err = New("", "d1,d3,d4", "", "")
+	assert.NoError(t, err)
+	things, err = discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t1", "t3"}, thingIDs(things))
+
+	// Criteria are in AND
+	s, err = New("", "d1,d3", "*-turin", "")
+	assert.NoError(t, err)
+	things, err = discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t1", "t3"}, thingIDs(things))
+	s, err = New("", "d1,d3", "pump-*", "")
+	assert.NoError(t, err)
+	things, err = discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t3"}, thingIDs(things))
+}
+
+func TestSelect_singleDeviceIsFilteredByAPI(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string(nil), toPtr("d2"), false, map[string]string{"site": "milan"}).Return([]iotclient.ArduinoThing{testThings[1]}, false, nil)
+
+	s, err := New("", "d2", "", "site=milan")
+	assert.NoError(t, err)
+	things, err := discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t2"}, thingIDs(things))
+}
+
+func TestSelect_thingIDs(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string{"t1", "t2"}, (*string)(nil), false, map[string]string(nil)).Return([]iotclient.ArduinoThing{testThings[0], testThings[1]}, false, nil).Once()
+
+	s, err := New("t1,t2", "", "", "")
+	assert.NoError(t, err)
+	things, err := discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t1", "t2"}, thingIDs(things))
+
+	// Requested things are restricted to configured ones
+	iotcl.On("ThingList", ctx, []string{"t2", "t3"}, (*string)(nil), false, map[string]string(nil)).Return([]iotclient.ArduinoThing{testThings[1], testThings[2]}, false, nil).Once()
+	things, err = discover(ctx, s, iotcl, []string{"t2", "t3"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t2"}, thingIDs(things))
+}
+
+func TestSelect_error(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("ThingList", ctx, mock.Anything, mock.Anything, false, mock.Anything).Return(nil, false, errors.New("unauthorized"))
+
+	s, err := New("", "", "", "site=turin")
+	assert.NoError(t, err)
+	_, err = discover(ctx, s, iotcl, nil)
+	assert.Error(t, err)
+}
+
+func TestSelect_rateLimited(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return(nil, true, errors.New("too many requests")).Twice()
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return(testThings, false, nil).Once()
+
+	s, err := New("", "", "", "")
+	assert.NoError(t, err)
+	things, err := discover(ctx, s, iotcl, nil)
+	assert.NoError(t, err)
+	assert.Len(t, things, len(testThings))
+
+	// Requests still rate limited after all attempts fail
+	iotcl = iotMocks.NewAPI(t)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return(nil, true, errors.New("too many requests"))
+	_, err = discover(ctx, s, iotcl, nil)
+	assert.EqualError(t, err, "too many requests")
+	iotcl.AssertNumberOfCalls(t, "ThingList", retryCount)
+}
+
+func TestDiscover_propertiesInBatches(t *testing.T) {
+	ctx := context.Background()
+	iotcl := iotMocks.NewAPI(t)
+	mockProperties(iotcl)
+	iotcl.On("ThingList", ctx, []string(nil), (*string)(nil), false, map[string]string(nil)).Return(testThings, false, nil)
+
+	s, err := New("", "", "", "")
+	assert.NoError(t, err)
+	batches := [][]string{}
+	err = s.Discover(ctx, iotcl, nil, 3, func(things []iotclient.ArduinoThing) error {
+		batches = append(batches, thingIDs(things))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"t1", "t2", "t3"}, {"t4"}}, batches)
+	iotcl.AssertNumberOfCalls(t, "ThingList", 3)
+
+	// Errors of found function stop discovery
+	err = s.Discover(ctx, iotcl, nil, 3, func(things []iotclient.ArduinoThing) error {
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	iotcl.AssertNumberOfCalls(t, "ThingList", 5)
+}
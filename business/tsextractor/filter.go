@@ -0,0 +1,140 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package tsextractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/arduino/aws-s3-integration/internal/iot"
+	"github.com/arduino/aws-s3-integration/internal/pattern"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+// Property type groups, usable in filters in place of single property types
+const (
+	PropertyTypeNumeric  = "numeric"
+	PropertyTypeBoolean  = "boolean"
+	PropertyTypeString   = "string"
+	PropertyTypeLocation = "location"
+)
+
+// PropertyFilter selects the exported properties of things. Names are matched against wildcard patterns (for example debug_*)
+// or regular expressions enclosed in slashes (for example /^dbg[0-9]+$/). Types are property types (for example CHARSTRING)
+// or type groups (numeric, boolean, string, location). A property is exported when it matches include lists, if any, and no exclude list.
+type PropertyFilter struct {
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	IncludeTypes []string `json:"include_types,omitempty"`
+	ExcludeTypes []string `json:"exclude_types,omitempty"`
+
+	include []pattern.Pattern
+	exclude []pattern.Pattern
+}
+
+// ParsePropertyFilter parses a JSON property filter, for example
+// {"exclude": ["debug_*"], "exclude_types": ["CHARSTRING"]}. An empty value means no filter.
+func ParsePropertyFilter(value string) (*PropertyFilter, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	filter := &PropertyFilter{}
+	if err := json.Unmarshal([]byte(value), filter); err != nil {
+		return nil, fmt.Errorf("invalid property filter: %w", err)
+	}
+	var err error
+	if filter.include, err = pattern.ParseList(filter.Include); err != nil {
+		return nil, fmt.Errorf("invalid property filter: %w", err)
+	}
+	if filter.exclude, err = pattern.ParseList(filter.Exclude); err != nil {
+		return nil, fmt.Errorf("invalid property filter: %w", err)
+	}
+	return filter, nil
+}
+
+func (f *PropertyFilter) String() string {
+	if f == nil {
+		return "none"
+	}
+	parts := []string{}
+	for _, list := range []struct {
+		name   string
+		values []string
+	}{{"include", f.Include}, {"exclude", f.Exclude}, {"include types", f.IncludeTypes}, {"exclude types", f.ExcludeTypes}} {
+		if len(list.values) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", list.name, strings.Join(list.values, ",")))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Matches reports whether the property has to be exported
+func (f *PropertyFilter) Matches(prop iotclient.ArduinoProperty) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !pattern.MatchAny(f.include, prop.Name) {
+		return false
+	}
+	if pattern.MatchAny(f.exclude, prop.Name) {
+		return false
+	}
+	if len(f.IncludeTypes) > 0 && !matchesAnyType(f.IncludeTypes, prop.Type) {
+		return false
+	}
+	return !matchesAnyType(f.ExcludeTypes, prop.Type)
+}
+
+// ApplyToThing returns the thing with the properties selected by the filter
+func (f *PropertyFilter) ApplyToThing(thing iotclient.ArduinoThing) iotclient.ArduinoThing {
+	if f == nil {
+		return thing
+	}
+	properties := make([]iotclient.ArduinoProperty, 0, len(thing.Properties))
+	for _, prop := range thing.Properties {
+		if f.Matches(prop) {
+			properties = append(properties, prop)
+		}
+	}
+	thing.Properties = properties
+	return thing
+}
+
+func matchesAnyType(types []string, propertyType string) bool {
+	for _, t := range types {
+		var ok bool
+		switch strings.ToLower(t) {
+		case PropertyTypeNumeric:
+			ok = iot.IsPropertyNumberType(propertyType)
+		case PropertyTypeBoolean:
+			ok = iot.IsPropertyBool(propertyType)
+		case PropertyTypeString:
+			ok = iot.IsPropertyString(propertyType)
+		case PropertyTypeLocation:
+			ok = iot.IsPropertyLocation(propertyType)
+		default:
+			ok = strings.EqualFold(t, propertyType)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
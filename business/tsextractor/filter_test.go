@@ -0,0 +1,85 @@
+package tsextractor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	iotMocks "github.com/arduino/aws-s3-integration/internal/iot/mocks"
+	iotclient "github.com/arduino/iot-client-go/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var filterTestProperties = []iotclient.ArduinoProperty{
+	{Id: "temperature", Name: "temperature", Type: "TEMPERATURE_C"},
+	{Id: "counter", Name: "counter", Type: "INT"},
+	{Id: "debug", Name: "debug_loop", Type: "FLOAT"},
+	{Id: "dbg", Name: "dbg12", Type: "INT"},
+	{Id: "message", Name: "message", Type: "CHARSTRING"},
+	{Id: "alarm", Name: "alarm", Type: "STATUS"},
+	{Id: "position", Name: "position", Type: "LOCATION"},
+}
+
+func filteredIDs(t *testing.T, value string) []string {
+	filter, err := ParsePropertyFilter(value)
+	assert.NoError(t, err)
+	return propertyIDsOf(filter.ApplyToThing(iotclient.ArduinoThing{Properties: filterTestProperties}))
+}
+
+func TestPropertyFilter(t *testing.T) {
+	assert.Equal(t, []string{"temperature", "counter", "debug", "dbg", "message", "alarm", "position"}, filteredIDs(t, ""))
+	assert.Equal(t, []string{"temperature", "counter", "message", "alarm", "position"}, filteredIDs(t, `{"exclude": ["debug_*", "/^dbg[0-9]+$/"]}`))
+	assert.Equal(t, []string{"temperature", "debug"}, filteredIDs(t, `{"include": ["temp*", "debug_*"]}`))
+	assert.Equal(t, []string{"temperature", "counter", "debug", "dbg"}, filteredIDs(t, `{"include_types": ["numeric"]}`))
+	assert.Equal(t, []string{"temperature", "counter", "debug", "dbg", "alarm", "position"}, filteredIDs(t, `{"exclude_types": ["charstring"]}`))
+	assert.Equal(t, []string{"alarm", "position"}, filteredIDs(t, `{"include_types": ["boolean", "location"]}`))
+	assert.Equal(t, []string{"temperature", "counter"}, filteredIDs(t, `{"exclude": ["d*"], "include_types": ["numeric"]}`))
+
+	for _, invalid := range []string{`["debug_*"]`, `{"include": ["["]}`, `{"exclude": ["/(/"]}`} {
+		_, err := ParsePropertyFilter(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestExtractionFlow_propertyFilter(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	bucket := now.Add(-5 * time.Minute)
+	// Things are filtered by discovery
+	filter, err := ParsePropertyFilter(`{"exclude": ["debug_*", "dbg*"], "exclude_types": ["CHARSTRING", "location"]}`)
+	assert.NoError(t, err)
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: filter.ApplyToThing(iotclient.ArduinoThing{Id: thingId, Name: "test", Properties: filterTestProperties}),
+	}
+
+	// Only selected properties are queried
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetTimeSeriesByProperties", ctx, []string{"temperature", "counter", "alarm"}, mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: "property.temperature", Times: []time.Time{bucket}, Values: []float64{21.5}, CountValues: 1},
+		},
+	}, false, nil)
+
+	sink := &memorySink{}
+	err = exportToSink(ctx, New(iotcl, logger, nil, true, false), now.Add(-time.Hour), now, thingsMap, 300, []string{"AVG"}, sink)
+	assert.NoError(t, err)
+	assert.Len(t, sink.samples, 1)
+
+	// Raw exports are queried by property too
+	iotcl.On("GetRawTimeSeriesByProperties", ctx, []string{"temperature", "counter", "alarm"}, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{
+		Responses: []iotclient.ArduinoSeriesRawResponse{
+			{Query: "property.counter", Times: []time.Time{bucket}, Values: []any{3}, CountValues: 1},
+		},
+	}, false, nil)
+
+	sink = &memorySink{}
+	err = exportToSink(ctx, New(iotcl, logger, nil, true, false), now.Add(-time.Hour), now, thingsMap, -1, nil, sink)
+	assert.NoError(t, err)
+	assert.Len(t, sink.samples, 1)
+	assert.Equal(t, "", sink.samples[0].Aggregation)
+}
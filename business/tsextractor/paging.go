@@ -0,0 +1,164 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package tsextractor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/iot"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+// seriesPage holds the values of a property series returned by a single raw or sampled query
+type seriesPage struct {
+	times     []time.Time
+	values    []any
+	truncated bool
+}
+
+func (p *seriesPage) append(page seriesPage) {
+	p.times = append(p.times, page.times...)
+	p.values = append(p.values, page.values...)
+}
+
+// latest returns the most recent timestamp of the page. Values are not assumed to be sorted,
+// as only raw queries can request ascending order.
+func (p seriesPage) latest() time.Time {
+	latest := p.times[0]
+	for _, t := range p.times[1:] {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// before returns the values of the page older than the given time
+func (p seriesPage) before(t time.Time) seriesPage {
+	older := seriesPage{}
+	for i, ts := range p.times {
+		if ts.Before(t) {
+			older.times = append(older.times, ts)
+			older.values = append(older.values, p.values[i])
+		}
+	}
+	return older
+}
+
+// isTruncated reports whether a series has been cut by the series limit. Limit reported by response, if any, wins over the default one.
+func isTruncated(countValues int64, seriesLimit *int64) bool {
+	limit := iot.SeriesLimit
+	if seriesLimit != nil && *seriesLimit > 0 {
+		limit = *seriesLimit
+	}
+	return countValues >= limit
+}
+
+// completeSeries pages through the rest of the time window, in sub-intervals, until a truncated series is complete.
+// Each page starts from the most recent timestamp of the previous one: values of that timestamp are dropped from the previous page,
+// as the series limit could have cut some of them, and are all returned by the next one.
+func (a *TsExtractor) completeSeries(
+	thing iotclient.ArduinoThing,
+	propertyID string,
+	page seriesPage,
+	to time.Time,
+	nextPage func(from time.Time) (seriesPage, error)) (seriesPage, error) {
+
+	completed := seriesPage{}
+	pages := 1
+	for page.truncated && len(page.times) > 0 {
+		last := page.latest()
+		older := page.before(last)
+		from := last
+		if len(older.times) == 0 {
+			// All values share the same timestamp: page cannot be split further
+			a.logger.Warnf("Thing %s - Property %s - more than %d values at %s, exceeding values are skipped\n", thing.Id, propertyID, len(page.times), last)
+			older = page
+			from = last.Add(time.Millisecond)
+		}
+		completed.append(older)
+		if !from.Before(to) {
+			page = seriesPage{}
+			break
+		}
+
+		var err error
+		if page, err = nextPage(from); err != nil {
+			return seriesPage{}, err
+		}
+		pages++
+	}
+	completed.append(page)
+
+	a.logger.Infof("Thing %s - Property %s - series truncated by limit, extracted %d values in %d pages\n", thing.Id, propertyID, len(completed.times), pages)
+	return completed, nil
+}
+
+// nextRawPage queries raw values of a property starting from the given time
+func (a *TsExtractor) nextRawPage(ctx context.Context, thing iotclient.ArduinoThing, propertyID string, to time.Time) func(from time.Time) (seriesPage, error) {
+	return func(from time.Time) (seriesPage, error) {
+		var batched *iotclient.ArduinoSeriesRawBatch
+		var err error
+		var retry bool
+		for i := 0; i < retryCount; i++ {
+			batched, retry, err = a.iotcl.GetRawTimeSeriesByProperties(ctx, []string{propertyID}, from, to)
+			if !retry {
+				break
+			} else {
+				// This is due to a rate limit on the IoT API: client waits for backoff before next call
+				a.logger.Warnf("Rate limit reached for thing %s. Retrying after backoff.\n", thing.Id)
+			}
+		}
+		if err != nil {
+			return seriesPage{}, err
+		}
+		for _, response := range batched.Responses {
+			if response.Query == fmt.Sprintf("property.%s", propertyID) {
+				return seriesPage{times: response.Times, values: response.Values, truncated: isTruncated(response.CountValues, response.SeriesLimit)}, nil
+			}
+		}
+		return seriesPage{}, nil
+	}
+}
+
+// nextSampledPage queries sampled values of a string property starting from the given time
+func (a *TsExtractor) nextSampledPage(ctx context.Context, thing iotclient.ArduinoThing, propertyID string, to time.Time, resolution int) func(from time.Time) (seriesPage, error) {
+	return func(from time.Time) (seriesPage, error) {
+		var batched *iotclient.ArduinoSeriesBatchSampled
+		var err error
+		var retry bool
+		for i := 0; i < retryCount; i++ {
+			batched, retry, err = a.iotcl.GetTimeSeriesStringSampling(ctx, []string{propertyID}, from, to, int32(resolution))
+			if !retry {
+				break
+			} else {
+				// This is due to a rate limit on the IoT API: client waits for backoff before next call
+				a.logger.Warnf("Rate limit reached for thing %s. Retrying after backoff.\n", thing.Id)
+			}
+		}
+		if err != nil {
+			return seriesPage{}, err
+		}
+		for _, response := range batched.Responses {
+			if response.Query == fmt.Sprintf("property.%s", propertyID) {
+				return seriesPage{times: response.Times, values: response.Values, truncated: isTruncated(response.CountValues, response.SeriesLimit)}, nil
+			}
+		}
+		return seriesPage{}, nil
+	}
+}
@@ -0,0 +1,103 @@
+package tsextractor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	iotMocks "github.com/arduino/aws-s3-integration/internal/iot/mocks"
+	iotclient "github.com/arduino/iot-client-go/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func toInt64Ptr(val int64) *int64 {
+	return &val
+}
+
+func TestIsTruncated(t *testing.T) {
+	assert.False(t, isTruncated(999, nil))
+	assert.True(t, isTruncated(1000, nil))
+	assert.True(t, isTruncated(3, toInt64Ptr(3)))
+	assert.False(t, isTruncated(2, toInt64Ptr(3)))
+}
+
+func TestExtractionFlow_rawSeriesPaging(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	from, to := now.Add(-24*time.Hour), now
+	t1, t2, t3, t4 := from.Add(time.Minute), from.Add(2*time.Minute), from.Add(3*time.Minute), from.Add(4*time.Minute)
+
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: {
+			Id:         thingId,
+			Name:       "test",
+			Properties: []iotclient.ArduinoProperty{{Id: "counter", Name: "counter", Type: "INT"}},
+		},
+	}
+
+	// First page reaches the series limit. Values of its last timestamp could be cut, so next page starts from it.
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, from, to).Return(&iotclient.ArduinoSeriesRawBatch{
+		Responses: []iotclient.ArduinoSeriesRawResponse{
+			{Query: "property.counter", Times: []time.Time{t1, t2, t3}, Values: []any{1.0, 2.0, 3.0}, CountValues: 3, SeriesLimit: toInt64Ptr(3)},
+		},
+	}, false, nil)
+	iotcl.On("GetRawTimeSeriesByProperties", ctx, []string{"counter"}, t3, to).Return(&iotclient.ArduinoSeriesRawBatch{
+		Responses: []iotclient.ArduinoSeriesRawResponse{
+			{Query: "property.counter", Times: []time.Time{t3, t3, t4}, Values: []any{3.0, 3.5, 4.0}, CountValues: 3, SeriesLimit: toInt64Ptr(3)},
+		},
+	}, false, nil).Once()
+	iotcl.On("GetRawTimeSeriesByProperties", ctx, []string{"counter"}, t4, to).Return(&iotclient.ArduinoSeriesRawBatch{
+		Responses: []iotclient.ArduinoSeriesRawResponse{
+			{Query: "property.counter", Times: []time.Time{t4}, Values: []any{4.0}, CountValues: 1, SeriesLimit: toInt64Ptr(3)},
+		},
+	}, false, nil).Once()
+
+	sink := &memorySink{}
+	err := exportToSink(ctx, New(iotcl, logger, nil, false, false), from, to, thingsMap, -1, nil, sink)
+	assert.NoError(t, err)
+
+	values := []any{}
+	for _, sample := range sink.samples {
+		values = append(values, sample.Value)
+	}
+	assert.Equal(t, []any{1.0, 2.0, 3.0, 3.5, 4.0}, values)
+}
+
+func TestCompleteSeries_sameTimestamp(t *testing.T) {
+	a := New(nil, logrus.NewEntry(logrus.New()), nil, false, false)
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+
+	// A page with a single timestamp cannot be split: paging moves past it
+	var requested []time.Time
+	page := seriesPage{times: []time.Time{now, now}, values: []any{1, 2}, truncated: true}
+	completed, err := a.completeSeries(iotclient.ArduinoThing{Id: "thing"}, "prop", page, now.Add(time.Hour), func(from time.Time) (seriesPage, error) {
+		requested = append(requested, from)
+		return seriesPage{times: []time.Time{now.Add(time.Minute)}, values: []any{3}}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{now.Add(time.Millisecond)}, requested)
+	assert.Equal(t, []any{1, 2, 3}, completed.values)
+}
+
+func TestCompleteSeries_unorderedTimes(t *testing.T) {
+	a := New(nil, logrus.NewEntry(logrus.New()), nil, false, false)
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	t1, t2, t3, t4 := now.Add(time.Minute), now.Add(2*time.Minute), now.Add(3*time.Minute), now.Add(4*time.Minute)
+
+	// Sampled series are not sorted: next page starts from the most recent timestamp, whose values are all dropped
+	var requested []time.Time
+	page := seriesPage{times: []time.Time{t3, t1, t3, t2}, values: []any{"c", "a", "c2", "b"}, truncated: true}
+	completed, err := a.completeSeries(iotclient.ArduinoThing{Id: "thing"}, "prop", page, now.Add(time.Hour), func(from time.Time) (seriesPage, error) {
+		requested = append(requested, from)
+		return seriesPage{times: []time.Time{t4, t3, t3}, values: []any{"d", "c", "c2"}}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{t3}, requested)
+	assert.Equal(t, []any{"a", "b", "d", "c", "c2"}, completed.values)
+	assert.Equal(t, []time.Time{t1, t2, t4, t3, t3}, completed.times)
+}
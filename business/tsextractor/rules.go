@@ -0,0 +1,218 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package tsextractor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/pattern"
+	iotclient "github.com/arduino/iot-client-go/v2"
+)
+
+// Aggregation statistic of raw samples of properties exported raw by a rule, during aggregated exports
+const rawAggregation = "RAW"
+
+// PropertyRule overrides resolution and aggregation statistics of the properties it matches.
+// Property ID, name pattern (wildcard, for example temp*, or regular expression enclosed in slashes) and type, when set, must all match.
+// Resolution is raw or a duration between 1m and 1h (for example 15m). Aggregation is a comma separated list of statistics.
+type PropertyRule struct {
+	PropertyID  string `json:"property_id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Resolution  string `json:"resolution,omitempty"`
+	Aggregation string `json:"aggregation,omitempty"`
+
+	name             pattern.Pattern
+	resolution       int
+	aggregationStats []string
+}
+
+// PropertyRules are applied to aggregated exports. The first rule matching a property wins.
+type PropertyRules []PropertyRule
+
+// ParsePropertyRules parses a JSON list of property rules, for example
+// [{"type": "COUNT", "aggregation": "MAX"}, {"name": "alarm*", "resolution": "raw"}]
+func ParsePropertyRules(value string) (PropertyRules, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var rules PropertyRules
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("invalid property rules: %w", err)
+	}
+	for i := range rules {
+		if err := rules[i].parse(); err != nil {
+			return nil, fmt.Errorf("invalid property rule %d: %w", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *PropertyRule) parse() error {
+	if r.PropertyID == "" && r.Name == "" && r.Type == "" {
+		return errors.New("one of property_id, name and type is required")
+	}
+	if r.Resolution == "" && r.Aggregation == "" {
+		return errors.New("one of resolution and aggregation is required")
+	}
+	if r.Name != "" {
+		name, err := pattern.Parse(r.Name)
+		if err != nil {
+			return err
+		}
+		r.name = name
+	}
+	if r.Resolution == "raw" {
+		r.resolution = -1
+	} else if r.Resolution != "" {
+		d, err := time.ParseDuration(r.Resolution)
+		if err != nil {
+			return err
+		}
+		if d < time.Minute || d > time.Hour {
+			return errors.New("resolution must be between 1m and 1h")
+		}
+		r.resolution = int(d.Seconds())
+	}
+	if r.Aggregation != "" {
+		stats, err := ParseAggregationStatistics(r.Aggregation)
+		if err != nil {
+			return err
+		}
+		r.aggregationStats = stats
+	}
+	return nil
+}
+
+func (r PropertyRule) matches(prop iotclient.ArduinoProperty) bool {
+	if r.PropertyID != "" && r.PropertyID != prop.Id {
+		return false
+	}
+	if r.Type != "" && !strings.EqualFold(r.Type, prop.Type) {
+		return false
+	}
+	return r.Name == "" || r.name.Match(prop.Name)
+}
+
+func (r PropertyRules) match(prop iotclient.ArduinoProperty) (PropertyRule, bool) {
+	for _, rule := range r {
+		if rule.matches(prop) {
+			return rule, true
+		}
+	}
+	return PropertyRule{}, false
+}
+
+// Statistics returns the aggregation statistics produced by an aggregated export using the given default statistics
+func (r PropertyRules) Statistics(aggregationStats []string) []string {
+	stats := slices.Clone(aggregationStats)
+	for _, rule := range r {
+		ruleStats := rule.aggregationStats
+		if rule.resolution < 0 {
+			ruleStats = []string{rawAggregation}
+		}
+		for _, stat := range ruleStats {
+			if !slices.Contains(stats, stat) {
+				stats = append(stats, stat)
+			}
+		}
+	}
+	return stats
+}
+
+// validate checks that rules can be applied to an export with the given output format, resolution and time window
+func (r PropertyRules) validate(format string, resolution, timeWindowMinutes int) error {
+	for i, rule := range r {
+		if rule.resolution != 0 && timeWindowMinutes > 60 && rule.resolution <= 60 {
+			return fmt.Errorf("property rule %d: resolution must be greater than 1m for time windows greater than 1h", i+1)
+		}
+		if format != OutputFormatCSVWide {
+			continue
+		}
+		if rule.resolution < 0 {
+			return errors.New("csv_wide output format does not support raw property rules")
+		}
+		if rule.resolution != 0 && rule.resolution != resolution {
+			// Samples with different resolutions cannot share the rows of the wide layout
+			return fmt.Errorf("property rule %d: csv_wide output format does not support a resolution different from the export one", i+1)
+		}
+		if len(rule.aggregationStats) > 1 {
+			return errors.New("csv_wide output format supports a single aggregation statistic per property rule")
+		}
+	}
+	return nil
+}
+
+// propertyQuery is a set of thing properties extracted with the same resolution and statistics.
+// Nil property IDs stand for all the numeric properties of the thing.
+type propertyQuery struct {
+	resolution       int
+	aggregationStats []string
+	propertyIDs      []string
+}
+
+// queryPlan groups the properties of a thing by matching rule, so that each group is extracted with a single batch query
+type queryPlan struct {
+	numeric []propertyQuery
+	strings []propertyQuery
+	raw     []string
+}
+
+// plan builds the queries of thing properties. When byProperty is set, properties are queried by ID also without matching rules.
+func (r PropertyRules) plan(thing iotclient.ArduinoThing, resolution int, aggregationStats []string, byProperty bool) queryPlan {
+	plan := queryPlan{}
+	overridden := byProperty
+	for _, prop := range thing.Properties {
+		propResolution, propStats := resolution, aggregationStats
+		if rule, ok := r.match(prop); ok {
+			overridden = true
+			if rule.resolution != 0 {
+				propResolution = rule.resolution
+			}
+			if rule.aggregationStats != nil {
+				propStats = rule.aggregationStats
+			}
+		}
+		switch {
+		case isRawResolution(propResolution):
+			plan.raw = append(plan.raw, prop.Id)
+		case isStringProperty(prop.Type):
+			plan.strings = addToQuery(plan.strings, propResolution, nil, prop.Id)
+		default:
+			plan.numeric = addToQuery(plan.numeric, propResolution, propStats, prop.Id)
+		}
+	}
+	// Without overrides, numeric properties are extracted with a single query by thing
+	if !overridden {
+		plan.numeric = []propertyQuery{{resolution: resolution, aggregationStats: aggregationStats}}
+	}
+	return plan
+}
+
+func addToQuery(queries []propertyQuery, resolution int, aggregationStats []string, propertyID string) []propertyQuery {
+	for i := range queries {
+		if queries[i].resolution == resolution && slices.Equal(queries[i].aggregationStats, aggregationStats) {
+			queries[i].propertyIDs = append(queries[i].propertyIDs, propertyID)
+			return queries
+		}
+	}
+	return append(queries, propertyQuery{resolution: resolution, aggregationStats: aggregationStats, propertyIDs: []string{propertyID}})
+}
@@ -0,0 +1,162 @@
+package tsextractor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	iotMocks "github.com/arduino/aws-s3-integration/internal/iot/mocks"
+	iotclient "github.com/arduino/iot-client-go/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParsePropertyRules(t *testing.T) {
+	rules, err := ParsePropertyRules(`[
+		{"type": "COUNT", "aggregation": "max"},
+		{"name": "temp*", "resolution": "15m", "aggregation": "AVG,MIN"},
+		{"property_id": "c86f4ed9", "resolution": "raw"}
+	]`)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 3)
+	assert.Equal(t, []string{"MAX"}, rules[0].aggregationStats)
+	assert.Equal(t, 900, rules[1].resolution)
+	assert.Equal(t, []string{"AVG", "MIN"}, rules[1].aggregationStats)
+	assert.Equal(t, -1, rules[2].resolution)
+	assert.Equal(t, []string{"AVG", "MAX", "MIN", "RAW"}, rules.Statistics([]string{"AVG"}))
+
+	rules, err = ParsePropertyRules("")
+	assert.NoError(t, err)
+	assert.Nil(t, rules)
+
+	for _, invalid := range []string{
+		`{"type": "COUNT"}`,
+		`[{"aggregation": "MAX"}]`,
+		`[{"type": "COUNT"}]`,
+		`[{"name": "[", "aggregation": "MAX"}]`,
+		`[{"type": "COUNT", "resolution": "10s"}]`,
+		`[{"type": "COUNT", "resolution": "often"}]`,
+	} {
+		_, err := ParsePropertyRules(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPropertyRules_validateLayout(t *testing.T) {
+	rules, err := ParsePropertyRules(`[{"type": "COUNT", "aggregation": "MAX,MIN"}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateOutputFormat(OutputFormatCSV, 300, 60, []string{"AVG"}, rules))
+	assert.Error(t, ValidateOutputFormat(OutputFormatCSVWide, 300, 60, []string{"AVG"}, rules))
+
+	rules, err = ParsePropertyRules(`[{"type": "COUNT", "resolution": "raw"}]`)
+	assert.NoError(t, err)
+	assert.Error(t, ValidateOutputFormat(OutputFormatCSVWide, 300, 60, []string{"AVG"}, rules))
+
+	// Wide rows require a single resolution
+	rules, err = ParsePropertyRules(`[{"type": "COUNT", "resolution": "15m"}, {"name": "temp*", "resolution": "5m", "aggregation": "MAX"}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateOutputFormat(OutputFormatCSV, 300, 60, []string{"AVG"}, rules))
+	assert.EqualError(t, ValidateOutputFormat(OutputFormatCSVWide, 300, 60, []string{"AVG"}, rules), "property rule 1: csv_wide output format does not support a resolution different from the export one")
+	assert.NoError(t, ValidateOutputFormat(OutputFormatCSVWide, 300, 60, []string{"AVG"}, rules[1:]))
+}
+
+func TestPropertyRules_validateTimeWindow(t *testing.T) {
+	rules, err := ParsePropertyRules(`[{"type": "COUNT", "aggregation": "MAX"}, {"name": "alarm*", "resolution": "raw"}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateOutputFormat(OutputFormatCSV, 300, 60, []string{"AVG"}, rules))
+	assert.EqualError(t, ValidateOutputFormat(OutputFormatCSV, 300, 24*60, []string{"AVG"}, rules), "property rule 2: resolution must be greater than 1m for time windows greater than 1h")
+
+	rules, err = ParsePropertyRules(`[{"name": "temp*", "resolution": "1m"}]`)
+	assert.NoError(t, err)
+	assert.Error(t, ValidateOutputFormat(OutputFormatCSV, 300, 24*60, []string{"AVG"}, rules))
+	rules, err = ParsePropertyRules(`[{"name": "temp*", "resolution": "15m"}]`)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateOutputFormat(OutputFormatCSV, 300, 24*60, []string{"AVG"}, rules))
+}
+
+func TestPropertyRules_plan(t *testing.T) {
+	thing := iotclient.ArduinoThing{
+		Id: "thing",
+		Properties: []iotclient.ArduinoProperty{
+			{Id: "counter", Name: "pulses", Type: "COUNT"},
+			{Id: "temperature", Name: "temp_room", Type: "TEMPERATURE_C"},
+			{Id: "humidity", Name: "humidity", Type: "PERCENTAGE_RELATIVE_HUMIDITY"},
+			{Id: "alarm", Name: "alarm", Type: "STATUS"},
+			{Id: "message", Name: "message", Type: "CHARSTRING"},
+		},
+	}
+
+	// Without rules, numeric properties are extracted by thing
+	plan := PropertyRules(nil).plan(thing, 300, []string{"AVG"}, false)
+	assert.Equal(t, []propertyQuery{{resolution: 300, aggregationStats: []string{"AVG"}}}, plan.numeric)
+	assert.Equal(t, []propertyQuery{{resolution: 300, propertyIDs: []string{"message"}}}, plan.strings)
+	assert.Empty(t, plan.raw)
+
+	rules, err := ParsePropertyRules(`[
+		{"property_id": "alarm", "resolution": "raw"},
+		{"type": "count", "aggregation": "MAX"},
+		{"name": "temp*", "aggregation": "MAX"},
+		{"name": "*", "type": "CHARSTRING", "resolution": "15m"}
+	]`)
+	assert.NoError(t, err)
+	plan = rules.plan(thing, 300, []string{"AVG"}, false)
+	assert.Equal(t, []propertyQuery{
+		{resolution: 300, aggregationStats: []string{"MAX"}, propertyIDs: []string{"counter", "temperature"}},
+		{resolution: 300, aggregationStats: []string{"AVG"}, propertyIDs: []string{"humidity"}},
+	}, plan.numeric)
+	assert.Equal(t, []propertyQuery{{resolution: 900, propertyIDs: []string{"message"}}}, plan.strings)
+	assert.Equal(t, []string{"alarm"}, plan.raw)
+}
+
+func TestExtractionFlow_propertyRules(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	bucket := now.Add(-5 * time.Minute)
+
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: {
+			Id:   thingId,
+			Name: "test",
+			Properties: []iotclient.ArduinoProperty{
+				{Id: "counter", Name: "pulses", Type: "COUNT"},
+				{Id: "temperature", Name: "temperature", Type: "FLOAT"},
+				{Id: "alarm", Name: "alarm", Type: "STATUS"},
+			},
+		},
+	}
+
+	rules, err := ParsePropertyRules(`[{"type": "COUNT", "aggregation": "MAX"}, {"name": "alarm", "resolution": "raw"}]`)
+	assert.NoError(t, err)
+
+	// Each group of properties is extracted with its own query
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetTimeSeriesByProperties", ctx, []string{"counter"}, mock.Anything, mock.Anything, int64(300), []string{"MAX"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Aggregation: toPtr("MAX"), Query: "property.counter", Times: []time.Time{bucket}, Values: []float64{42}, CountValues: 1},
+		},
+	}, false, nil)
+	iotcl.On("GetTimeSeriesByProperties", ctx, []string{"temperature"}, mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Aggregation: toPtr("AVG"), Query: "property.temperature", Times: []time.Time{bucket}, Values: []float64{21.5}, CountValues: 1},
+		},
+	}, false, nil)
+	iotcl.On("GetRawTimeSeriesByProperties", ctx, []string{"alarm"}, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{
+		Responses: []iotclient.ArduinoSeriesRawResponse{
+			{Query: "property.alarm", Times: []time.Time{bucket.Add(17 * time.Second)}, Values: []any{true}, CountValues: 1},
+		},
+	}, false, nil)
+
+	sink := &memorySink{}
+	err = exportToSink(ctx, New(iotcl, logger, rules, false, false), now.Add(-time.Hour), now, thingsMap, 300, []string{"AVG"}, sink)
+	assert.NoError(t, err)
+
+	aggregations := map[string]string{}
+	for _, sample := range sink.samples {
+		aggregations[sample.PropertyID] = sample.Aggregation
+	}
+	assert.Equal(t, map[string]string{"counter": "MAX", "temperature": "AVG", "alarm": "RAW"}, aggregations)
+}
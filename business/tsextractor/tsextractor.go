@@ -17,34 +17,202 @@ package tsextractor
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"slices"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"crypto/rand"
-	"math/big"
-
 	"github.com/arduino/aws-s3-integration/internal/csv"
 	"github.com/arduino/aws-s3-integration/internal/iot"
+	"github.com/arduino/aws-s3-integration/internal/jsonl"
+	"github.com/arduino/aws-s3-integration/internal/parquet"
+	"github.com/arduino/aws-s3-integration/internal/samples"
 	iotclient "github.com/arduino/iot-client-go/v2"
 	"github.com/sirupsen/logrus"
 )
 
 const importConcurrency = 10
-const retryCount = 5
+const retryCount = 8
+
+// Max number of partition files open at the same time by split exports
+const maxOpenPartitions = 100
+
+// Aggregation of last value samples of properties without samples in the time window
+const lastValueAggregation = "LAST_VALUE"
+
+const (
+	OutputFormatCSV     = "csv"
+	OutputFormatParquet = "parquet"
+	OutputFormatJSONL   = "jsonl"
+	// CSV with one row per (timestamp, thing) and one column per property name. Only for aggregated resolutions.
+	OutputFormatCSVWide = "csv_wide"
+)
+
+func IsSupportedOutputFormat(format string) bool {
+	return format == OutputFormatCSV || format == OutputFormatParquet || format == OutputFormatJSONL || format == OutputFormatCSVWide
+}
+
+// ValidateOutputFormat checks that output format can be used with the given resolution, aggregation statistics and property rules,
+// and that property rules can be applied to the time window
+func ValidateOutputFormat(format string, resolution, timeWindowMinutes int, aggregationStats []string, rules PropertyRules) error {
+	if !IsSupportedOutputFormat(format) {
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+	if err := validateLayout(format, resolution, aggregationStats); err != nil {
+		return err
+	}
+	return rules.validate(format, resolution, timeWindowMinutes)
+}
+
+func validateLayout(format string, resolution int, aggregationStats []string) error {
+	if format != OutputFormatCSVWide {
+		return nil
+	}
+	if isRawResolution(resolution) {
+		return errors.New("csv_wide output format is supported only for aggregated resolutions")
+	}
+	if len(aggregationStats) > 1 {
+		return errors.New("csv_wide output format supports a single aggregation statistic")
+	}
+	return nil
+}
+
+// ParseAggregationStatistics parses a comma separated list of aggregation statistics (for example AVG,MAX)
+func ParseAggregationStatistics(value string) ([]string, error) {
+	stats := []string{}
+	for _, stat := range strings.Split(value, ",") {
+		stat = strings.ToUpper(strings.TrimSpace(stat))
+		if stat == "" {
+			continue
+		}
+		if !slices.Contains(stats, stat) {
+			stats = append(stats, stat)
+		}
+	}
+	if len(stats) == 0 {
+		return nil, errors.New("no aggregation statistic provided")
+	}
+	return stats, nil
+}
+
+// propertyNames returns the sorted names of the properties of given things, used as wide layout columns.
+// When complex values are expanded, locations have latitude and longitude columns, while sub-field columns of other
+// complex properties are added by the writer as samples are received.
+func propertyNames(thingsMap map[string]iotclient.ArduinoThing, expandComplexValues bool) []string {
+	names := []string{}
+	add := func(name string) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, thing := range thingsMap {
+		for _, prop := range thing.Properties {
+			switch {
+			case !expandComplexValues || !iot.IsPropertyComplex(prop.Type):
+				add(prop.Name)
+			case iot.IsPropertyLocation(prop.Type):
+				add(samples.SubFieldName(prop.Name, prop.Type, "lat"))
+				add(samples.SubFieldName(prop.Name, prop.Type, "lon"))
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// thingCollector records the things received by a streamed export, so that wide layout columns are known once export is complete
+type thingCollector struct {
+	mu                  sync.Mutex
+	things              map[string]iotclient.ArduinoThing
+	expandComplexValues bool
+}
+
+func newThingCollector(expandComplexValues bool) *thingCollector {
+	return &thingCollector{things: map[string]iotclient.ArduinoThing{}, expandComplexValues: expandComplexValues}
+}
+
+func (c *thingCollector) add(thing iotclient.ArduinoThing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.things[thing.Id] = thing
+}
+
+func (c *thingCollector) propertyNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return propertyNames(c.things, c.expandComplexValues)
+}
+
+// ThingsChannel returns a closed channel delivering the given things, to export things already listed
+func ThingsChannel(thingsMap map[string]iotclient.ArduinoThing) <-chan iotclient.ArduinoThing {
+	things := make(chan iotclient.ArduinoThing, len(thingsMap))
+	for _, thing := range thingsMap {
+		things <- thing
+	}
+	close(things)
+	return things
+}
+
+func newSamplesStreamWriter(outputFormat string, out io.Writer, logger *logrus.Entry, resolution int, aggregationStats []string, expandComplexValues bool, propertyNames func() []string) (samples.Sink, io.Closer, error) {
+	if err := validateLayout(outputFormat, resolution, aggregationStats); err != nil {
+		return nil, nil, err
+	}
+	isRawData := isRawResolution(resolution)
+	switch outputFormat {
+	case OutputFormatCSV, "":
+		writer, err := csv.NewStreamWriter(out, logger, isRawData, expandComplexValues)
+		return writer, writer, err
+	case OutputFormatCSVWide:
+		writer, err := csv.NewWideStreamWriter(out, logger, propertyNames)
+		return writer, writer, err
+	case OutputFormatParquet:
+		writer, err := parquet.NewStreamWriter(out, logger, isRawData)
+		return writer, writer, err
+	case OutputFormatJSONL:
+		writer, err := jsonl.NewStreamWriter(out, logger, isRawData)
+		return writer, writer, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+}
+
+func newSamplesWriter(outputFormat string, from time.Time, logger *logrus.Entry, resolution int, aggregationStats []string, expandComplexValues bool, propertyNames func() []string) (samples.Writer, error) {
+	if err := validateLayout(outputFormat, resolution, aggregationStats); err != nil {
+		return nil, err
+	}
+	isRawData := isRawResolution(resolution)
+	switch outputFormat {
+	case OutputFormatCSV, "":
+		return csv.NewWriter(from, logger, isRawData, expandComplexValues)
+	case OutputFormatCSVWide:
+		return csv.NewWideWriter(from, logger, propertyNames)
+	case OutputFormatParquet:
+		return parquet.NewWriter(from, logger, isRawData)
+	case OutputFormatJSONL:
+		return jsonl.NewWriter(from, logger, isRawData)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+}
 
 type TsExtractor struct {
-	iotcl  iot.API
-	logger *logrus.Entry
+	iotcl               iot.API
+	logger              *logrus.Entry
+	rules               PropertyRules
+	byProperty          bool
+	expandComplexValues bool
 }
 
-func New(iotcl iot.API, logger *logrus.Entry) *TsExtractor {
-	return &TsExtractor{iotcl: iotcl, logger: logger}
+// New returns an extractor. When expandComplexValues is set, complex values (for example locations) are exported
+// as one sample per sub-field (for example position.latitude and position.longitude).
+// When byProperty is set, series are always queried by property ID, as things carry only a subset of their properties
+// (for example, when a PropertyFilter has been applied by the caller): a query by thing would return all properties.
+func New(iotcl iot.API, logger *logrus.Entry, rules PropertyRules, byProperty bool, expandComplexValues bool) *TsExtractor {
+	return &TsExtractor{iotcl: iotcl, logger: logger, rules: rules, byProperty: byProperty, expandComplexValues: expandComplexValues}
 }
 
 func computeTimeAlignment(resolutionSeconds, timeWindowInMinutes int, enableAlignTimeWindow bool) (time.Time, time.Time) {
@@ -66,38 +234,284 @@ func computeTimeAlignment(resolutionSeconds, timeWindowInMinutes int, enableAlig
 	return from, to
 }
 
+type TimeWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// ComputeTimeWindows returns the time windows to be exported by current execution.
+// Without a watermark only the last aligned window is returned. Otherwise, all complete windows
+// starting from the watermark are returned (up to maxWindows), so that missed executions are recovered.
+func ComputeTimeWindows(resolutionSeconds, timeWindowInMinutes int, enableAlignTimeWindow bool, watermark *time.Time, maxWindows int) []TimeWindow {
+	from, to := computeTimeAlignment(resolutionSeconds, timeWindowInMinutes, enableAlignTimeWindow)
+	if watermark == nil {
+		return []TimeWindow{{From: from, To: to}}
+	}
+
+	windows := []TimeWindow{}
+	windowDuration := time.Duration(timeWindowInMinutes) * time.Minute
+	start := watermark.UTC()
+	for end := start.Add(windowDuration); !end.After(to) && len(windows) < maxWindows; end = start.Add(windowDuration) {
+		windows = append(windows, TimeWindow{From: start, To: end})
+		start = end
+	}
+	return windows
+}
+
+// SplitTimeWindows splits the [from, to] range in consecutive time windows of the given size.
+// From is aligned to window size, so that generated windows match the ones of scheduled executions.
+func SplitTimeWindows(from, to time.Time, timeWindowInMinutes int) []TimeWindow {
+	windowDuration := time.Duration(timeWindowInMinutes) * time.Minute
+	windows := []TimeWindow{}
+	for start := from.UTC().Truncate(windowDuration); start.Before(to); start = start.Add(windowDuration) {
+		end := start.Add(windowDuration)
+		if end.After(to) {
+			end = to.UTC()
+		}
+		windows = append(windows, TimeWindow{From: start, To: end})
+	}
+	return windows
+}
+
 func isRawResolution(resolution int) bool {
 	return resolution <= 0
 }
 
-func (a *TsExtractor) ExportTSToFile(
+// ExportTSWindowToFile exports samples of the given time window to a local file, in the given output format.
+// Things are exported as they are received, until the channel is closed.
+// Observers, if any, receive a copy of written samples (for example, to collect stats).
+func (a *TsExtractor) ExportTSWindowToFile(
 	ctx context.Context,
-	timeWindowInMinutes int,
-	thingsMap map[string]iotclient.ArduinoThing,
+	from, to time.Time,
+	things <-chan iotclient.ArduinoThing,
 	resolution int,
-	aggregationStat string,
-	enableAlignTimeWindow bool) (*csv.CsvWriter, time.Time, error) {
+	aggregationStats []string,
+	outputFormat string,
+	observers ...samples.Sink) (samples.Writer, error) {
 
-	// Truncate time to given resolution
-	from, to := computeTimeAlignment(resolution, timeWindowInMinutes, enableAlignTimeWindow)
+	// Open output writer. Wide layout columns are computed on close, when all things have been received.
+	collector := newThingCollector(a.expandComplexValues)
+	writer, err := newSamplesWriter(outputFormat, from, a.logger, resolution, aggregationStats, a.expandComplexValues, collector.propertyNames)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := a.outputSink(outputFormat, from, resolution, writer, observers)
+	if err := a.exportThings(ctx, from, to, things, resolution, aggregationStats, sink, collector.add); err != nil {
+		return writer, err
+	}
+	return writer, nil
+}
 
-	// Open csv output writer
-	writer, err := csv.NewWriter(from, a.logger, isRawResolution(resolution))
+// ExportTSWindowToStream exports samples of the given time window to the given stream, in the given output format.
+// Things are exported as they are received, until the channel is closed.
+func (a *TsExtractor) ExportTSWindowToStream(
+	ctx context.Context,
+	from, to time.Time,
+	things <-chan iotclient.ArduinoThing,
+	resolution int,
+	aggregationStats []string,
+	outputFormat string,
+	out io.Writer,
+	observers ...samples.Sink) error {
+
+	collector := newThingCollector(a.expandComplexValues)
+	writer, closer, err := newSamplesStreamWriter(outputFormat, out, a.logger, resolution, aggregationStats, a.expandComplexValues, collector.propertyNames)
 	if err != nil {
-		return nil, from, err
+		return err
 	}
+	// Writer is finalized also on things failures, that could be tolerated by caller
+	sink := a.outputSink(outputFormat, from, resolution, writer, observers)
+	err = a.exportThings(ctx, from, to, things, resolution, aggregationStats, sink, collector.add)
+	if closeErr := closer.Close(); closeErr != nil {
+		// An incomplete stream (for example without parquet footer) is unusable, even if things failures are tolerated
+		return fmt.Errorf("failed to close output stream: %w", closeErr)
+	}
+	return err
+}
+
+// ExportTSWindowToFiles exports samples of the given time window to one local file per partition (for example, per thing).
+// Partitions are exported in batches, closing their files once a batch is complete, so that open files are bounded.
+// Returned writer is closed, also when error is an ExportError.
+func (a *TsExtractor) ExportTSWindowToFiles(
+	ctx context.Context,
+	from, to time.Time,
+	thingsMap map[string]iotclient.ArduinoThing,
+	resolution int,
+	aggregationStats []string,
+	outputFormat string,
+	partition samples.PartitionFunc,
+	observers ...samples.Sink) (*samples.PartitionedWriter, error) {
+
+	columns := func() []string { return propertyNames(thingsMap, a.expandComplexValues) }
+	writer := samples.NewPartitionedWriter(partition, func(string) (samples.Writer, error) {
+		return newSamplesWriter(outputFormat, from, a.logger, resolution, aggregationStats, a.expandComplexValues, columns)
+	})
+
+	sink := a.outputSink(outputFormat, from, resolution, writer, observers)
+	failures := []ThingFailure{}
+	for _, batch := range partitionBatches(thingsMap, partition, maxOpenPartitions) {
+		err := a.exportThings(ctx, from, to, ThingsChannel(batch), resolution, aggregationStats, sink, nil)
+		var exportErr *ExportError
+		if err != nil && !errors.As(err, &exportErr) {
+			writer.Close()
+			return writer, err
+		}
+		if exportErr != nil {
+			failures = append(failures, exportErr.Failures...)
+		}
+		// Partitions of the batch are complete: close them to release their files
+		if err := writer.Close(); err != nil {
+			return writer, fmt.Errorf("failed to close output files: %w", err)
+		}
+	}
+	if len(failures) > 0 {
+		slices.SortFunc(failures, func(a, b ThingFailure) int { return strings.Compare(a.ThingID, b.ThingID) })
+		return writer, &ExportError{Failures: failures}
+	}
+	return writer, nil
+}
+
+// partitionBatches groups things by partition, returning batches of things of at most size partitions
+func partitionBatches(thingsMap map[string]iotclient.ArduinoThing, partition samples.PartitionFunc, size int) []map[string]iotclient.ArduinoThing {
+	partitions := map[string]map[string]iotclient.ArduinoThing{}
+	for id, thing := range thingsMap {
+		key := partition(samples.Sample{ThingID: id})
+		if partitions[key] == nil {
+			partitions[key] = map[string]iotclient.ArduinoThing{}
+		}
+		partitions[key][id] = thing
+	}
+	keys := make([]string, 0, len(partitions))
+	for key := range partitions {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	batches := []map[string]iotclient.ArduinoThing{}
+	for start := 0; start < len(keys); start += size {
+		batch := map[string]iotclient.ArduinoThing{}
+		for _, key := range keys[start:min(start+size, len(keys))] {
+			maps.Copy(batch, partitions[key])
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// outputSink returns the sink pushing samples to writer and observers, adapted to the output format
+func (a *TsExtractor) outputSink(outputFormat string, from time.Time, resolution int, writer samples.Sink, observers []samples.Sink) samples.Sink {
+	sink := a.expandingSink(outputFormat, withObservers(writer, observers))
+	if outputFormat == OutputFormatCSVWide && !isRawResolution(resolution) {
+		sink = newLastValueAligningSink(sink, from, resolution)
+	}
+	return sink
+}
+
+// lastValueAligningSink moves last value samples to the aggregation interval containing their update time,
+// or to the first interval of the time window when updated earlier, so that wide layout rows stay on the resolution grid
+type lastValueAligningSink struct {
+	sink       samples.Sink
+	first      time.Time
+	resolution time.Duration
+}
+
+func newLastValueAligningSink(sink samples.Sink, from time.Time, resolution int) *lastValueAligningSink {
+	interval := time.Duration(resolution) * time.Second
+	first := from.Truncate(interval)
+	if first.Before(from) {
+		first = first.Add(interval)
+	}
+	return &lastValueAligningSink{sink: sink, first: first, resolution: interval}
+}
+
+func (l *lastValueAligningSink) Write(toWrite []samples.Sample) error {
+	aligned := make([]samples.Sample, 0, len(toWrite))
+	for _, sample := range toWrite {
+		if sample.Aggregation == lastValueAggregation {
+			sample.Time = sample.Time.Truncate(l.resolution)
+			if sample.Time.Before(l.first) {
+				sample.Time = l.first
+			}
+		}
+		aligned = append(aligned, sample)
+	}
+	return l.sink.Write(aligned)
+}
+
+// expandingSink returns a sink expanding complex values, when enabled, before pushing them to sink.
+// Long csv layout writes sub-fields as columns of the complex value row, so its samples are not expanded.
+func (a *TsExtractor) expandingSink(outputFormat string, sink samples.Sink) samples.Sink {
+	if !a.expandComplexValues || outputFormat == OutputFormatCSV || outputFormat == "" {
+		return sink
+	}
+	return samples.ExpandComplexValues(sink)
+}
+
+// withObservers returns a sink pushing samples to writer and, once written, to observers
+func withObservers(writer samples.Sink, observers []samples.Sink) samples.Sink {
+	if len(observers) == 0 {
+		return writer
+	}
+	return samples.MultiSink(append([]samples.Sink{writer}, observers...)...)
+}
+
+// ThingFailure describes the export failure of a single thing
+type ThingFailure struct {
+	ThingID   string `json:"thing_id"`
+	ThingName string `json:"thing_name"`
+	Error     string `json:"error"`
+}
+
+func newThingFailure(thing iotclient.ArduinoThing, err error) ThingFailure {
+	return ThingFailure{ThingID: thing.Id, ThingName: thing.Name, Error: err.Error()}
+}
+
+// ExportError is returned when export of one or more things failed.
+// Samples of the other things have been pushed to sink anyway.
+type ExportError struct {
+	Failures []ThingFailure
+}
+
+func (e *ExportError) Error() string {
+	ids := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		ids = append(ids, failure.ThingID)
+	}
+	return fmt.Sprintf("export failed for %d things: %s", len(e.Failures), strings.Join(ids, ", "))
+}
+
+// exportThings is the extraction loop. Received, if not nil, is notified of every thing read from the channel.
+func (a *TsExtractor) exportThings(
+	ctx context.Context,
+	from, to time.Time,
+	things <-chan iotclient.ArduinoThing,
+	resolution int,
+	aggregationStats []string,
+	sink samples.Sink,
+	received func(iotclient.ArduinoThing)) error {
+
+	timeWindowInMinutes := int(to.Sub(from).Minutes())
 
 	var wg sync.WaitGroup
 	tokens := make(chan struct{}, importConcurrency)
-	errorChannel := make(chan error, len(thingsMap))
+	var failuresMu sync.Mutex
+	failures := []ThingFailure{}
+	fail := func(failure ThingFailure) {
+		failuresMu.Lock()
+		defer failuresMu.Unlock()
+		failures = append(failures, failure)
+	}
 
 	if isRawResolution(resolution) {
 		a.logger.Infoln("=====> Exporting data. Time window: ", timeWindowInMinutes, "m (resolution: ", resolution, "s). From ", from, " to ", to, " - aggregation: raw")
 	} else {
-		a.logger.Infoln("=====> Exporting data. Time window: ", timeWindowInMinutes, "m (resolution: ", resolution, "s). From ", from, " to ", to, " - aggregation: ", aggregationStat)
+		a.logger.Infoln("=====> Exporting data. Time window: ", timeWindowInMinutes, "m (resolution: ", resolution, "s). From ", from, " to ", to, " - aggregation: ", strings.Join(aggregationStats, ","))
 	}
-	for _, thing := range thingsMap {
-
+	for thing := range things {
+		if received != nil {
+			received(thing)
+		}
 		if len(thing.Properties) == 0 {
 			a.logger.Warn("Skipping thing with no properties: ", thing.Id)
 			continue
@@ -106,7 +520,7 @@ func (a *TsExtractor) ExportTSToFile(
 		tokens <- struct{}{}
 		wg.Add(1)
 
-		go func(thing iotclient.ArduinoThing, writer *csv.CsvWriter) {
+		go func(thing iotclient.ArduinoThing, sink samples.Sink) {
 			defer func() { <-tokens }()
 			defer wg.Done()
 
@@ -114,78 +528,88 @@ func (a *TsExtractor) ExportTSToFile(
 			isRaw := isRawResolution(resolution)
 			if isRaw {
 				// Populate raw time series data
-				populatedProperties, err := a.populateRawTSDataIntoS3(ctx, from, to, thing, writer)
+				var propertyIDs []string
+				if a.byProperty {
+					propertyIDs = propertyIDsOf(thing)
+				}
+				populatedProperties, err := a.populateRawTSDataIntoS3(ctx, from, to, thing, propertyIDs, "", sink)
 				if err != nil {
 					a.logger.Error("Error populating raw time series data: ", err)
-					errorChannel <- err
+					fail(newThingFailure(thing, err))
 					return
 				}
 				if len(populatedProperties) > 0 {
 					detectedProperties = append(detectedProperties, populatedProperties...)
 				}
 			} else {
+				// Properties are grouped by resolution and statistics, according to property rules
+				plan := a.rules.plan(thing, resolution, aggregationStats, a.byProperty)
+
 				// Populate numeric time series data
-				populatedProperties, err := a.populateNumericTSDataIntoS3(ctx, from, to, thing, resolution, aggregationStat, writer)
-				if err != nil {
-					a.logger.Error("Error populating time series data: ", err)
-					errorChannel <- err
-					return
-				}
-				if len(populatedProperties) > 0 {
-					detectedProperties = append(detectedProperties, populatedProperties...)
+				for _, query := range plan.numeric {
+					populatedProperties, err := a.populateNumericTSDataIntoS3(ctx, from, to, thing, query, sink)
+					if err != nil {
+						a.logger.Error("Error populating time series data: ", err)
+						fail(newThingFailure(thing, err))
+						return
+					}
+					if len(populatedProperties) > 0 {
+						detectedProperties = append(detectedProperties, populatedProperties...)
+					}
 				}
 
 				// Populate string time series data, if any
-				populatedProperties, err = a.populateStringTSDataIntoS3(ctx, from, to, thing, resolution, writer)
-				if err != nil {
-					a.logger.Error("Error populating string time series data: ", err)
-					errorChannel <- err
-					return
+				for _, query := range plan.strings {
+					populatedProperties, err := a.populateStringTSDataIntoS3(ctx, from, to, thing, query.resolution, query.propertyIDs, sink)
+					if err != nil {
+						a.logger.Error("Error populating string time series data: ", err)
+						fail(newThingFailure(thing, err))
+						return
+					}
+					if len(populatedProperties) > 0 {
+						detectedProperties = append(detectedProperties, populatedProperties...)
+					}
 				}
-				if len(populatedProperties) > 0 {
-					detectedProperties = append(detectedProperties, populatedProperties...)
+
+				// Populate raw time series data of properties exported raw by rules, if any
+				if len(plan.raw) > 0 {
+					populatedProperties, err := a.populateRawTSDataIntoS3(ctx, from, to, thing, plan.raw, rawAggregation, sink)
+					if err != nil {
+						a.logger.Error("Error populating raw time series data: ", err)
+						fail(newThingFailure(thing, err))
+						return
+					}
+					if len(populatedProperties) > 0 {
+						detectedProperties = append(detectedProperties, populatedProperties...)
+					}
 				}
 			}
 
 			// Populate last value samples for ON_CHANGE properties, if needed
-			err = a.populateLastValueSamplesForOnChangeProperties(isRaw, thing, detectedProperties, writer)
+			err := a.populateLastValueSamplesForOnChangeProperties(isRaw, thing, detectedProperties, sink)
 			if err != nil {
 				a.logger.Error("Error populating last value data: ", err)
-				errorChannel <- err
+				fail(newThingFailure(thing, err))
 				return
 			}
 
-		}(thing, writer)
+		}(thing, sink)
 	}
 
 	// Wait for all routines termination
 	a.logger.Infoln("Waiting for all data extraction jobs to terminate...")
 	wg.Wait()
-	close(errorChannel)
 
 	// Check if there were errors
-	detectedErrors := false
-	for err := range errorChannel {
-		if err != nil {
-			a.logger.Error(err)
-		}
+	for _, failure := range failures {
+		a.logger.Errorf("Export of thing %s (%s) failed: %s\n", failure.ThingID, failure.ThingName, failure.Error)
 	}
-	if detectedErrors {
-		return writer, from, errors.New("errors detected during data export")
+	if len(failures) > 0 {
+		slices.SortFunc(failures, func(a, b ThingFailure) int { return strings.Compare(a.ThingID, b.ThingID) })
+		return &ExportError{Failures: failures}
 	}
 
-	return writer, from, nil
-}
-
-func randomRateLimitingSleep() {
-	// Random sleep to avoid rate limiting (1s + random(0-500ms))
-	n, err := rand.Int(rand.Reader, big.NewInt(500))
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
-	}
-	randomSleep := n.Int64() + 1000
-	time.Sleep(time.Duration(randomSleep) * time.Millisecond)
+	return nil
 }
 
 func (a *TsExtractor) populateNumericTSDataIntoS3(
@@ -193,26 +617,30 @@ func (a *TsExtractor) populateNumericTSDataIntoS3(
 	from time.Time,
 	to time.Time,
 	thing iotclient.ArduinoThing,
-	resolution int,
-	aggregationStat string,
-	writer *csv.CsvWriter) ([]string, error) {
+	query propertyQuery,
+	sink samples.Sink) ([]string, error) {
 
+	resolution := query.resolution
 	if resolution <= 60 {
 		resolution = 60
 	}
+	aggregationStats := query.aggregationStats
 
 	populatedProperties := []string{}
 	var batched *iotclient.ArduinoSeriesBatch
 	var err error
 	var retry bool
 	for i := 0; i < retryCount; i++ {
-		batched, retry, err = a.iotcl.GetTimeSeriesByThing(ctx, thing.Id, from, to, int64(resolution), aggregationStat)
+		if query.propertyIDs == nil {
+			batched, retry, err = a.iotcl.GetTimeSeriesByThing(ctx, thing.Id, from, to, int64(resolution), aggregationStats)
+		} else {
+			batched, retry, err = a.iotcl.GetTimeSeriesByProperties(ctx, query.propertyIDs, from, to, int64(resolution), aggregationStats)
+		}
 		if !retry {
 			break
 		} else {
-			// This is due to a rate limit on the IoT API, we need to wait a bit before retrying
-			a.logger.Warnf("Rate limit reached for thing %s. Waiting 1 second before retrying.\n", thing.Id)
-			randomRateLimitingSleep()
+			// This is due to a rate limit on the IoT API: client waits for backoff before next call
+			a.logger.Warnf("Rate limit reached for thing %s. Retrying after backoff.\n", thing.Id)
 		}
 	}
 	if err != nil {
@@ -220,14 +648,19 @@ func (a *TsExtractor) populateNumericTSDataIntoS3(
 	}
 
 	sampleCount := int64(0)
-	samples := [][]string{}
+	extracted := []samples.Sample{}
 	for _, response := range batched.Responses {
 		if response.CountValues == 0 {
 			continue
 		}
 
 		propertyID := strings.Replace(response.Query, "property.", "", 1)
-		a.logger.Debugf("Thing %s - Property %s - %d values\n", thing.Id, propertyID, response.CountValues)
+		aggregationStat, ok := responseAggregation(response, aggregationStats)
+		if !ok {
+			a.logger.Warnf("Thing %s - Property %s - unable to detect aggregation statistic of %d values, skipping them\n", thing.Id, propertyID, response.CountValues)
+			continue
+		}
+		a.logger.Debugf("Thing %s - Property %s - %s - %d values\n", thing.Id, propertyID, aggregationStat, response.CountValues)
 		sampleCount += response.CountValues
 
 		propertyName, propertyType := extractPropertyNameAndType(thing, propertyID)
@@ -239,13 +672,13 @@ func (a *TsExtractor) populateNumericTSDataIntoS3(
 			if !slices.Contains(populatedProperties, propertyID) {
 				populatedProperties = append(populatedProperties, propertyID)
 			}
-			samples = append(samples, composeRow(ts, thing.Id, thing.Name, propertyID, propertyName, propertyType, strconv.FormatFloat(value, 'f', -1, 64), aggregationStat))
+			extracted = append(extracted, composeSample(ts, thing, propertyID, propertyName, propertyType, value, aggregationStat))
 		}
 	}
 
-	// Write samples to csv ouput file
-	if len(samples) > 0 {
-		if err := writer.Write(samples); err != nil {
+	// Write samples to output sink
+	if len(extracted) > 0 {
+		if err := sink.Write(extracted); err != nil {
 			return nil, err
 		}
 		a.logger.Debugf("Thing %s [%s] saved %d values\n", thing.Id, thing.Name, sampleCount)
@@ -254,29 +687,28 @@ func (a *TsExtractor) populateNumericTSDataIntoS3(
 	return populatedProperties, nil
 }
 
-func composeRow(ts time.Time, thingID string, thingName string, propertyID string, propertyName string, propertyType string, value string, aggregation string) []string {
-	row := make([]string, 8)
-	row[0] = ts.UTC().Format(time.RFC3339)
-	row[1] = thingID
-	row[2] = thingName
-	row[3] = propertyID
-	row[4] = propertyName
-	row[5] = propertyType
-	row[6] = value
-	row[7] = aggregation
-	return row
+// responseAggregation returns the statistic of a batch query response. Statistic is always known when a single one is requested.
+func responseAggregation(response iotclient.ArduinoSeriesResponse, aggregationStats []string) (string, bool) {
+	if response.Aggregation != nil && *response.Aggregation != "" {
+		return *response.Aggregation, true
+	}
+	if len(aggregationStats) == 1 {
+		return aggregationStats[0], true
+	}
+	return "", false
 }
 
-func composeRawRow(ts time.Time, thingID string, thingName string, propertyID string, propertyName string, propertyType string, value string) []string {
-	row := make([]string, 7)
-	row[0] = ts.UTC().Format(time.RFC3339)
-	row[1] = thingID
-	row[2] = thingName
-	row[3] = propertyID
-	row[4] = propertyName
-	row[5] = propertyType
-	row[6] = value
-	return row
+func composeSample(ts time.Time, thing iotclient.ArduinoThing, propertyID string, propertyName string, propertyType string, value any, aggregation string) samples.Sample {
+	return samples.Sample{
+		Time:         ts.UTC(),
+		ThingID:      thing.Id,
+		ThingName:    thing.Name,
+		PropertyID:   propertyID,
+		PropertyName: propertyName,
+		PropertyType: propertyType,
+		Value:        value,
+		Aggregation:  aggregation,
+	}
 }
 
 func extractPropertyNameAndType(thing iotclient.ArduinoThing, propertyID string) (string, string) {
@@ -305,15 +737,8 @@ func (a *TsExtractor) populateStringTSDataIntoS3(
 	to time.Time,
 	thing iotclient.ArduinoThing,
 	resolution int,
-	writer *csv.CsvWriter) ([]string, error) {
-
-	// Filter properties by char type
-	stringProperties := []string{}
-	for _, prop := range thing.Properties {
-		if isStringProperty(prop.Type) {
-			stringProperties = append(stringProperties, prop.Id)
-		}
-	}
+	stringProperties []string,
+	sink samples.Sink) ([]string, error) {
 
 	if len(stringProperties) == 0 {
 		return nil, nil
@@ -328,9 +753,8 @@ func (a *TsExtractor) populateStringTSDataIntoS3(
 		if !retry {
 			break
 		} else {
-			// This is due to a rate limit on the IoT API, we need to wait a bit before retrying
-			a.logger.Warnf("Rate limit reached for thing %s. Waiting 1 second before retrying.\n", thing.Id)
-			randomRateLimitingSleep()
+			// This is due to a rate limit on the IoT API: client waits for backoff before next call
+			a.logger.Warnf("Rate limit reached for thing %s. Retrying after backoff.\n", thing.Id)
 		}
 	}
 	if err != nil {
@@ -338,7 +762,7 @@ func (a *TsExtractor) populateStringTSDataIntoS3(
 	}
 
 	sampleCount := int64(0)
-	samples := [][]string{}
+	extracted := []samples.Sample{}
 	for _, response := range batched.Responses {
 		if response.CountValues == 0 {
 			continue
@@ -346,27 +770,35 @@ func (a *TsExtractor) populateStringTSDataIntoS3(
 
 		propertyID := strings.Replace(response.Query, "property.", "", 1)
 		a.logger.Debugf("Thing %s - String Property %s - %d values\n", thing.Id, propertyID, response.CountValues)
-		sampleCount += response.CountValues
+
+		// Series cut by the series limit are completed querying the rest of the time window
+		page := seriesPage{times: response.Times, values: response.Values, truncated: isTruncated(response.CountValues, response.SeriesLimit)}
+		if page.truncated {
+			if page, err = a.completeSeries(thing, propertyID, page, to, a.nextSampledPage(ctx, thing, propertyID, to, resolution)); err != nil {
+				return nil, err
+			}
+		}
+		sampleCount += int64(len(page.times))
 
 		propertyName, propertyType := extractPropertyNameAndType(thing, propertyID)
 
-		for i := 0; i < len(response.Times); i++ {
+		for i := 0; i < len(page.times); i++ {
 
-			ts := response.Times[i]
-			value := response.Values[i]
+			ts := page.times[i]
+			value := page.values[i]
 			if value == nil {
 				continue
 			}
 			if !slices.Contains(populatedProperties, propertyID) {
 				populatedProperties = append(populatedProperties, propertyID)
 			}
-			samples = append(samples, composeRow(ts, thing.Id, thing.Name, propertyID, propertyName, propertyType, a.interfaceToString(value), "SAMPLED"))
+			extracted = append(extracted, composeSample(ts, thing, propertyID, propertyName, propertyType, value, "SAMPLED"))
 		}
 	}
 
-	// Write samples to csv ouput file
-	if len(samples) > 0 {
-		if err := writer.Write(samples); err != nil {
+	// Write samples to output sink
+	if len(extracted) > 0 {
+		if err := sink.Write(extracted); err != nil {
 			return nil, err
 		}
 		a.logger.Debugf("Thing %s [%s] string properties saved %d values\n", thing.Id, thing.Name, sampleCount)
@@ -380,20 +812,25 @@ func (a *TsExtractor) populateRawTSDataIntoS3(
 	from time.Time,
 	to time.Time,
 	thing iotclient.ArduinoThing,
-	writer *csv.CsvWriter) ([]string, error) {
+	propertyIDs []string,
+	aggregation string,
+	sink samples.Sink) ([]string, error) {
 
 	populatedProperties := []string{}
 	var batched *iotclient.ArduinoSeriesRawBatch
 	var err error
 	var retry bool
 	for i := 0; i < retryCount; i++ {
-		batched, retry, err = a.iotcl.GetRawTimeSeriesByThing(ctx, thing.Id, from, to)
+		if propertyIDs == nil {
+			batched, retry, err = a.iotcl.GetRawTimeSeriesByThing(ctx, thing.Id, from, to)
+		} else {
+			batched, retry, err = a.iotcl.GetRawTimeSeriesByProperties(ctx, propertyIDs, from, to)
+		}
 		if !retry {
 			break
 		} else {
-			// This is due to a rate limit on the IoT API, we need to wait a bit before retrying
-			a.logger.Warnf("Rate limit reached for thing %s. Waiting 1 second before retrying.\n", thing.Id)
-			randomRateLimitingSleep()
+			// This is due to a rate limit on the IoT API: client waits for backoff before next call
+			a.logger.Warnf("Rate limit reached for thing %s. Retrying after backoff.\n", thing.Id)
 		}
 	}
 	if err != nil {
@@ -401,7 +838,7 @@ func (a *TsExtractor) populateRawTSDataIntoS3(
 	}
 
 	sampleCount := int64(0)
-	samples := [][]string{}
+	extracted := []samples.Sample{}
 	for _, response := range batched.Responses {
 		if response.CountValues == 0 {
 			continue
@@ -409,27 +846,35 @@ func (a *TsExtractor) populateRawTSDataIntoS3(
 
 		propertyID := strings.Replace(response.Query, "property.", "", 1)
 		a.logger.Debugf("Thing %s - Query %s Property %s - %d values\n", thing.Id, response.Query, propertyID, response.CountValues)
-		sampleCount += response.CountValues
+
+		// Series cut by the series limit are completed querying the rest of the time window
+		page := seriesPage{times: response.Times, values: response.Values, truncated: isTruncated(response.CountValues, response.SeriesLimit)}
+		if page.truncated {
+			if page, err = a.completeSeries(thing, propertyID, page, to, a.nextRawPage(ctx, thing, propertyID, to)); err != nil {
+				return nil, err
+			}
+		}
+		sampleCount += int64(len(page.times))
 
 		propertyName, propertyType := extractPropertyNameAndType(thing, propertyID)
 
-		for i := 0; i < len(response.Times); i++ {
+		for i := 0; i < len(page.times); i++ {
 
-			ts := response.Times[i]
-			value := response.Values[i]
+			ts := page.times[i]
+			value := page.values[i]
 			if value == nil {
 				continue
 			}
 			if !slices.Contains(populatedProperties, propertyID) {
 				populatedProperties = append(populatedProperties, propertyID)
 			}
-			samples = append(samples, composeRawRow(ts, thing.Id, thing.Name, propertyID, propertyName, propertyType, a.interfaceToString(value)))
+			extracted = append(extracted, composeSample(ts, thing, propertyID, propertyName, propertyType, value, aggregation))
 		}
 	}
 
-	// Write samples to csv ouput file
-	if len(samples) > 0 {
-		if err := writer.Write(samples); err != nil {
+	// Write samples to output sink
+	if len(extracted) > 0 {
+		if err := sink.Write(extracted); err != nil {
 			return nil, err
 		}
 		a.logger.Debugf("Thing %s [%s] raw data saved %d values\n", thing.Id, thing.Name, sampleCount)
@@ -438,26 +883,12 @@ func (a *TsExtractor) populateRawTSDataIntoS3(
 	return populatedProperties, nil
 }
 
-func (a *TsExtractor) interfaceToString(value interface{}) string {
-	switch v := value.(type) {
-	case string:
-		return v
-	case int:
-		return strconv.Itoa(v)
-	case float64:
-		return strconv.FormatFloat(v, 'f', -1, 64)
-	case bool:
-		return strconv.FormatBool(v)
-	case map[string]any:
-		encoded, err := json.Marshal(v)
-		if err != nil {
-			a.logger.Error("Error encoding map to json: ", err)
-			return fmt.Sprintf("%v", v)
-		}
-		return string(encoded)
-	default:
-		return fmt.Sprintf("%v", v)
+func propertyIDsOf(thing iotclient.ArduinoThing) []string {
+	ids := make([]string, 0, len(thing.Properties))
+	for _, prop := range thing.Properties {
+		ids = append(ids, prop.Id)
 	}
+	return ids
 }
 
 func isLastValueAllowedProperty(prop iotclient.ArduinoProperty) bool {
@@ -468,13 +899,13 @@ func (a *TsExtractor) populateLastValueSamplesForOnChangeProperties(
 	isRaw bool,
 	thing iotclient.ArduinoThing,
 	propertiesWithExtractedValue []string,
-	writer *csv.CsvWriter) error {
+	sink samples.Sink) error {
 
 	// Check if there are ON_CHANGE properties
 	if len(thing.Properties) == 0 {
 		return nil
 	}
-	samples := [][]string{}
+	extracted := []samples.Sample{}
 	sampleCount := 0
 	for _, prop := range thing.Properties {
 		if isLastValueAllowedProperty(prop) && !slices.Contains(propertiesWithExtractedValue, prop.Id) {
@@ -482,20 +913,18 @@ func (a *TsExtractor) populateLastValueSamplesForOnChangeProperties(
 				continue
 			}
 			propName, propType := extractPropertyNameAndType(thing, prop.Id)
-			var toAdd []string
+			aggregation := lastValueAggregation
 			if isRaw {
-				toAdd = composeRawRow(*prop.ValueUpdatedAt, thing.Id, thing.Name, prop.Id, propName, propType, a.interfaceToString(prop.LastValue))
-			} else {
-				toAdd = composeRow(*prop.ValueUpdatedAt, thing.Id, thing.Name, prop.Id, propName, propType, a.interfaceToString(prop.LastValue), "LAST_VALUE")
+				aggregation = ""
 			}
-			samples = append(samples, toAdd)
+			extracted = append(extracted, composeSample(*prop.ValueUpdatedAt, thing, prop.Id, propName, propType, prop.LastValue, aggregation))
 			sampleCount++
 		}
 	}
 
-	// Write samples to csv ouput file
-	if len(samples) > 0 {
-		if err := writer.Write(samples); err != nil {
+	// Write samples to output sink
+	if len(extracted) > 0 {
+		if err := sink.Write(extracted); err != nil {
 			return err
 		}
 		a.logger.Debugf("Thing %s [%s] last value data saved %d values\n", thing.Id, thing.Name, sampleCount)
@@ -1,15 +1,23 @@
 package tsextractor
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	iotMocks "github.com/arduino/aws-s3-integration/internal/iot/mocks"
+	"github.com/arduino/aws-s3-integration/internal/parquet"
+	"github.com/arduino/aws-s3-integration/internal/samples"
 	iotclient "github.com/arduino/iot-client-go/v2"
+	pq "github.com/parquet-go/parquet-go"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -59,6 +67,54 @@ func TestTimeAlignment_raw_HourlyTimeWindows(t *testing.T) {
 	assert.Equal(t, int64(3600), to.Unix()-from.Unix())
 }
 
+func TestTimeWindows_noWatermark(t *testing.T) {
+	from, to := computeTimeAlignment(300, 60, true)
+	windows := ComputeTimeWindows(300, 60, true, nil, 24)
+	assert.Len(t, windows, 1)
+	assert.Equal(t, from, windows[0].From)
+	assert.Equal(t, to, windows[0].To)
+}
+
+func TestTimeWindows_catchUpFromWatermark(t *testing.T) {
+	// Last 3 hourly executions have been missed
+	_, to := computeTimeAlignment(300, 60, true)
+	watermark := to.Add(-3 * time.Hour)
+	windows := ComputeTimeWindows(300, 60, true, &watermark, 24)
+	assert.Len(t, windows, 3)
+	assert.Equal(t, watermark, windows[0].From)
+	for i := 1; i < len(windows); i++ {
+		assert.Equal(t, windows[i-1].To, windows[i].From)
+	}
+	assert.Equal(t, to, windows[2].To)
+}
+
+func TestTimeWindows_catchUpIsBounded(t *testing.T) {
+	_, to := computeTimeAlignment(300, 60, true)
+	watermark := to.Add(-48 * time.Hour)
+	windows := ComputeTimeWindows(300, 60, true, &watermark, 24)
+	assert.Len(t, windows, 24)
+	assert.Equal(t, watermark, windows[0].From)
+	assert.Equal(t, watermark.Add(24*time.Hour), windows[23].To)
+}
+
+func TestTimeWindows_alreadyExported(t *testing.T) {
+	// A retried execution must not export the same window twice
+	_, to := computeTimeAlignment(300, 60, true)
+	windows := ComputeTimeWindows(300, 60, true, &to, 24)
+	assert.Len(t, windows, 0)
+}
+
+func TestTimeWindows_backfillSplit(t *testing.T) {
+	from := time.Date(2024, 9, 1, 10, 20, 0, 0, time.UTC)
+	to := time.Date(2024, 9, 1, 13, 30, 0, 0, time.UTC)
+	windows := SplitTimeWindows(from, to, 60)
+	assert.Len(t, windows, 4)
+	assert.Equal(t, time.Date(2024, 9, 1, 10, 0, 0, 0, time.UTC), windows[0].From)
+	assert.Equal(t, time.Date(2024, 9, 1, 11, 0, 0, 0, time.UTC), windows[0].To)
+	assert.Equal(t, time.Date(2024, 9, 1, 13, 0, 0, 0, time.UTC), windows[3].From)
+	assert.Equal(t, to, windows[3].To)
+}
+
 func toPtr(val string) *string {
 	return &val
 }
@@ -89,7 +145,7 @@ func TestExtractionFlow_defaultAggregation(t *testing.T) {
 	samples := iotclient.ArduinoSeriesBatch{
 		Responses: responses,
 	}
-	iotcl.On("GetTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything, int64(300), "AVG").Return(&samples, false, nil)
+	iotcl.On("GetTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&samples, false, nil)
 
 	// Time series sampling mock
 	sampledResponse := []iotclient.ArduinoSeriesSampledResponse{
@@ -105,7 +161,7 @@ func TestExtractionFlow_defaultAggregation(t *testing.T) {
 	}
 	iotcl.On("GetTimeSeriesStringSampling", ctx, []string{propertyStringId}, mock.Anything, mock.Anything, int32(300)).Return(&samplesSampled, false, nil)
 
-	tsextractorClient := New(iotcl, logger)
+	tsextractorClient := New(iotcl, logger, nil, false, false)
 
 	lastValueTime := now.Add(-time.Minute * 1)
 	propCount := int64(3)
@@ -136,10 +192,10 @@ func TestExtractionFlow_defaultAggregation(t *testing.T) {
 		PropertiesCount: &propCount,
 	}
 
-	writer, from, err := tsextractorClient.ExportTSToFile(ctx, 60, thingsMap, 300, "AVG", false)
+	from, to := computeTimeAlignment(300, 60, false)
+	writer, err := tsextractorClient.ExportTSWindowToFile(ctx, from, to, ThingsChannel(thingsMap), 300, []string{"AVG"}, OutputFormatCSV)
 	assert.NoError(t, err)
 	assert.NotNil(t, writer)
-	assert.NotNil(t, from)
 
 	writer.Close()
 	defer writer.Delete()
@@ -200,7 +256,7 @@ func TestExtractionFlow_rawResolution(t *testing.T) {
 	}
 	iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything).Return(&samples, false, nil)
 
-	tsextractorClient := New(iotcl, logger)
+	tsextractorClient := New(iotcl, logger, nil, false, false)
 
 	lastValueTime := now.Add(-time.Minute * 1)
 	propCount := int64(3)
@@ -239,10 +295,10 @@ func TestExtractionFlow_rawResolution(t *testing.T) {
 		PropertiesCount: &propCount,
 	}
 
-	writer, from, err := tsextractorClient.ExportTSToFile(ctx, 60, thingsMap, -1, "", false)
+	from, to := computeTimeAlignment(-1, 60, false)
+	writer, err := tsextractorClient.ExportTSWindowToFile(ctx, from, to, ThingsChannel(thingsMap), -1, nil, OutputFormatCSV)
 	assert.NoError(t, err)
 	assert.NotNil(t, writer)
-	assert.NotNil(t, from)
 
 	writer.Close()
 	defer writer.Delete()
@@ -269,3 +325,720 @@ func TestExtractionFlow_rawResolution(t *testing.T) {
 		assert.NotContains(t, string(content), "pNOIMPORT")
 	}
 }
+
+func TestExtractionFlow_parquetOutput(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+	propertyStringId := "a86f4ed9-7f52-4bd3-bdc6-b2936bec68bb"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Now().Truncate(time.Second)
+	responses := []iotclient.ArduinoSeriesResponse{
+		{
+			Aggregation: toPtr("AVG"),
+			Query:       fmt.Sprintf("property.%s", propertyId),
+			Times:       []time.Time{now.Add(-time.Minute * 1), now},
+			Values:      []float64{1.5, 2.0},
+			CountValues: 2,
+		},
+	}
+	samples := iotclient.ArduinoSeriesBatch{
+		Responses: responses,
+	}
+	iotcl.On("GetTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&samples, false, nil)
+
+	sampledResponse := []iotclient.ArduinoSeriesSampledResponse{
+		{
+			Query:       fmt.Sprintf("property.%s", propertyStringId),
+			Times:       []time.Time{now},
+			Values:      []any{"a"},
+			CountValues: 1,
+		},
+	}
+	samplesSampled := iotclient.ArduinoSeriesBatchSampled{
+		Responses: sampledResponse,
+	}
+	iotcl.On("GetTimeSeriesStringSampling", ctx, []string{propertyStringId}, mock.Anything, mock.Anything, int32(300)).Return(&samplesSampled, false, nil)
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := make(map[string]iotclient.ArduinoThing)
+	thingsMap[thingId] = iotclient.ArduinoThing{
+		Id:   thingId,
+		Name: "test",
+		Properties: []iotclient.ArduinoProperty{
+			{
+				Name: "ptest",
+				Id:   propertyId,
+				Type: "FLOAT",
+			},
+			{
+				Name: "pstringVar",
+				Id:   propertyStringId,
+				Type: "CHARSTRING",
+			},
+		},
+	}
+
+	from, to := computeTimeAlignment(300, 60, false)
+	writer, err := tsextractorClient.ExportTSWindowToFile(ctx, from, to, ThingsChannel(thingsMap), 300, []string{"AVG"}, OutputFormatParquet)
+	assert.NoError(t, err)
+	assert.NotNil(t, writer)
+
+	writer.Close()
+	defer writer.Delete()
+
+	rows, err := pq.ReadFile[parquet.Row](writer.GetFilePath())
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	for _, row := range rows {
+		assert.Equal(t, thingId, row.ThingID)
+		switch row.PropertyID {
+		case propertyId:
+			assert.NotNil(t, row.Value)
+			assert.Nil(t, row.ValueString)
+			assert.Equal(t, "AVG", *row.AggregationStatistic)
+		case propertyStringId:
+			assert.Nil(t, row.Value)
+			assert.Equal(t, "a", *row.ValueString)
+			assert.Equal(t, now.UTC(), row.Timestamp.UTC())
+		default:
+			t.Errorf("unexpected property %s", row.PropertyID)
+		}
+	}
+}
+
+type memorySink struct {
+	lock    sync.Mutex
+	samples []samples.Sample
+}
+
+func (m *memorySink) Write(toWrite []samples.Sample) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.samples = append(m.samples, toWrite...)
+	return nil
+}
+
+// exportToSink exports the given things with the streaming export, collecting written samples in sink
+func exportToSink(ctx context.Context, a *TsExtractor, from, to time.Time, thingsMap map[string]iotclient.ArduinoThing, resolution int, aggregationStats []string, sink *memorySink) error {
+	return a.ExportTSWindowToStream(ctx, from, to, ThingsChannel(thingsMap), resolution, aggregationStats, OutputFormatJSONL, io.Discard, sink)
+}
+
+func TestExtractionFlow_customSink(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+	propertyBoolId := "d12f4ed9-7f52-4bd3-bdc6-b2936bec68cc"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Now()
+	responses := []iotclient.ArduinoSeriesRawResponse{
+		{
+			Query:       fmt.Sprintf("property.%s", propertyId),
+			Times:       []time.Time{now},
+			Values:      []any{1.5},
+			CountValues: 1,
+		},
+		{
+			Query:       fmt.Sprintf("property.%s", propertyBoolId),
+			Times:       []time.Time{now},
+			Values:      []any{true},
+			CountValues: 1,
+		},
+	}
+	iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{Responses: responses}, false, nil)
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := make(map[string]iotclient.ArduinoThing)
+	thingsMap[thingId] = iotclient.ArduinoThing{
+		Id:   thingId,
+		Name: "test",
+		Properties: []iotclient.ArduinoProperty{
+			{
+				Name: "ptest",
+				Id:   propertyId,
+				Type: "FLOAT",
+			},
+			{
+				Name: "pbool",
+				Id:   propertyBoolId,
+				Type: "STATUS",
+			},
+		},
+	}
+
+	sink := &memorySink{}
+	err := exportToSink(ctx, tsextractorClient, now.Add(-time.Hour), now, thingsMap, -1, nil, sink)
+	assert.NoError(t, err)
+	assert.Len(t, sink.samples, 2)
+
+	for _, sample := range sink.samples {
+		assert.Equal(t, thingId, sample.ThingID)
+		assert.Equal(t, "test", sample.ThingName)
+		assert.Empty(t, sample.Aggregation)
+		switch sample.PropertyID {
+		case propertyId:
+			assert.Equal(t, "FLOAT", sample.PropertyType)
+			assert.Equal(t, 1.5, sample.Value)
+		case propertyBoolId:
+			assert.Equal(t, "BOOLEAN", sample.PropertyType)
+			assert.Equal(t, true, sample.Value)
+		}
+	}
+}
+
+func TestExtractionFlow_splitByThing(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingIds := []string{"91f30213-2bd7-480a-b1dc-f31b01840e7e", "e2b1a3f2-1b2c-4d5e-8f90-a1b2c3d4e5f6"}
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Now()
+	thingsMap := make(map[string]iotclient.ArduinoThing)
+	for i, thingId := range thingIds {
+		responses := []iotclient.ArduinoSeriesRawResponse{
+			{
+				Query:       fmt.Sprintf("property.%s", propertyId),
+				Times:       []time.Time{now},
+				Values:      []any{float64(i)},
+				CountValues: 1,
+			},
+		}
+		iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{Responses: responses}, false, nil)
+		thingsMap[thingId] = iotclient.ArduinoThing{
+			Id:   thingId,
+			Name: fmt.Sprintf("thing%d", i),
+			Properties: []iotclient.ArduinoProperty{
+				{
+					Name: "ptest",
+					Id:   propertyId,
+					Type: "FLOAT",
+				},
+			},
+		}
+	}
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	byThing := func(sample samples.Sample) string { return sample.ThingID }
+	writer, err := tsextractorClient.ExportTSWindowToFiles(ctx, now.Add(-time.Hour), now, thingsMap, -1, nil, OutputFormatCSV, byThing)
+	assert.NoError(t, err)
+	writer.Close()
+	defer writer.Delete()
+
+	writers := writer.Writers()
+	assert.Len(t, writers, 2)
+	for i, thingId := range thingIds {
+		content, err := os.ReadFile(writers[thingId].GetFilePath())
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), fmt.Sprintf("%s,thing%d,%s,ptest,FLOAT,%d", thingId, i, propertyId, i))
+		assert.NotContains(t, string(content), thingIds[1-i])
+	}
+}
+
+func thingIDs(thingsMap map[string]iotclient.ArduinoThing) []string {
+	ids := []string{}
+	for id := range thingsMap {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestPartitionBatches(t *testing.T) {
+	thingsMap := map[string]iotclient.ArduinoThing{
+		"a1": {Id: "a1", Tags: map[string]any{"site": "a"}},
+		"a2": {Id: "a2", Tags: map[string]any{"site": "a"}},
+		"b1": {Id: "b1", Tags: map[string]any{"site": "b"}},
+		"c1": {Id: "c1", Tags: map[string]any{"site": "c"}},
+	}
+	bySite := func(sample samples.Sample) string { return thingsMap[sample.ThingID].Tags["site"].(string) }
+
+	// Things of the same partition are always exported in the same batch
+	batches := partitionBatches(thingsMap, bySite, 2)
+	assert.Len(t, batches, 2)
+	assert.ElementsMatch(t, []string{"a1", "a2", "b1"}, thingIDs(batches[0]))
+	assert.ElementsMatch(t, []string{"c1"}, thingIDs(batches[1]))
+
+	assert.Len(t, partitionBatches(thingsMap, bySite, maxOpenPartitions), 1)
+	assert.Empty(t, partitionBatches(map[string]iotclient.ArduinoThing{}, bySite, maxOpenPartitions))
+}
+
+func TestExtractionFlow_streamOutput(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Now()
+	responses := []iotclient.ArduinoSeriesRawResponse{
+		{
+			Query:       fmt.Sprintf("property.%s", propertyId),
+			Times:       []time.Time{now},
+			Values:      []any{2.5},
+			CountValues: 1,
+		},
+	}
+	iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{Responses: responses}, false, nil)
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := make(map[string]iotclient.ArduinoThing)
+	thingsMap[thingId] = iotclient.ArduinoThing{
+		Id:   thingId,
+		Name: "test",
+		Properties: []iotclient.ArduinoProperty{
+			{
+				Name: "ptest",
+				Id:   propertyId,
+				Type: "FLOAT",
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	err := tsextractorClient.ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), -1, nil, OutputFormatCSV, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("timestamp,thing_id,thing_name,property_id,property_name,property_type,value\n%s,%s,test,%s,ptest,FLOAT,2.5\n", now.UTC().Format(time.RFC3339), thingId, propertyId), out.String())
+}
+
+func TestExtractionFlow_thingFailuresAreReported(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	okThingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	failingThingId := "e2b1a3f2-1b2c-4d5e-8f90-a1b2c3d4e5f6"
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Now()
+	responses := []iotclient.ArduinoSeriesRawResponse{
+		{
+			Query:       fmt.Sprintf("property.%s", propertyId),
+			Times:       []time.Time{now},
+			Values:      []any{1.0},
+			CountValues: 1,
+		},
+	}
+	iotcl.On("GetRawTimeSeriesByThing", ctx, okThingId, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{Responses: responses}, false, nil)
+	iotcl.On("GetRawTimeSeriesByThing", ctx, failingThingId, mock.Anything, mock.Anything).Return(nil, false, errors.New("internal server error"))
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := make(map[string]iotclient.ArduinoThing)
+	for i, thingId := range []string{okThingId, failingThingId} {
+		thingsMap[thingId] = iotclient.ArduinoThing{
+			Id:   thingId,
+			Name: fmt.Sprintf("thing%d", i),
+			Properties: []iotclient.ArduinoProperty{
+				{
+					Name: "ptest",
+					Id:   propertyId,
+					Type: "FLOAT",
+				},
+			},
+		}
+	}
+
+	sink := &memorySink{}
+	err := exportToSink(ctx, tsextractorClient, now.Add(-time.Hour), now, thingsMap, -1, nil, sink)
+
+	var exportErr *ExportError
+	assert.ErrorAs(t, err, &exportErr)
+	assert.Len(t, exportErr.Failures, 1)
+	assert.Equal(t, failingThingId, exportErr.Failures[0].ThingID)
+	assert.Equal(t, "thing1", exportErr.Failures[0].ThingName)
+	assert.Contains(t, exportErr.Failures[0].Error, "internal server error")
+
+	// Samples of other things are exported anyway
+	assert.Len(t, sink.samples, 1)
+	assert.Equal(t, okThingId, sink.samples[0].ThingID)
+}
+
+// failingWriter is an output stream whose writes always fail
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestExtractionFlow_streamCloseFailureIsFatal(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "e2b1a3f2-1b2c-4d5e-8f90-a1b2c3d4e5f6"
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything).Return(nil, false, errors.New("internal server error"))
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: {Id: thingId, Name: "thing", Properties: []iotclient.ArduinoProperty{{Name: "ptest", Id: "ptest", Type: "FLOAT"}}},
+	}
+
+	// Stream cannot be completed: error must not be tolerated as a things failure
+	now := time.Now()
+	err := New(iotcl, logger, nil, false, false).ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), -1, nil, OutputFormatParquet, failingWriter{})
+	assert.ErrorContains(t, err, "failed to close output stream")
+	var exportErr *ExportError
+	assert.False(t, errors.As(err, &exportErr))
+}
+
+func TestExtractionFlow_jsonlOutput(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+	propertyBoolId := "b86f4ed9-7f52-4bd3-bdc6-b2936bec68ad"
+	propertyLocationId := "a86f4ed9-7f52-4bd3-bdc6-b2936bec68bb"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Date(2024, 10, 1, 10, 30, 0, 0, time.UTC)
+	responses := []iotclient.ArduinoSeriesRawResponse{
+		{
+			Query:       fmt.Sprintf("property.%s", propertyId),
+			Times:       []time.Time{now},
+			Values:      []any{2.5},
+			CountValues: 1,
+		},
+		{
+			Query:       fmt.Sprintf("property.%s", propertyBoolId),
+			Times:       []time.Time{now},
+			Values:      []any{true},
+			CountValues: 1,
+		},
+		{
+			Query:       fmt.Sprintf("property.%s", propertyLocationId),
+			Times:       []time.Time{now},
+			Values:      []any{map[string]any{"lat": 45.07, "lon": 7.68}},
+			CountValues: 1,
+		},
+	}
+	iotcl.On("GetRawTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything).Return(&iotclient.ArduinoSeriesRawBatch{Responses: responses}, false, nil)
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := make(map[string]iotclient.ArduinoThing)
+	thingsMap[thingId] = iotclient.ArduinoThing{
+		Id:   thingId,
+		Name: "test",
+		Properties: []iotclient.ArduinoProperty{
+			{Name: "ptest", Id: propertyId, Type: "FLOAT"},
+			{Name: "pbool", Id: propertyBoolId, Type: "STATUS"},
+			{Name: "plocation", Id: propertyLocationId, Type: "LOCATION"},
+		},
+	}
+
+	var out bytes.Buffer
+	err := tsextractorClient.ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), -1, nil, OutputFormatJSONL, &out)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	values := map[string]any{}
+	for _, line := range lines {
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.Equal(t, thingId, record["thing_id"])
+		assert.Equal(t, "test", record["thing_name"])
+		assert.Equal(t, "2024-10-01T10:30:00Z", record["timestamp"])
+		assert.NotContains(t, record, "aggregation_statistic")
+		values[record["property_name"].(string)] = record["value"]
+	}
+	assert.Equal(t, 2.5, values["ptest"])
+	assert.Equal(t, true, values["pbool"])
+	assert.Equal(t, map[string]any{"lat": 45.07, "lon": 7.68}, values["plocation"])
+}
+
+func TestExtractionFlow_csvWideLastValues(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	temperatureId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: fmt.Sprintf("property.%s", temperatureId), Times: []time.Time{now.Add(-10 * time.Minute)}, Values: []float64{20.5}, CountValues: 1},
+		},
+	}, false, nil)
+
+	// Last values are moved to the interval of their update, or to the first interval when updated before the time window
+	setpointUpdatedAt := now.Add(-8*time.Minute - 13*time.Second)
+	modeUpdatedAt := now.Add(-72 * time.Hour)
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: {
+			Id:   thingId,
+			Name: "thing0",
+			Properties: []iotclient.ArduinoProperty{
+				{Name: "temperature", Id: temperatureId, Type: "FLOAT"},
+				{Name: "setpoint", Id: "setpoint", Type: "FLOAT", UpdateStrategy: "ON_CHANGE", LastValue: 22.0, ValueUpdatedAt: &setpointUpdatedAt},
+				{Name: "mode", Id: "mode", Type: "CHARSTRING", UpdateStrategy: "ON_CHANGE", LastValue: "eco", ValueUpdatedAt: &modeUpdatedAt},
+			},
+		},
+	}
+
+	iotcl.On("GetTimeSeriesStringSampling", ctx, []string{"mode"}, mock.Anything, mock.Anything, int32(300)).Return(&iotclient.ArduinoSeriesBatchSampled{}, false, nil)
+
+	var out bytes.Buffer
+	err := New(iotcl, logger, nil, false, false).ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), 300, []string{"AVG"}, OutputFormatCSVWide, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,thing_id,thing_name,mode,setpoint,temperature",
+		"2024-10-01T10:00:00Z," + thingId + ",thing0,eco,,",
+		"2024-10-01T10:50:00Z," + thingId + ",thing0,,22,20.5",
+		"",
+	}, "\n"), out.String())
+}
+
+func TestExtractionFlow_csvWideOutput(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingIds := []string{"91f30213-2bd7-480a-b1dc-f31b01840e7e", "e2b1a3f2-1b2c-4d5e-8f90-a1b2c3d4e5f6"}
+	temperatureId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+	humidityId := "d86f4ed9-7f52-4bd3-bdc6-b2936bec68ad"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	bucket1 := now.Add(-10 * time.Minute)
+	bucket2 := now.Add(-5 * time.Minute)
+
+	// First thing has temperature and humidity, second one only temperature
+	iotcl.On("GetTimeSeriesByThing", ctx, thingIds[0], mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: fmt.Sprintf("property.%s", temperatureId), Times: []time.Time{bucket1, bucket2}, Values: []float64{20.5, 21}, CountValues: 2},
+			{Query: fmt.Sprintf("property.%s", humidityId), Times: []time.Time{bucket2}, Values: []float64{40}, CountValues: 1},
+		},
+	}, false, nil)
+	iotcl.On("GetTimeSeriesByThing", ctx, thingIds[1], mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: fmt.Sprintf("property.%s", temperatureId), Times: []time.Time{bucket1}, Values: []float64{18}, CountValues: 1},
+		},
+	}, false, nil)
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingIds[0]: {
+			Id:   thingIds[0],
+			Name: "thing0",
+			Properties: []iotclient.ArduinoProperty{
+				{Name: "temperature", Id: temperatureId, Type: "FLOAT"},
+				{Name: "humidity", Id: humidityId, Type: "FLOAT"},
+			},
+		},
+		thingIds[1]: {
+			Id:   thingIds[1],
+			Name: "thing1",
+			Properties: []iotclient.ArduinoProperty{
+				{Name: "temperature", Id: temperatureId, Type: "FLOAT"},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	err := tsextractorClient.ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), 300, []string{"AVG"}, OutputFormatCSVWide, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,thing_id,thing_name,humidity,temperature",
+		"2024-10-01T10:50:00Z," + thingIds[0] + ",thing0,,20.5",
+		"2024-10-01T10:50:00Z," + thingIds[1] + ",thing1,,18",
+		"2024-10-01T10:55:00Z," + thingIds[0] + ",thing0,40,21",
+		"",
+	}, "\n"), out.String())
+
+	// Raw data is not supported by wide layout
+	err = tsextractorClient.ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), -1, nil, OutputFormatCSVWide, &out)
+	assert.Error(t, err)
+}
+
+func TestExtractionFlow_streamedThings(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingIds := []string{"91f30213-2bd7-480a-b1dc-f31b01840e7e", "a1f30213-2bd7-480a-b1dc-f31b01840e7f"}
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	bucket := now.Add(-5 * time.Minute)
+
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetTimeSeriesByThing", ctx, thingIds[0], mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: "property.temperature", Times: []time.Time{bucket}, Values: []float64{21}, CountValues: 1},
+		},
+	}, false, nil)
+	iotcl.On("GetTimeSeriesByThing", ctx, thingIds[1], mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: "property.pressure", Times: []time.Time{bucket}, Values: []float64{1013}, CountValues: 1},
+		},
+	}, false, nil)
+
+	// Things are sent one at a time, as done while discovering them
+	things := make(chan iotclient.ArduinoThing)
+	go func() {
+		defer close(things)
+		things <- iotclient.ArduinoThing{Id: thingIds[0], Name: "thing0", Properties: []iotclient.ArduinoProperty{{Name: "temperature", Id: "temperature", Type: "FLOAT"}}}
+		things <- iotclient.ArduinoThing{Id: thingIds[1], Name: "thing1", Properties: []iotclient.ArduinoProperty{{Name: "pressure", Id: "pressure", Type: "FLOAT"}}}
+	}()
+
+	// Wide layout columns include properties of all received things
+	var out bytes.Buffer
+	err := New(iotcl, logger, nil, false, false).ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, things, 300, []string{"AVG"}, OutputFormatCSVWide, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,thing_id,thing_name,pressure,temperature",
+		"2024-10-01T10:55:00Z," + thingIds[0] + ",thing0,,21",
+		"2024-10-01T10:55:00Z," + thingIds[1] + ",thing1,1013,",
+		"",
+	}, "\n"), out.String())
+}
+
+func TestExtractionFlow_expandComplexValues(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	bucket := now.Add(-5 * time.Minute)
+
+	iotcl := iotMocks.NewAPI(t)
+	iotcl.On("GetTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything, int64(300), []string{"AVG"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Query: "property.temperature", Times: []time.Time{bucket}, Values: []float64{21}, CountValues: 1},
+		},
+	}, false, nil)
+	iotcl.On("GetTimeSeriesStringSampling", ctx, []string{"position"}, mock.Anything, mock.Anything, int32(300)).Return(&iotclient.ArduinoSeriesBatchSampled{
+		Responses: []iotclient.ArduinoSeriesSampledResponse{
+			{Query: "property.position", Times: []time.Time{bucket}, Values: []any{`{"lat":45.07,"lon":7.68}`}, CountValues: 1},
+		},
+	}, false, nil)
+
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: {
+			Id:   thingId,
+			Name: "thing0",
+			Properties: []iotclient.ArduinoProperty{
+				{Name: "temperature", Id: "temperature", Type: "FLOAT"},
+				{Name: "position", Id: "position", Type: "LOCATION"},
+			},
+		},
+	}
+
+	// Location sub-fields have dedicated columns
+	var out bytes.Buffer
+	err := New(iotcl, logger, nil, false, true).ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), 300, []string{"AVG"}, OutputFormatCSVWide, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,thing_id,thing_name,position.latitude,position.longitude,temperature",
+		"2024-10-01T10:55:00Z," + thingId + ",thing0,45.07,7.68,21",
+		"",
+	}, "\n"), out.String())
+
+	// Sub-fields are typed values in other formats
+	sink := &memorySink{}
+	err = exportToSink(ctx, New(iotcl, logger, nil, false, true), now.Add(-time.Hour), now, thingsMap, 300, []string{"AVG"}, sink)
+	assert.NoError(t, err)
+	types := map[string]string{}
+	for _, sample := range sink.samples {
+		types[sample.PropertyName] = sample.PropertyType
+		if sample.PropertyName == "position.latitude" {
+			assert.Equal(t, 45.07, sample.Value)
+		}
+	}
+	assert.Equal(t, map[string]string{"temperature": "FLOAT", "position.latitude": "DEGREES_LATITUDE", "position.longitude": "DEGREES_LONGITUDE"}, types)
+
+	// Long csv layout keeps a row per complex value, with sub-fields in dedicated columns
+	out.Reset()
+	err = New(iotcl, logger, nil, false, true).ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), 300, []string{"AVG"}, OutputFormatCSV, &out)
+	assert.NoError(t, err)
+	lines := strings.Split(out.String(), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "timestamp,thing_id,thing_name,property_id,property_name,property_type,value,aggregation_statistic,latitude,longitude,"))
+	assert.Contains(t, out.String(), `,position,position,LOCATION,"{""lat"":45.07,""lon"":7.68}",SAMPLED,45.07,7.68,`)
+	assert.Len(t, lines, 4)
+}
+
+func TestExtractionFlow_multipleAggregationStatistics(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ctx := context.Background()
+
+	thingId := "91f30213-2bd7-480a-b1dc-f31b01840e7e"
+	propertyId := "c86f4ed9-7f52-4bd3-bdc6-b2936bec68ac"
+
+	// Init client
+	iotcl := iotMocks.NewAPI(t)
+
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	bucket := now.Add(-5 * time.Minute)
+
+	// Statistics are requested with a single batch query
+	iotcl.On("GetTimeSeriesByThing", ctx, thingId, mock.Anything, mock.Anything, int64(300), []string{"AVG", "MAX"}).Return(&iotclient.ArduinoSeriesBatch{
+		Responses: []iotclient.ArduinoSeriesResponse{
+			{Aggregation: toPtr("AVG"), Query: fmt.Sprintf("property.%s", propertyId), Times: []time.Time{bucket}, Values: []float64{20.5}, CountValues: 1},
+			{Aggregation: toPtr("MAX"), Query: fmt.Sprintf("property.%s", propertyId), Times: []time.Time{bucket}, Values: []float64{23}, CountValues: 1},
+			{Query: fmt.Sprintf("property.%s", propertyId), Times: []time.Time{bucket}, Values: []float64{1}, CountValues: 1},
+		},
+	}, false, nil)
+
+	tsextractorClient := New(iotcl, logger, nil, false, false)
+
+	thingsMap := map[string]iotclient.ArduinoThing{
+		thingId: {
+			Id:   thingId,
+			Name: "test",
+			Properties: []iotclient.ArduinoProperty{
+				{Name: "temperature", Id: propertyId, Type: "FLOAT"},
+			},
+		},
+	}
+
+	sink := &memorySink{}
+	err := exportToSink(ctx, tsextractorClient, now.Add(-time.Hour), now, thingsMap, 300, []string{"AVG", "MAX"}, sink)
+	assert.NoError(t, err)
+
+	// Each statistic has its own rows, response without statistic is discarded
+	values := map[string]any{}
+	for _, sample := range sink.samples {
+		values[sample.Aggregation] = sample.Value
+	}
+	assert.Len(t, sink.samples, 2)
+	assert.Equal(t, map[string]any{"AVG": 20.5, "MAX": 23.0}, values)
+
+	// Wide layout requires a single statistic
+	var out bytes.Buffer
+	err = tsextractorClient.ExportTSWindowToStream(ctx, now.Add(-time.Hour), now, ThingsChannel(thingsMap), 300, []string{"AVG", "MAX"}, OutputFormatCSVWide, &out)
+	assert.Error(t, err)
+}
+
+func TestParseAggregationStatistics(t *testing.T) {
+	stats, err := ParseAggregationStatistics("avg, MAX,,PCT_90,AVG")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AVG", "MAX", "PCT_90"}, stats)
+
+	_, err = ParseAggregationStatistics(" , ")
+	assert.Error(t, err)
+}
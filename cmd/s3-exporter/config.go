@@ -0,0 +1,172 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/app/config"
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+)
+
+// cliConfig is the configuration of the command line exporter: exporter parameters, read with config.Load,
+// and options available only from command line
+type cliConfig struct {
+	*config.Config
+	BackfillFrom *time.Time
+	BackfillTo   *time.Time
+	Dev          bool
+}
+
+func (c *cliConfig) isBackfill() bool {
+	return c.BackfillFrom != nil
+}
+
+// option is a parameter settable by command line flag
+type option struct {
+	param  string
+	usage  string
+	isBool bool
+}
+
+// flagName returns the flag of a parameter: parameter name with '/' and '_' replaced by '-' (for example /iot/api-key is -iot-api-key)
+func flagName(param string) string {
+	return strings.NewReplacer("/", "-", "_", "-").Replace(strings.TrimPrefix(param, "/"))
+}
+
+// Parameters settable by flags. Tenants and fan-out execution are not supported by command line exporter.
+var options = []option{
+	{param: config.ApiKeyParam, usage: "Arduino IoT API key"},
+	{param: config.ApiSecretParam, usage: "Arduino IoT API secret"},
+	{param: config.OrgIdParam, usage: "Arduino organization id (optional)"},
+	{param: config.TagsParam, usage: "filter things by tags. Syntax: tag=value,tag2=value2, with groups in OR separated by '|'"},
+	{param: config.ThingIdsParam, usage: "comma separated list of thing IDs to export"},
+	{param: config.DeviceIdsParam, usage: "comma separated list of device IDs whose things are exported"},
+	{param: config.ThingNamesParam, usage: "comma separated list of thing name patterns (wildcard or /regex/)"},
+	{param: config.PropertyFilterParam, usage: "JSON filter of exported properties, by name pattern and type"},
+	{param: config.ResolutionParam, usage: "samples resolution: raw, 1 minute, 5 minutes, 15 minutes or 1 hour"},
+	{param: config.SchedulingParam, usage: "data extraction time window: 5 minutes, 15 minutes, 1 hour or 1 day"},
+	{param: config.AlignWithTimeWindowParam, usage: "align data extraction with time window", isBool: true},
+	{param: config.PropertyRulesParam, usage: "JSON list of per property resolution and aggregation rules"},
+	{param: config.AggregationStatParam, usage: "comma separated aggregation statistics (AVG, MIN, MAX, PCT_90)"},
+	{param: config.DestinationBucketParam, usage: "destination S3 bucket"},
+	{param: config.DestinationDirParam, usage: "destination local directory, alternative to bucket"},
+	{param: config.EnableCompressionParam, usage: "compress csv and jsonl files with gzip", isBool: true},
+	{param: config.OutputFormatParam, usage: "output format: csv, csv_wide, parquet or jsonl"},
+	{param: config.KeyTemplateParam, usage: "destination key template or preset (default, hive)"},
+	{param: config.OutputSplitParam, usage: "split output files: none, thing or tag:<key>"},
+	{param: config.EnableWatermarkParam, usage: "persist last exported window and recover missed windows", isBool: true},
+	{param: config.ErrorPolicyParam, usage: "behaviour when export of some things fails: fail, partial or skip"},
+	{param: config.MaxFailedThingsParam, usage: "max failed things tolerated by skip error policy, as number or percentage (for example 10%)"},
+	{param: config.StreamingUploadParam, usage: "stream data to destination without local temporary files", isBool: true},
+	{param: config.ExpandComplexValuesParam, usage: "export sub-fields of complex values (for example location latitude and longitude) as separate values", isBool: true},
+}
+
+// flagProvider provides parameters set by command line flags
+type flagProvider map[string]string
+
+func (p flagProvider) Lookup(_ context.Context, param string) (string, bool, error) {
+	value, found := p[param]
+	return value, found, nil
+}
+
+func (p flagProvider) Describe(param string) string {
+	return "flag -" + flagName(param)
+}
+
+// loadConfig resolves configuration with the following precedence: flags, environment variables, config file, defaults.
+// Environment variables and config file keys are the ones of Lambda configuration sources (see config.EnvName and config.NewFileProvider).
+func loadConfig(ctx context.Context, args []string) (*cliConfig, error) {
+	fs := flag.NewFlagSet("s3-exporter", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "YAML configuration file (env "+config.EnvPrefix+"CONFIG)")
+	stack := fs.String("stack", os.Getenv("STACK_NAME"), "name used to isolate watermark state (env STACK_NAME)")
+	from := fs.String("from", "", "backfill range start (RFC3339)")
+	to := fs.String("to", "", "backfill range end (RFC3339)")
+	dev := fs.Bool("dev", false, "use development API endpoint")
+
+	flagValues := flagProvider{}
+	for _, opt := range options {
+		param := opt.param
+		usage := fmt.Sprintf("%s (env %s)", opt.usage, config.EnvName(param))
+		if opt.isBool {
+			fs.BoolFunc(flagName(param), usage, func(v string) error { flagValues[param] = v; return nil })
+		} else {
+			fs.Func(flagName(param), usage, func(v string) error { flagValues[param] = v; return nil })
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	providers := []config.Provider{flagValues, config.NewEnvProvider(os.LookupEnv)}
+	if *configFile != "" {
+		fileProvider, err := config.NewFileProvider(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, fileProvider)
+	}
+	exporterConfig, err := config.Load(ctx, config.NewChain(providers...), *stack)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &cliConfig{Config: exporterConfig, Dev: *dev}
+	if cfg.BackfillFrom, err = parseTime(*from); err != nil {
+		return nil, fmt.Errorf("invalid backfill start: %w", err)
+	}
+	if cfg.BackfillTo, err = parseTime(*to); err != nil {
+		return nil, fmt.Errorf("invalid backfill end: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *cliConfig) validate() error {
+	if len(c.Tenants) > 0 || c.FanOutQueueURL != "" {
+		return errors.New("tenants and fan-out execution are not supported by command line exporter")
+	}
+	if (c.BackfillFrom == nil) != (c.BackfillTo == nil) {
+		return errors.New("both from and to are required for backfill")
+	}
+	if c.BackfillFrom != nil && !c.BackfillFrom.Before(*c.BackfillTo) {
+		return errors.New("backfill from must be before to")
+	}
+	if c.TimeWindowMinutes > 60 && c.ResolutionSeconds <= 60 {
+		return errors.New("resolution must be greater than 1 minute for time windows greater than 1 hour")
+	}
+	return tsextractor.ValidateOutputFormat(c.OutputFormat, c.ResolutionSeconds, c.TimeWindowMinutes, c.AggregationStats, c.PropertyRules)
+}
+
+// parseTime parses a RFC3339 time, returning nil when value is empty
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
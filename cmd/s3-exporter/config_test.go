@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_precedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`
+iot/api-key: file-key
+iot/api-secret: file-secret
+iot/samples-resolution: 15 minutes
+iot/scheduling: 1 hour
+output_format: parquet
+destination-directory: /tmp/out
+enable_compression: "true"
+`), 0644)
+	assert.NoError(t, err)
+
+	t.Setenv("ARDUINO_EXPORTER_IOT_API_KEY", "env-key")
+	t.Setenv("ARDUINO_EXPORTER_IOT_SAMPLES_RESOLUTION", "1 hour")
+
+	cfg, err := loadConfig(context.Background(), []string{"-config", configFile, "-iot-samples-resolution", "raw", "-iot-scheduling", "15 minutes"})
+	assert.NoError(t, err)
+	assert.Equal(t, "env-key", cfg.ApiKey)
+	assert.Equal(t, "file-secret", cfg.ApiSecret)
+	assert.Equal(t, -1, cfg.ResolutionSeconds)
+	assert.Equal(t, 15, cfg.TimeWindowMinutes)
+	assert.Equal(t, "parquet", cfg.OutputFormat)
+	assert.Equal(t, "/tmp/out", cfg.DestinationDirectory)
+	assert.True(t, cfg.Compress)
+	assert.Equal(t, []string{"AVG"}, cfg.AggregationStats)
+}
+
+func TestConfig_configFileFromEnv(t *testing.T) {
+	// Same file is read by Lambda and command line exporter
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`
+iot/api-key: key
+iot/api-secret: secret
+destination-bucket: bucket
+`), 0644)
+	assert.NoError(t, err)
+	t.Setenv("ARDUINO_EXPORTER_CONFIG", configFile)
+
+	cfg, err := loadConfig(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", cfg.ApiKey)
+	assert.Equal(t, "bucket", cfg.DestinationBucket)
+
+	err = os.WriteFile(configFile, []byte("api-key: key\n"), 0644)
+	assert.NoError(t, err)
+	_, err = loadConfig(context.Background(), nil)
+	assert.ErrorContains(t, err, "unknown parameters in config file "+configFile+": api-key")
+}
+
+func TestConfig_validation(t *testing.T) {
+	ctx := context.Background()
+	_, err := loadConfig(ctx, []string{"-iot-api-key", "k", "-iot-api-secret", "s"})
+	assert.ErrorContains(t, err, "parameter flag -destination-bucket or ARDUINO_EXPORTER_DESTINATION_BUCKET: required parameter is missing")
+
+	_, err = loadConfig(ctx, []string{"-iot-api-key", "k", "-iot-api-secret", "s", "-destination-bucket", "b", "-destination-directory", "/tmp/out"})
+	assert.ErrorContains(t, err, "parameter flag -destination-directory: destination directory is alternative to destination bucket")
+
+	_, err = loadConfig(ctx, []string{"-iot-api-key", "k", "-iot-api-secret", "s", "-destination-directory", "/tmp/out", "-from", "2024-09-01T00:00:00Z"})
+	assert.EqualError(t, err, "both from and to are required for backfill")
+
+	_, err = loadConfig(ctx, []string{"-iot-api-key", "k", "-iot-api-secret", "s", "-destination-directory", "/tmp/out", "-output-format", "xml"})
+	assert.ErrorContains(t, err, "parameter flag -output-format: unsupported output format: xml")
+
+	_, err = loadConfig(ctx, []string{"-iot-api-key", "k", "-iot-api-secret", "s", "-destination-directory", "/tmp/out", "-iot-scheduling", "1 day", "-iot-samples-resolution", "raw"})
+	assert.EqualError(t, err, "resolution must be greater than 1 minute for time windows greater than 1 hour")
+
+	cfg, err := loadConfig(ctx, []string{"-iot-api-key", "k", "-iot-api-secret", "s", "-destination-directory", "/tmp/out", "-enable-watermark",
+		"-from", "2024-09-01T00:00:00Z", "-to", "2024-09-02T00:00:00Z", "-iot-filter-thing-ids", "a, b"})
+	assert.NoError(t, err)
+	assert.True(t, cfg.isBackfill())
+	assert.True(t, cfg.Watermark)
+	assert.Equal(t, []string{"a", "b"}, cfg.Selector.ThingIDs)
+}
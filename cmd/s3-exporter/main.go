@@ -0,0 +1,115 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+// Command s3-exporter runs the Arduino Cloud samples export outside of AWS Lambda,
+// writing to an S3 bucket or to a local directory.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/arduino/aws-s3-integration/app/exporter"
+	"github.com/arduino/aws-s3-integration/internal/localfs"
+	"github.com/arduino/aws-s3-integration/internal/s3"
+	"github.com/arduino/aws-s3-integration/version"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	logger := logrus.NewEntry(logrus.New())
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := loadConfig(ctx, os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		logger.Fatal(err)
+	}
+
+	report, err := run(ctx, cfg, logger)
+	if err != nil {
+		logger.Error("Error detected during data export: ", err)
+		os.Exit(1)
+	}
+	if failed := len(report.FailedThings); failed > 0 {
+		logger.Warnf("Data exported partially: %d failed things\n", failed)
+		return
+	}
+	logger.Infoln("Data exported successfully")
+}
+
+func run(ctx context.Context, cfg *cliConfig, logger *logrus.Entry) (*exporter.Report, error) {
+	if cfg.Dev {
+		logger.Infoln("Running in dev mode")
+		os.Setenv("IOT_API_URL", "https://api2.oniudra.cc")
+	}
+
+	var destination s3.API
+	var err error
+	if cfg.DestinationDirectory != "" {
+		logger.Infoln("destination directory:", cfg.DestinationDirectory)
+		destination, err = localfs.NewDirectory(cfg.DestinationDirectory)
+	} else {
+		logger.Infoln("destination bucket:", cfg.DestinationBucket)
+		destination, err = s3.NewS3Client(cfg.DestinationBucket)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infoln("exporter version:", version.Version())
+	logger.Infoln("key:", cfg.ApiKey)
+	logger.Infoln("secret:", "*********")
+	if cfg.ResolutionSeconds <= 0 {
+		logger.Infoln("resolution: raw")
+	} else {
+		logger.Infoln("resolution:", cfg.ResolutionSeconds, "seconds")
+	}
+	logger.Infoln("aggregation statistics:", strings.Join(cfg.AggregationStats, ", "))
+	logger.Infoln("data extraction time window:", cfg.TimeWindowMinutes, "minutes")
+	logger.Infoln("output format:", cfg.OutputFormat)
+	logger.Infoln("things selection:", cfg.Selector.String())
+	logger.Infoln("error policy:", cfg.ErrorPolicy.String())
+	logger.Infoln("property rules:", len(cfg.PropertyRules))
+	logger.Infoln("property filter:", cfg.PropertyFilter.String())
+	logger.Infoln("expand complex values:", cfg.ExpandComplexValues)
+
+	tsExporter, err := exporter.New(cfg.ApiKey, cfg.ApiSecret, cfg.OrgID, cfg.Options, logger)
+	if err != nil {
+		return nil, err
+	}
+	var report *exporter.Report
+	if cfg.isBackfill() {
+		report, err = tsExporter.StartBackfill(ctx, *cfg.BackfillFrom, *cfg.BackfillTo, nil, cfg.ResolutionSeconds, cfg.TimeWindowMinutes, destination, cfg.AggregationStats)
+	} else {
+		report, err = tsExporter.StartExporter(ctx, cfg.ResolutionSeconds, cfg.TimeWindowMinutes, destination, cfg.AggregationStats)
+	}
+	if report != nil {
+		logger.Infof("Export %s: %d time windows, %d things, %d failed things\n", report.Status, report.ExportedWindows, report.Things, len(report.FailedThings))
+		for _, failed := range report.FailedThings {
+			logger.Warnf("  Failed thing %s (%s) in window %s - %s: %s\n", failed.ThingID, failed.ThingName, failed.From, failed.To, failed.Error)
+		}
+	}
+	return report, err
+}
@@ -1,7 +1,10 @@
 #!/bin/bash
 
 mkdir -p deployment/binaries
-GOOS=linux CGO_ENABLED=0 go build -o bootstrap -tags lambda.norpc lambda.go
+VERSION_PACKAGE=github.com/arduino/aws-s3-integration/version
+VERSION=$(git describe --tags --always 2> /dev/null)
+COMMIT=$(git log --no-show-signature -n 1 --format=%h 2> /dev/null)
+GOOS=linux CGO_ENABLED=0 go build -o bootstrap -tags lambda.norpc -ldflags "-X ${VERSION_PACKAGE}.versionString=${VERSION} -X ${VERSION_PACKAGE}.commit=${COMMIT}" lambda.go
 zip arduino-s3-integration-lambda.zip bootstrap
 mv arduino-s3-integration-lambda.zip deployment/binaries/
 rm bootstrap
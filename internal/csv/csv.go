@@ -4,10 +4,13 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/arduino/aws-s3-integration/internal/samples"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,51 +21,112 @@ const (
 var csvHeader = []string{"timestamp", "thing_id", "thing_name", "property_id", "property_name", "property_type", "value", "aggregation_statistic"}
 var csvHeaderRaw = []string{"timestamp", "thing_id", "thing_name", "property_id", "property_name", "property_type", "value"}
 
-func NewWriter(destinationHour time.Time, logger *logrus.Entry, isRawData bool) (*CsvWriter, error) {
-	filePath := fmt.Sprintf("%s/%s.csv", baseTmpStorage, destinationHour.Format("2006-01-02-15-04"))
-	file, err := os.Create(filePath)
+// NewWriter returns a writer producing csv files in long layout, one row per sample. When subFieldColumns is set,
+// rows have a column per known sub-field of complex values (see samples.SubFieldColumns), next to the json encoded value.
+func NewWriter(destinationHour time.Time, logger *logrus.Entry, isRawData, subFieldColumns bool) (*CsvWriter, error) {
+	// Use a unique file name, as multiple files can be generated for the same time window
+	file, err := os.CreateTemp(baseTmpStorage, fmt.Sprintf("%s-*.csv", destinationHour.Format("2006-01-02-15-04")))
 	if err != nil {
-		logger.Fatalf("failed creating file: %s", err)
+		return nil, fmt.Errorf("failed creating file: %w", err)
 	}
+	filePath := file.Name()
 	writer := csv.NewWriter(file)
 
-	header := csvHeader
-	if isRawData {
-		header = csvHeaderRaw
-	}
-	if err := writer.Write(header); err != nil {
-		logger.Fatalf("failed writing record to file: %s", err)
-	}
-	return &CsvWriter{
+	c := &CsvWriter{
 		outFile:   file,
 		logger:    logger,
 		csvWriter: writer,
 		filePath:  filePath,
 		isRawData: isRawData,
-	}, nil
+	}
+	if subFieldColumns {
+		c.subFieldColumns = samples.SubFieldColumns()
+	}
+	if err := writer.Write(c.header()); err != nil {
+		c.Delete()
+		return nil, fmt.Errorf("failed writing header: %w", err)
+	}
+	return c, nil
+}
+
+// NewStreamWriter returns a writer producing csv content on the given stream, without any local file.
+// Stream is not closed by the writer.
+func NewStreamWriter(out io.Writer, logger *logrus.Entry, isRawData, subFieldColumns bool) (*CsvWriter, error) {
+	writer := csv.NewWriter(out)
+
+	c := &CsvWriter{
+		logger:    logger,
+		csvWriter: writer,
+		isRawData: isRawData,
+	}
+	if subFieldColumns {
+		c.subFieldColumns = samples.SubFieldColumns()
+	}
+	if err := writer.Write(c.header()); err != nil {
+		return nil, fmt.Errorf("failed writing header: %w", err)
+	}
+	return c, nil
 }
 
 type CsvWriter struct {
-	fileWriteLock sync.Mutex
-	outFile       *os.File
-	logger        *logrus.Entry
-	csvWriter     *csv.Writer
-	filePath      string
-	isRawData     bool
+	fileWriteLock   sync.Mutex
+	outFile         *os.File
+	logger          *logrus.Entry
+	csvWriter       *csv.Writer
+	filePath        string
+	isRawData       bool
+	subFieldColumns []string
 }
 
-func (c *CsvWriter) Write(records [][]string) error {
+func (c *CsvWriter) header() []string {
+	header := csvHeader
+	if c.isRawData {
+		header = csvHeaderRaw
+	}
+	return append(slices.Clone(header), c.subFieldColumns...)
+}
+
+func (c *CsvWriter) Write(toWrite []samples.Sample) error {
 	c.fileWriteLock.Lock()
 	defer c.fileWriteLock.Unlock()
 
 	// Write records to csv file
-	for _, record := range records {
-		if err := c.csvWriter.Write(record); err != nil {
+	for _, sample := range toWrite {
+		if err := c.csvWriter.Write(c.composeRow(sample)); err != nil {
 			return err
 		}
 	}
 	c.csvWriter.Flush()
-	return nil
+	return c.csvWriter.Error()
+}
+
+func (c *CsvWriter) composeRow(sample samples.Sample) []string {
+	size := len(csvHeader)
+	if c.isRawData {
+		size = len(csvHeaderRaw)
+	}
+	row := make([]string, size, size+len(c.subFieldColumns))
+	row[0] = sample.Time.UTC().Format(time.RFC3339)
+	row[1] = sample.ThingID
+	row[2] = sample.ThingName
+	row[3] = sample.PropertyID
+	row[4] = sample.PropertyName
+	row[5] = sample.PropertyType
+	row[6] = samples.ValueToString(sample.Value)
+	if !c.isRawData {
+		row[7] = sample.Aggregation
+	}
+	if len(c.subFieldColumns) > 0 {
+		values := samples.SubFieldValues(sample)
+		for _, column := range c.subFieldColumns {
+			cell := ""
+			if value, ok := values[column]; ok {
+				cell = samples.ValueToString(value)
+			}
+			row = append(row, cell)
+		}
+	}
+	return row
 }
 
 func (c *CsvWriter) GetFilePath() string {
@@ -70,8 +134,15 @@ func (c *CsvWriter) GetFilePath() string {
 }
 
 func (c *CsvWriter) Close() error {
-	c.logger.Infoln("Closing ouput csv file ", c.outFile.Name())
+	if c.csvWriter != nil && c.outFile == nil {
+		// Stream writer
+		c.csvWriter.Flush()
+		err := c.csvWriter.Error()
+		c.csvWriter = nil
+		return err
+	}
 	if c.csvWriter != nil && c.outFile != nil {
+		c.logger.Infoln("Closing ouput csv file ", c.outFile.Name())
 		c.csvWriter.Flush()
 		err := c.outFile.Close()
 		c.csvWriter = nil
@@ -0,0 +1,97 @@
+package csv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSamples = []samples.Sample{
+	{
+		Time:         time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC),
+		ThingID:      "th1",
+		ThingName:    "thing one",
+		PropertyID:   "p1",
+		PropertyName: "temperature",
+		PropertyType: "FLOAT",
+		Value:        21.5,
+		Aggregation:  "AVG",
+	},
+	{
+		Time:         time.Date(2024, 9, 4, 11, 5, 0, 0, time.UTC),
+		ThingID:      "th1",
+		ThingName:    "thing one",
+		PropertyID:   "p2",
+		PropertyName: "status",
+		PropertyType: "CHARSTRING",
+		Value:        "on, off",
+		Aggregation:  "AVG",
+	},
+}
+
+func TestStreamWriter_aggregated(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewStreamWriter(&out, logrus.NewEntry(logrus.New()), false, false)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(testSamples))
+	assert.NoError(t, writer.Close())
+	assert.Equal(t, "", writer.GetFilePath())
+
+	assert.Equal(t, "timestamp,thing_id,thing_name,property_id,property_name,property_type,value,aggregation_statistic\n"+
+		"2024-09-04T11:00:00Z,th1,thing one,p1,temperature,FLOAT,21.5,AVG\n"+
+		"2024-09-04T11:05:00Z,th1,thing one,p2,status,CHARSTRING,\"on, off\",AVG\n", out.String())
+}
+
+func TestStreamWriter_raw(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewStreamWriter(&out, logrus.NewEntry(logrus.New()), true, false)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(testSamples[:1]))
+	assert.NoError(t, writer.Close())
+
+	assert.Equal(t, "timestamp,thing_id,thing_name,property_id,property_name,property_type,value\n"+
+		"2024-09-04T11:00:00Z,th1,thing one,p1,temperature,FLOAT,21.5\n", out.String())
+}
+
+func TestWriter_file(t *testing.T) {
+	writer, err := NewWriter(time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC), logrus.NewEntry(logrus.New()), true, false)
+	assert.NoError(t, err)
+	defer writer.Delete()
+	assert.NoError(t, writer.Write(testSamples[:1]))
+	assert.NoError(t, writer.Close())
+	assert.Error(t, writer.Close())
+
+	content, err := os.ReadFile(writer.GetFilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, "timestamp,thing_id,thing_name,property_id,property_name,property_type,value\n"+
+		"2024-09-04T11:00:00Z,th1,thing one,p1,temperature,FLOAT,21.5\n", string(content))
+
+	assert.NoError(t, writer.Delete())
+	_, err = os.Stat(writer.GetFilePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStreamWriter_subFieldColumns(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewStreamWriter(&out, logrus.NewEntry(logrus.New()), true, true)
+	assert.NoError(t, err)
+	ts := time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p1", PropertyName: "position", PropertyType: "LOCATION", Value: `{"lat":45.07,"lon":7.68}`},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p2", PropertyName: "light", PropertyType: "HOME_COLORED_LIGHT", Value: map[string]any{"swi": true, "hue": 120.0, "sat": 50.0, "bri": 80.0}},
+		// Json content of string properties is not expanded
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p3", PropertyName: "status", PropertyType: "CHARSTRING", Value: `{"lat":1,"lon":2}`},
+	}))
+	assert.NoError(t, writer.Close())
+
+	assert.Equal(t, "timestamp,thing_id,thing_name,property_id,property_name,property_type,value,"+
+		"latitude,longitude,hue,sat,bri,swi,vol,mut,pbc,inp,cha,frm,to,len,msk\n"+
+		`2024-09-04T11:00:00Z,th1,one,p1,position,LOCATION,"{""lat"":45.07,""lon"":7.68}",45.07,7.68,,,,,,,,,,,,,`+"\n"+
+		`2024-09-04T11:00:00Z,th1,one,p2,light,HOME_COLORED_LIGHT,"{""bri"":80,""hue"":120,""sat"":50,""swi"":true}",,,120,50,80,true,,,,,,,,,`+"\n"+
+		`2024-09-04T11:00:00Z,th1,one,p3,status,CHARSTRING,"{""lat"":1,""lon"":2}",,,,,,,,,,,,,,,`+"\n", out.String())
+}
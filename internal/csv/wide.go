@@ -0,0 +1,180 @@
+package csv
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/sirupsen/logrus"
+)
+
+var csvWideHeaderPrefix = []string{"timestamp", "thing_id", "thing_name"}
+
+// NewWideWriter returns a writer producing csv files in wide layout: one row per (timestamp, thing)
+// and one column per property name. Cells without a sample are left empty.
+// Property names are requested on Close, so that they can include things discovered while samples are written.
+func NewWideWriter(destinationHour time.Time, logger *logrus.Entry, propertyNames func() []string) (*CsvWideWriter, error) {
+	// Use a unique file name, as multiple files can be generated for the same time window
+	file, err := os.CreateTemp(baseTmpStorage, fmt.Sprintf("%s-*.csv", destinationHour.Format("2006-01-02-15-04")))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating file: %w", err)
+	}
+	writer := newWideWriter(file, logger, propertyNames)
+	writer.outFile = file
+	writer.filePath = file.Name()
+	return writer, nil
+}
+
+// NewWideStreamWriter returns a writer producing csv content in wide layout on the given stream.
+// Stream is not closed by the writer. As rows are buffered until Close, memory usage is not bounded
+// by the stream: callers must not use it for bounded memory uploads.
+func NewWideStreamWriter(out io.Writer, logger *logrus.Entry, propertyNames func() []string) (*CsvWideWriter, error) {
+	return newWideWriter(out, logger, propertyNames), nil
+}
+
+func newWideWriter(out io.Writer, logger *logrus.Entry, propertyNames func() []string) *CsvWideWriter {
+	return &CsvWideWriter{
+		logger:        logger,
+		out:           out,
+		propertyNames: propertyNames,
+		rows:          make(map[wideRowKey]map[string]string),
+		thingNames:    make(map[string]string),
+	}
+}
+
+type wideRowKey struct {
+	timestamp int64
+	thingID   string
+}
+
+// CsvWideWriter buffers samples in memory and writes rows on Close, as a row is complete
+// only once all the properties of the thing have been extracted.
+// Memory usage is bounded by aggregated resolutions, the only ones supported by this layout.
+type CsvWideWriter struct {
+	lock          sync.Mutex
+	outFile       *os.File
+	out           io.Writer
+	logger        *logrus.Entry
+	filePath      string
+	propertyNames func() []string
+	rows          map[wideRowKey]map[string]string
+	thingNames    map[string]string
+}
+
+func (c *CsvWideWriter) Write(toWrite []samples.Sample) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.rows == nil {
+		return errors.New("writer already closed")
+	}
+	for _, sample := range toWrite {
+		key := wideRowKey{timestamp: sample.Time.UTC().Unix(), thingID: sample.ThingID}
+		row, ok := c.rows[key]
+		if !ok {
+			row = make(map[string]string)
+			c.rows[key] = row
+		}
+		row[sample.PropertyName] = samples.ValueToString(sample.Value)
+		c.thingNames[sample.ThingID] = sample.ThingName
+	}
+	return nil
+}
+
+func (c *CsvWideWriter) flush() error {
+	keys := make([]wideRowKey, 0, len(c.rows))
+	for key := range c.rows {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b wideRowKey) int {
+		if n := cmp.Compare(a.timestamp, b.timestamp); n != 0 {
+			return n
+		}
+		return strings.Compare(a.thingID, b.thingID)
+	})
+
+	columns := c.columns()
+	writer := csv.NewWriter(c.out)
+	header := append(slices.Clone(csvWideHeaderPrefix), columns...)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		record := make([]string, 0, len(header))
+		record = append(record, time.Unix(key.timestamp, 0).UTC().Format(time.RFC3339), key.thingID, c.thingNames[key.thingID])
+		row := c.rows[key]
+		for _, column := range columns {
+			record = append(record, row[column])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// columns returns given property names, followed by names of written properties not included in them
+func (c *CsvWideWriter) columns() []string {
+	var names []string
+	if c.propertyNames != nil {
+		names = slices.Clone(c.propertyNames())
+	}
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	extra := []string{}
+	for _, row := range c.rows {
+		for name := range row {
+			if !known[name] {
+				known[name] = true
+				extra = append(extra, name)
+			}
+		}
+	}
+	slices.Sort(extra)
+	return append(names, extra...)
+}
+
+func (c *CsvWideWriter) GetFilePath() string {
+	return c.filePath
+}
+
+func (c *CsvWideWriter) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.rows == nil {
+		return errors.New("no file to close")
+	}
+	err := c.flush()
+	c.rows = nil
+	if c.outFile != nil {
+		c.logger.Infoln("Closing ouput csv file ", c.outFile.Name())
+		if closeErr := c.outFile.Close(); err == nil {
+			err = closeErr
+		}
+		c.outFile = nil
+	}
+	return err
+}
+
+func (c *CsvWideWriter) Delete() error {
+	if c.outFile != nil {
+		c.Close()
+	}
+	if c.filePath == "" {
+		// Stream writer
+		return nil
+	}
+	return os.Remove(c.filePath)
+}
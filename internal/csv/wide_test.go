@@ -0,0 +1,61 @@
+package csv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWideStreamWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewWideStreamWriter(&out, logrus.NewEntry(logrus.New()), func() []string { return []string{"humidity", "temperature"} })
+	assert.NoError(t, err)
+
+	t1 := time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+	t2 := t1.Add(5 * time.Minute)
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: t2, ThingID: "th1", ThingName: "one", PropertyName: "temperature", Value: 21.0},
+		{Time: t1, ThingID: "th2", ThingName: "two", PropertyName: "temperature", Value: 19.5},
+		{Time: t1, ThingID: "th1", ThingName: "one", PropertyName: "temperature", Value: 20.5},
+		{Time: t1, ThingID: "th1", ThingName: "one", PropertyName: "pressure", Value: 1013.0},
+	}))
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: t2, ThingID: "th1", ThingName: "one", PropertyName: "humidity", Value: 40.0},
+	}))
+	// Nothing is written until close
+	assert.Equal(t, 0, out.Len())
+	assert.NoError(t, writer.Close())
+
+	// Rows sorted by timestamp and thing, written properties missing from given names are appended
+	assert.Equal(t, "timestamp,thing_id,thing_name,humidity,temperature,pressure\n"+
+		"2024-09-04T11:00:00Z,th1,one,,20.5,1013\n"+
+		"2024-09-04T11:00:00Z,th2,two,,19.5,\n"+
+		"2024-09-04T11:05:00Z,th1,one,40,21,\n", out.String())
+
+	assert.Error(t, writer.Write([]samples.Sample{{Time: t1, ThingID: "th1", PropertyName: "humidity", Value: 1.0}}))
+	assert.Error(t, writer.Close())
+	assert.NoError(t, writer.Delete())
+}
+
+func TestWideWriter_file(t *testing.T) {
+	writer, err := NewWideWriter(time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC), logrus.NewEntry(logrus.New()), nil)
+	assert.NoError(t, err)
+	defer writer.Delete()
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC), ThingID: "th1", ThingName: "one", PropertyName: "temperature", Value: 20.5},
+	}))
+	assert.NoError(t, writer.Close())
+
+	content, err := os.ReadFile(writer.GetFilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, "timestamp,thing_id,thing_name,temperature\n2024-09-04T11:00:00Z,th1,one,20.5\n", string(content))
+
+	assert.NoError(t, writer.Delete())
+	_, err = os.Stat(writer.GetFilePath())
+	assert.True(t, os.IsNotExist(err))
+}
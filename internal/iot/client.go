@@ -17,6 +17,7 @@ package iot
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
 	iotclient "github.com/arduino/iot-client-go/v2"
@@ -25,24 +26,36 @@ import (
 
 var ErrOtaAlreadyInProgress = fmt.Errorf("ota already in progress")
 
+// SeriesLimit is the max number of values returned for each series by raw and sampled queries.
+// Series reaching the limit are truncated and must be paged.
+const SeriesLimit = int64(1000)
+
+// Raw series are requested in ascending order, so that truncated series can be paged starting from their last timestamp
+var rawSeriesSort = "ASC"
+
 //go:generate mockery --name API --filename iot_api.go
 type API interface {
-	ThingList(ctx context.Context, ids []string, device *string, props bool, tags map[string]string) ([]iotclient.ArduinoThing, error)
-	GetTimeSeriesByThing(ctx context.Context, thingID string, from, to time.Time, interval int64, aggregationStat string) (*iotclient.ArduinoSeriesBatch, bool, error)
+	ThingList(ctx context.Context, ids []string, device *string, props bool, tags map[string]string) ([]iotclient.ArduinoThing, bool, error)
+	GetTimeSeriesByThing(ctx context.Context, thingID string, from, to time.Time, interval int64, aggregationStats []string) (*iotclient.ArduinoSeriesBatch, bool, error)
+	GetTimeSeriesByProperties(ctx context.Context, properties []string, from, to time.Time, interval int64, aggregationStats []string) (*iotclient.ArduinoSeriesBatch, bool, error)
 	GetTimeSeriesStringSampling(ctx context.Context, properties []string, from, to time.Time, interval int32) (*iotclient.ArduinoSeriesBatchSampled, bool, error)
 	GetRawTimeSeriesByThing(ctx context.Context, thingID string, from, to time.Time) (*iotclient.ArduinoSeriesRawBatch, bool, error)
+	GetRawTimeSeriesByProperties(ctx context.Context, properties []string, from, to time.Time) (*iotclient.ArduinoSeriesRawBatch, bool, error)
 }
 
 // Client can perform actions on Arduino IoT Cloud.
 type Client struct {
-	api   *iotclient.APIClient
-	token oauth2.TokenSource
+	api     *iotclient.APIClient
+	token   oauth2.TokenSource
+	limiter *RateLimiter
 }
 
 // NewClient returns a new client implementing the Client interface.
 // It needs client Credentials for cloud authentication.
 func NewClient(key, secret, organization string) (*Client, error) {
-	cl := &Client{}
+	cl := &Client{
+		limiter: NewRateLimiter(defaultRequestsPerSecond, defaultBurst, defaultBackoffBase, defaultBackoffMax),
+	}
 	err := cl.setup(key, secret, organization)
 	if err != nil {
 		err = fmt.Errorf("instantiate new iot client: %w", err)
@@ -72,11 +85,11 @@ func (cl *Client) setup(client, secret, organizationId string) error {
 	return nil
 }
 
-// ThingList returns a list of things on Arduino IoT Cloud.
-func (cl *Client) ThingList(ctx context.Context, ids []string, device *string, showProperties bool, tags map[string]string) ([]iotclient.ArduinoThing, error) {
+// ThingList returns a list of things on Arduino IoT Cloud. Returned flag reports that request was rate limited and can be retried.
+func (cl *Client) ThingList(ctx context.Context, ids []string, device *string, showProperties bool, tags map[string]string) ([]iotclient.ArduinoThing, bool, error) {
 	ctx, err := ctxWithToken(ctx, cl.token)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	request := cl.api.ThingsV2Api.ThingsV2List(ctx)
@@ -99,15 +112,22 @@ func (cl *Client) ThingList(ctx context.Context, ids []string, device *string, s
 		request = request.Tags(t)
 	}
 
-	things, _, err := cl.api.ThingsV2Api.ThingsV2ListExecute(request)
+	if err := cl.limiter.Wait(ctx); err != nil {
+		return nil, false, err
+	}
+	things, httpResponse, err := cl.api.ThingsV2Api.ThingsV2ListExecute(request)
+	rateLimited := cl.checkRateLimit(httpResponse)
 	if err != nil {
 		err = fmt.Errorf("retrieving things, %w", errorDetail(err))
-		return nil, err
+		// Retry if rate limited. Next call waits for backoff or Retry-After delay.
+		return nil, rateLimited, err
 	}
-	return things, nil
+	return things, false, nil
 }
 
-func (cl *Client) GetTimeSeriesByThing(ctx context.Context, thingID string, from, to time.Time, interval int64, aggregationStat string) (*iotclient.ArduinoSeriesBatch, bool, error) {
+// GetTimeSeriesByThing returns aggregated time series of all thing properties. Statistics are requested in the same batch query:
+// responses report the statistic in their Aggregation field.
+func (cl *Client) GetTimeSeriesByThing(ctx context.Context, thingID string, from, to time.Time, interval int64, aggregationStats []string) (*iotclient.ArduinoSeriesBatch, bool, error) {
 	if thingID == "" {
 		return nil, false, fmt.Errorf("no thing provided")
 	}
@@ -117,18 +137,19 @@ func (cl *Client) GetTimeSeriesByThing(ctx context.Context, thingID string, from
 		return nil, false, err
 	}
 
-	requests := []iotclient.BatchQueryRequestMediaV1{
-		{
+	requests := make([]iotclient.BatchQueryRequestMediaV1, 0, len(aggregationStats))
+	for _, stat := range aggregationStats {
+		requests = append(requests, iotclient.BatchQueryRequestMediaV1{
 			From:        from,
 			Interval:    &interval,
 			Q:           fmt.Sprintf("thing.%s", thingID),
 			To:          to,
-			Aggregation: &aggregationStat,
-		},
+			Aggregation: &stat,
+		})
 	}
 
 	if len(requests) == 0 {
-		return nil, false, fmt.Errorf("no valid properties provided")
+		return nil, false, fmt.Errorf("no aggregation statistic provided")
 	}
 
 	batchQueryRequestsMediaV1 := iotclient.BatchQueryRequestsMediaV1{
@@ -137,14 +158,66 @@ func (cl *Client) GetTimeSeriesByThing(ctx context.Context, thingID string, from
 
 	request := cl.api.SeriesV2Api.SeriesV2BatchQuery(ctx)
 	request = request.BatchQueryRequestsMediaV1(batchQueryRequestsMediaV1)
+	if err := cl.limiter.Wait(ctx); err != nil {
+		return nil, false, err
+	}
 	ts, httpResponse, err := cl.api.SeriesV2Api.SeriesV2BatchQueryExecute(request)
+	rateLimited := cl.checkRateLimit(httpResponse)
 	if err != nil {
 		err = fmt.Errorf("retrieving time series: %w", errorDetail(err))
-		if httpResponse != nil && httpResponse.StatusCode == 429 { // Retry if rate limited
-			return nil, true, err
+		// Retry if rate limited. Next call waits for backoff or Retry-After delay.
+		return nil, rateLimited, err
+	}
+	return ts, false, nil
+}
+
+// GetTimeSeriesByProperties returns aggregated time series of the given properties, with one query per property and statistic
+func (cl *Client) GetTimeSeriesByProperties(ctx context.Context, properties []string, from, to time.Time, interval int64, aggregationStats []string) (*iotclient.ArduinoSeriesBatch, bool, error) {
+	if len(properties) == 0 {
+		return nil, false, fmt.Errorf("no properties provided")
+	}
+
+	ctx, err := ctxWithToken(ctx, cl.token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	requests := make([]iotclient.BatchQueryRequestMediaV1, 0, len(properties)*len(aggregationStats))
+	for _, prop := range properties {
+		if prop == "" {
+			continue
 		}
+		for _, stat := range aggregationStats {
+			requests = append(requests, iotclient.BatchQueryRequestMediaV1{
+				From:        from,
+				Interval:    &interval,
+				Q:           fmt.Sprintf("property.%s", prop),
+				To:          to,
+				Aggregation: &stat,
+			})
+		}
+	}
+
+	if len(requests) == 0 {
+		return nil, false, fmt.Errorf("no valid properties or aggregation statistics provided")
+	}
+
+	batchQueryRequestsMediaV1 := iotclient.BatchQueryRequestsMediaV1{
+		Requests: requests,
+	}
+
+	request := cl.api.SeriesV2Api.SeriesV2BatchQuery(ctx)
+	request = request.BatchQueryRequestsMediaV1(batchQueryRequestsMediaV1)
+	if err := cl.limiter.Wait(ctx); err != nil {
 		return nil, false, err
 	}
+	ts, httpResponse, err := cl.api.SeriesV2Api.SeriesV2BatchQueryExecute(request)
+	rateLimited := cl.checkRateLimit(httpResponse)
+	if err != nil {
+		err = fmt.Errorf("retrieving time series: %w", errorDetail(err))
+		// Retry if rate limited. Next call waits for backoff or Retry-After delay.
+		return nil, rateLimited, err
+	}
 	return ts, false, nil
 }
 
@@ -159,7 +232,7 @@ func (cl *Client) GetTimeSeriesStringSampling(ctx context.Context, properties []
 	}
 
 	requests := make([]iotclient.BatchQuerySampledRequestMediaV1, 0, len(properties))
-	limit := int64(1000)
+	limit := SeriesLimit
 	for _, prop := range properties {
 		if prop == "" {
 			continue
@@ -183,13 +256,15 @@ func (cl *Client) GetTimeSeriesStringSampling(ctx context.Context, properties []
 
 	request := cl.api.SeriesV2Api.SeriesV2BatchQuerySampling(ctx)
 	request = request.BatchQuerySampledRequestsMediaV1(batchQueryRequestsMediaV1)
+	if err := cl.limiter.Wait(ctx); err != nil {
+		return nil, false, err
+	}
 	ts, httpResponse, err := cl.api.SeriesV2Api.SeriesV2BatchQuerySamplingExecute(request)
+	rateLimited := cl.checkRateLimit(httpResponse)
 	if err != nil {
 		err = fmt.Errorf("retrieving time series sampling: %w", errorDetail(err))
-		if httpResponse != nil && httpResponse.StatusCode == 429 { // Retry if rate limited
-			return nil, true, err
-		}
-		return nil, false, err
+		// Retry if rate limited. Next call waits for backoff or Retry-After delay.
+		return nil, rateLimited, err
 	}
 	return ts, false, nil
 }
@@ -204,11 +279,14 @@ func (cl *Client) GetRawTimeSeriesByThing(ctx context.Context, thingID string, f
 		return nil, false, err
 	}
 
+	limit := SeriesLimit
 	requests := []iotclient.BatchQueryRawRequestMediaV1{
 		{
-			From: &from,
-			Q:    fmt.Sprintf("thing.%s", thingID),
-			To:   &to,
+			From:        &from,
+			Q:           fmt.Sprintf("thing.%s", thingID),
+			To:          &to,
+			SeriesLimit: &limit,
+			Sort:        &rawSeriesSort,
 		},
 	}
 
@@ -222,13 +300,74 @@ func (cl *Client) GetRawTimeSeriesByThing(ctx context.Context, thingID string, f
 
 	request := cl.api.SeriesV2Api.SeriesV2BatchQueryRaw(ctx)
 	request = request.BatchQueryRawRequestsMediaV1(batchQueryRequestsMediaV1)
+	if err := cl.limiter.Wait(ctx); err != nil {
+		return nil, false, err
+	}
 	ts, httpResponse, err := cl.api.SeriesV2Api.SeriesV2BatchQueryRawExecute(request)
+	rateLimited := cl.checkRateLimit(httpResponse)
 	if err != nil {
 		err = fmt.Errorf("retrieving raw time series: %w", errorDetail(err))
-		if httpResponse != nil && httpResponse.StatusCode == 429 { // Retry if rate limited
-			return nil, true, err
+		// Retry if rate limited. Next call waits for backoff or Retry-After delay.
+		return nil, rateLimited, err
+	}
+	return ts, false, nil
+}
+
+// GetRawTimeSeriesByProperties returns raw time series of the given properties
+func (cl *Client) GetRawTimeSeriesByProperties(ctx context.Context, properties []string, from, to time.Time) (*iotclient.ArduinoSeriesRawBatch, bool, error) {
+	if len(properties) == 0 {
+		return nil, false, fmt.Errorf("no properties provided")
+	}
+
+	ctx, err := ctxWithToken(ctx, cl.token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	requests := make([]iotclient.BatchQueryRawRequestMediaV1, 0, len(properties))
+	limit := SeriesLimit
+	for _, prop := range properties {
+		if prop == "" {
+			continue
 		}
+		requests = append(requests, iotclient.BatchQueryRawRequestMediaV1{
+			From:        &from,
+			Q:           fmt.Sprintf("property.%s", prop),
+			To:          &to,
+			SeriesLimit: &limit,
+			Sort:        &rawSeriesSort,
+		})
+	}
+
+	if len(requests) == 0 {
+		return nil, false, fmt.Errorf("no valid properties provided")
+	}
+
+	batchQueryRequestsMediaV1 := iotclient.BatchQueryRawRequestsMediaV1{
+		Requests: requests,
+	}
+
+	request := cl.api.SeriesV2Api.SeriesV2BatchQueryRaw(ctx)
+	request = request.BatchQueryRawRequestsMediaV1(batchQueryRequestsMediaV1)
+	if err := cl.limiter.Wait(ctx); err != nil {
 		return nil, false, err
 	}
+	ts, httpResponse, err := cl.api.SeriesV2Api.SeriesV2BatchQueryRawExecute(request)
+	rateLimited := cl.checkRateLimit(httpResponse)
+	if err != nil {
+		err = fmt.Errorf("retrieving raw time series: %w", errorDetail(err))
+		// Retry if rate limited. Next call waits for backoff or Retry-After delay.
+		return nil, rateLimited, err
+	}
 	return ts, false, nil
 }
+
+// checkRateLimit updates the shared rate limiter with API response, reporting if request was rate limited
+func (cl *Client) checkRateLimit(httpResponse *http.Response) bool {
+	if httpResponse != nil && httpResponse.StatusCode == http.StatusTooManyRequests {
+		cl.limiter.Throttled(parseRetryAfter(httpResponse.Header.Get("Retry-After"), time.Now()))
+		return true
+	}
+	cl.limiter.Succeeded()
+	return false
+}
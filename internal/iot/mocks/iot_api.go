@@ -1,4 +1,4 @@
-// Code generated by mockery v2.46.0. DO NOT EDIT.
+// Code generated by mockery v2.53.7. DO NOT EDIT.
 
 package mocks
 
@@ -17,6 +17,43 @@ type API struct {
 	mock.Mock
 }
 
+// GetRawTimeSeriesByProperties provides a mock function with given fields: ctx, properties, from, to
+func (_m *API) GetRawTimeSeriesByProperties(ctx context.Context, properties []string, from time.Time, to time.Time) (*iot.ArduinoSeriesRawBatch, bool, error) {
+	ret := _m.Called(ctx, properties, from, to)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetRawTimeSeriesByProperties")
+	}
+
+	var r0 *iot.ArduinoSeriesRawBatch
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string, time.Time, time.Time) (*iot.ArduinoSeriesRawBatch, bool, error)); ok {
+		return rf(ctx, properties, from, to)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string, time.Time, time.Time) *iot.ArduinoSeriesRawBatch); ok {
+		r0 = rf(ctx, properties, from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*iot.ArduinoSeriesRawBatch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string, time.Time, time.Time) bool); ok {
+		r1 = rf(ctx, properties, from, to)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, []string, time.Time, time.Time) error); ok {
+		r2 = rf(ctx, properties, from, to)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetRawTimeSeriesByThing provides a mock function with given fields: ctx, thingID, from, to
 func (_m *API) GetRawTimeSeriesByThing(ctx context.Context, thingID string, from time.Time, to time.Time) (*iot.ArduinoSeriesRawBatch, bool, error) {
 	ret := _m.Called(ctx, thingID, from, to)
@@ -54,9 +91,46 @@ func (_m *API) GetRawTimeSeriesByThing(ctx context.Context, thingID string, from
 	return r0, r1, r2
 }
 
-// GetTimeSeriesByThing provides a mock function with given fields: ctx, thingID, from, to, interval, aggregationStat
-func (_m *API) GetTimeSeriesByThing(ctx context.Context, thingID string, from time.Time, to time.Time, interval int64, aggregationStat string) (*iot.ArduinoSeriesBatch, bool, error) {
-	ret := _m.Called(ctx, thingID, from, to, interval, aggregationStat)
+// GetTimeSeriesByProperties provides a mock function with given fields: ctx, properties, from, to, interval, aggregationStats
+func (_m *API) GetTimeSeriesByProperties(ctx context.Context, properties []string, from time.Time, to time.Time, interval int64, aggregationStats []string) (*iot.ArduinoSeriesBatch, bool, error) {
+	ret := _m.Called(ctx, properties, from, to, interval, aggregationStats)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTimeSeriesByProperties")
+	}
+
+	var r0 *iot.ArduinoSeriesBatch
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string, time.Time, time.Time, int64, []string) (*iot.ArduinoSeriesBatch, bool, error)); ok {
+		return rf(ctx, properties, from, to, interval, aggregationStats)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string, time.Time, time.Time, int64, []string) *iot.ArduinoSeriesBatch); ok {
+		r0 = rf(ctx, properties, from, to, interval, aggregationStats)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*iot.ArduinoSeriesBatch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string, time.Time, time.Time, int64, []string) bool); ok {
+		r1 = rf(ctx, properties, from, to, interval, aggregationStats)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, []string, time.Time, time.Time, int64, []string) error); ok {
+		r2 = rf(ctx, properties, from, to, interval, aggregationStats)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetTimeSeriesByThing provides a mock function with given fields: ctx, thingID, from, to, interval, aggregationStats
+func (_m *API) GetTimeSeriesByThing(ctx context.Context, thingID string, from time.Time, to time.Time, interval int64, aggregationStats []string) (*iot.ArduinoSeriesBatch, bool, error) {
+	ret := _m.Called(ctx, thingID, from, to, interval, aggregationStats)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetTimeSeriesByThing")
@@ -65,25 +139,25 @@ func (_m *API) GetTimeSeriesByThing(ctx context.Context, thingID string, from ti
 	var r0 *iot.ArduinoSeriesBatch
 	var r1 bool
 	var r2 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int64, string) (*iot.ArduinoSeriesBatch, bool, error)); ok {
-		return rf(ctx, thingID, from, to, interval, aggregationStat)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int64, []string) (*iot.ArduinoSeriesBatch, bool, error)); ok {
+		return rf(ctx, thingID, from, to, interval, aggregationStats)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int64, string) *iot.ArduinoSeriesBatch); ok {
-		r0 = rf(ctx, thingID, from, to, interval, aggregationStat)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int64, []string) *iot.ArduinoSeriesBatch); ok {
+		r0 = rf(ctx, thingID, from, to, interval, aggregationStats)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*iot.ArduinoSeriesBatch)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time, int64, string) bool); ok {
-		r1 = rf(ctx, thingID, from, to, interval, aggregationStat)
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time, int64, []string) bool); ok {
+		r1 = rf(ctx, thingID, from, to, interval, aggregationStats)
 	} else {
 		r1 = ret.Get(1).(bool)
 	}
 
-	if rf, ok := ret.Get(2).(func(context.Context, string, time.Time, time.Time, int64, string) error); ok {
-		r2 = rf(ctx, thingID, from, to, interval, aggregationStat)
+	if rf, ok := ret.Get(2).(func(context.Context, string, time.Time, time.Time, int64, []string) error); ok {
+		r2 = rf(ctx, thingID, from, to, interval, aggregationStats)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -129,7 +203,7 @@ func (_m *API) GetTimeSeriesStringSampling(ctx context.Context, properties []str
 }
 
 // ThingList provides a mock function with given fields: ctx, ids, device, props, tags
-func (_m *API) ThingList(ctx context.Context, ids []string, device *string, props bool, tags map[string]string) ([]iot.ArduinoThing, error) {
+func (_m *API) ThingList(ctx context.Context, ids []string, device *string, props bool, tags map[string]string) ([]iot.ArduinoThing, bool, error) {
 	ret := _m.Called(ctx, ids, device, props, tags)
 
 	if len(ret) == 0 {
@@ -137,8 +211,9 @@ func (_m *API) ThingList(ctx context.Context, ids []string, device *string, prop
 	}
 
 	var r0 []iot.ArduinoThing
-	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, []string, *string, bool, map[string]string) ([]iot.ArduinoThing, error)); ok {
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string, *string, bool, map[string]string) ([]iot.ArduinoThing, bool, error)); ok {
 		return rf(ctx, ids, device, props, tags)
 	}
 	if rf, ok := ret.Get(0).(func(context.Context, []string, *string, bool, map[string]string) []iot.ArduinoThing); ok {
@@ -149,13 +224,19 @@ func (_m *API) ThingList(ctx context.Context, ids []string, device *string, prop
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, []string, *string, bool, map[string]string) error); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, []string, *string, bool, map[string]string) bool); ok {
 		r1 = rf(ctx, ids, device, props, tags)
 	} else {
-		r1 = ret.Error(1)
+		r1 = ret.Get(1).(bool)
 	}
 
-	return r0, r1
+	if rf, ok := ret.Get(2).(func(context.Context, []string, *string, bool, map[string]string) error); ok {
+		r2 = rf(ctx, ids, device, props, tags)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
 }
 
 // NewAPI creates a new instance of API. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
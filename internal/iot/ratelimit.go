@@ -0,0 +1,156 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package iot
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestsPerSecond = 10
+	defaultBurst             = 10
+	defaultBackoffBase       = time.Second
+	defaultBackoffMax        = 30 * time.Second
+)
+
+// RateLimiter is a token bucket shared by all the calls performed by a client.
+// When the API reports a rate limit, every caller is paused for an exponentially growing,
+// jittered delay, or for the delay requested by the API with Retry-After header.
+type RateLimiter struct {
+	lock        sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	failures    int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	now         func() time.Time
+}
+
+func NewRateLimiter(requestsPerSecond float64, burst int, backoffBase, backoffMax time.Duration) *RateLimiter {
+	return &RateLimiter{
+		rate:        requestsPerSecond,
+		burst:       float64(burst),
+		tokens:      float64(burst),
+		last:        time.Now(),
+		backoffBase: backoffBase,
+		backoffMax:  backoffMax,
+		now:         time.Now,
+	}
+}
+
+// Wait blocks until a request can be performed, or context is done
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := r.reserve()
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token, if available. Otherwise, it returns how long to wait before trying again.
+func (r *RateLimiter) reserve() time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	if now.Before(r.pausedUntil) {
+		return r.pausedUntil.Sub(now)
+	}
+	if elapsed := now.Sub(r.last).Seconds(); elapsed > 0 {
+		r.tokens = min(r.burst, r.tokens+elapsed*r.rate)
+	}
+	r.last = now
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+	return time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+}
+
+// Throttled records a rate limited response and pauses all callers. Pause lasts at least retryAfter,
+// when provided by the API. Returned value is the applied pause.
+func (r *RateLimiter) Throttled(retryAfter time.Duration) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.failures++
+	delay := r.backoff(r.failures)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	if until := r.now().Add(delay); until.After(r.pausedUntil) {
+		r.pausedUntil = until
+	}
+	r.tokens = 0
+	return delay
+}
+
+// Succeeded resets backoff after a request not rate limited
+func (r *RateLimiter) Succeeded() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failures = 0
+}
+
+// backoff returns exponential delay for the given consecutive failures, with jitter in [delay/2, delay)
+func (r *RateLimiter) backoff(failures int) time.Duration {
+	delay := r.backoffMax
+	if failures < 32 {
+		if exp := r.backoffBase << (failures - 1); exp > 0 && exp < r.backoffMax {
+			delay = exp
+		}
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
+}
+
+// parseRetryAfter parses Retry-After header value, expressed in seconds or as HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package iot
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimiter(now *time.Time) *RateLimiter {
+	limiter := NewRateLimiter(2, 2, time.Second, 8*time.Second)
+	limiter.now = func() time.Time { return *now }
+	limiter.last = *now
+	return limiter
+}
+
+func TestRateLimiter_tokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	// Burst is available immediately
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	// Then one token every 500ms
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+
+	// Bucket never holds more than burst
+	now = now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+}
+
+func TestRateLimiter_exponentialBackoff(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for _, upper := range expected {
+		delay := limiter.Throttled(0)
+		assert.GreaterOrEqual(t, delay, upper/2)
+		assert.Less(t, delay, upper)
+		// All callers are paused
+		assert.Equal(t, delay, limiter.reserve())
+		now = now.Add(delay)
+	}
+
+	// Success resets backoff
+	limiter.Succeeded()
+	delay := limiter.Throttled(0)
+	assert.Less(t, delay, time.Second)
+}
+
+func TestRateLimiter_retryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	delay := limiter.Throttled(20 * time.Second)
+	assert.Equal(t, 20*time.Second, delay)
+	assert.Equal(t, 20*time.Second, limiter.reserve())
+
+	// A shorter backoff does not shorten the pause
+	now = now.Add(5 * time.Second)
+	limiter.Throttled(0)
+	assert.Equal(t, 15*time.Second, limiter.reserve())
+}
+
+func TestRateLimiter_waitHonorsContext(t *testing.T) {
+	limiter := NewRateLimiter(1, 1, time.Second, time.Minute)
+	limiter.Throttled(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
@@ -274,6 +274,27 @@ func IsPropertyLocation(pType string) bool {
 	return Type(pType) == Location
 }
 
+var complexPropertyTypes = []Type{
+	Location,
+	ColorHSB,
+	ColorRGB,
+	GenericComplexProperty,
+	Schedule,
+	HomeColoredLight,
+	HomeDimmedLight,
+	HomeTelevision,
+}
+
+// IsPropertyComplex reports if property values are maps of sub-fields
+func IsPropertyComplex(pType string) bool {
+	for _, tpy := range complexPropertyTypes {
+		if pType == string(tpy) {
+			return true
+		}
+	}
+	return false
+}
+
 func IsPropertyBool(pType string) bool {
 	for _, tpy := range booleanPropertyTypes {
 		if pType == string(tpy) {
@@ -0,0 +1,184 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/iot"
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	baseTmpStorage = "/tmp"
+)
+
+// Record is the JSON object written for every sample, one per line.
+// Value keeps its native JSON type: numbers, booleans, strings or objects for complex properties.
+type Record struct {
+	Timestamp            time.Time `json:"timestamp"`
+	ThingID              string    `json:"thing_id"`
+	ThingName            string    `json:"thing_name"`
+	PropertyID           string    `json:"property_id"`
+	PropertyName         string    `json:"property_name"`
+	PropertyType         string    `json:"property_type"`
+	Value                any       `json:"value"`
+	AggregationStatistic string    `json:"aggregation_statistic,omitempty"`
+}
+
+func NewWriter(destinationHour time.Time, logger *logrus.Entry, isRawData bool) (*JsonlWriter, error) {
+	// Use a unique file name, as multiple files can be generated for the same time window
+	file, err := os.CreateTemp(baseTmpStorage, fmt.Sprintf("%s-*.jsonl", destinationHour.Format("2006-01-02-15-04")))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating file: %w", err)
+	}
+	return &JsonlWriter{
+		outFile:   file,
+		logger:    logger,
+		out:       bufio.NewWriter(file),
+		filePath:  file.Name(),
+		isRawData: isRawData,
+	}, nil
+}
+
+// NewStreamWriter returns a writer producing json lines on the given stream, without any local file.
+// Stream is not closed by the writer.
+func NewStreamWriter(out io.Writer, logger *logrus.Entry, isRawData bool) (*JsonlWriter, error) {
+	return &JsonlWriter{
+		logger:    logger,
+		out:       bufio.NewWriter(out),
+		isRawData: isRawData,
+	}, nil
+}
+
+type JsonlWriter struct {
+	fileWriteLock sync.Mutex
+	outFile       *os.File
+	logger        *logrus.Entry
+	out           *bufio.Writer
+	filePath      string
+	isRawData     bool
+}
+
+func (j *JsonlWriter) Write(toWrite []samples.Sample) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	for _, sample := range toWrite {
+		// Encoder terminates every object with a new line
+		if err := encoder.Encode(j.toRecord(sample)); err != nil {
+			return err
+		}
+	}
+
+	j.fileWriteLock.Lock()
+	defer j.fileWriteLock.Unlock()
+	if _, err := j.out.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	return j.out.Flush()
+}
+
+func (j *JsonlWriter) toRecord(sample samples.Sample) Record {
+	record := Record{
+		Timestamp:    sample.Time.UTC(),
+		ThingID:      sample.ThingID,
+		ThingName:    sample.ThingName,
+		PropertyID:   sample.PropertyID,
+		PropertyName: sample.PropertyName,
+		PropertyType: sample.PropertyType,
+		Value:        nativeValue(sample),
+	}
+	if !j.isRawData {
+		record.AggregationStatistic = sample.Aggregation
+	}
+	return record
+}
+
+// nativeValue converts sample value to the json type matching property type
+func nativeValue(sample samples.Sample) any {
+	switch {
+	case iot.IsPropertyNumberType(sample.PropertyType):
+		if v, ok := samples.ValueToFloat(sample.Value); ok {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil
+			}
+			return v
+		}
+	case iot.IsPropertyBool(sample.PropertyType):
+		if v, ok := sample.Value.(string); ok {
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	case iot.IsPropertyString(sample.PropertyType):
+		return samples.ValueToString(sample.Value)
+	default:
+		// Complex properties can be returned as json encoded strings
+		if v, ok := sample.Value.(string); ok && strings.HasPrefix(strings.TrimSpace(v), "{") && json.Valid([]byte(v)) {
+			return json.RawMessage(v)
+		}
+	}
+	if v, ok := sample.Value.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return nil
+	}
+	return sample.Value
+}
+
+func (j *JsonlWriter) GetFilePath() string {
+	return j.filePath
+}
+
+func (j *JsonlWriter) Close() error {
+	if j.out != nil && j.outFile == nil {
+		// Stream writer
+		err := j.out.Flush()
+		j.out = nil
+		return err
+	}
+	if j.out != nil && j.outFile != nil {
+		j.logger.Infoln("Closing ouput jsonl file ", j.outFile.Name())
+		flushErr := j.out.Flush()
+		err := j.outFile.Close()
+		j.out = nil
+		j.outFile = nil
+		if flushErr != nil {
+			return flushErr
+		}
+		return err
+	} else {
+		return errors.New("no file to close")
+	}
+}
+
+func (j *JsonlWriter) Delete() error {
+	if j.outFile != nil {
+		j.Close()
+	}
+	return os.Remove(j.filePath)
+}
@@ -0,0 +1,53 @@
+package jsonl
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var ts = time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+
+func TestStreamWriter_nativeValues(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewStreamWriter(&out, logrus.NewEntry(logrus.New()), false)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p1", PropertyName: "temperature", PropertyType: "FLOAT", Value: 21.5, Aggregation: "AVG"},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p2", PropertyName: "enabled", PropertyType: "STATUS", Value: "true", Aggregation: "AVG"},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p3", PropertyName: "status", PropertyType: "CHARSTRING", Value: "<on>", Aggregation: "AVG"},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p4", PropertyName: "position", PropertyType: "LOCATION", Value: `{"lat":45.1,"lon":7.6}`, Aggregation: "AVG"},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p5", PropertyName: "humidity", PropertyType: "FLOAT", Value: math.NaN(), Aggregation: "AVG"},
+	}))
+	assert.NoError(t, writer.Close())
+	assert.Equal(t, "", writer.GetFilePath())
+
+	assert.Equal(t,
+		`{"timestamp":"2024-09-04T11:00:00Z","thing_id":"th1","thing_name":"one","property_id":"p1","property_name":"temperature","property_type":"FLOAT","value":21.5,"aggregation_statistic":"AVG"}`+"\n"+
+			`{"timestamp":"2024-09-04T11:00:00Z","thing_id":"th1","thing_name":"one","property_id":"p2","property_name":"enabled","property_type":"STATUS","value":true,"aggregation_statistic":"AVG"}`+"\n"+
+			`{"timestamp":"2024-09-04T11:00:00Z","thing_id":"th1","thing_name":"one","property_id":"p3","property_name":"status","property_type":"CHARSTRING","value":"<on>","aggregation_statistic":"AVG"}`+"\n"+
+			`{"timestamp":"2024-09-04T11:00:00Z","thing_id":"th1","thing_name":"one","property_id":"p4","property_name":"position","property_type":"LOCATION","value":{"lat":45.1,"lon":7.6},"aggregation_statistic":"AVG"}`+"\n"+
+			`{"timestamp":"2024-09-04T11:00:00Z","thing_id":"th1","thing_name":"one","property_id":"p5","property_name":"humidity","property_type":"FLOAT","value":null,"aggregation_statistic":"AVG"}`+"\n",
+		out.String())
+}
+
+func TestWriter_rawFile(t *testing.T) {
+	writer, err := NewWriter(ts, logrus.NewEntry(logrus.New()), true)
+	assert.NoError(t, err)
+	defer writer.Delete()
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p1", PropertyName: "temperature", PropertyType: "FLOAT", Value: 21.5},
+	}))
+	assert.NoError(t, writer.Close())
+	assert.Error(t, writer.Close())
+
+	content, err := os.ReadFile(writer.GetFilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, `{"timestamp":"2024-09-04T11:00:00Z","thing_id":"th1","thing_name":"one","property_id":"p1","property_name":"temperature","property_type":"FLOAT","value":21.5}`+"\n", string(content))
+}
@@ -0,0 +1,154 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package keytemplate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	PresetDefault = "default"
+	PresetHive    = "hive"
+)
+
+var presets = map[string]string{
+	PresetDefault: "{date}/{from}.{ext}",
+	PresetHive:    "year={year}/month={month}/day={day}/hour={hour}/{from}.{ext}",
+}
+
+const (
+	Stack     = "stack"
+	Year      = "year"
+	Month     = "month"
+	Day       = "day"
+	Hour      = "hour"
+	Minute    = "minute"
+	Date      = "date"
+	From      = "from"
+	To        = "to"
+	ThingID   = "thing_id"
+	ThingName = "thing_name"
+	TagValue  = "tag_value"
+	Ext       = "ext"
+)
+
+var placeholders = []string{Stack, Year, Month, Day, Hour, Minute, Date, From, To, ThingID, ThingName, TagValue, Ext}
+
+var placeholderRegexp = regexp.MustCompile(`\{([a-z_]*)\}`)
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Values used to render a key. Date placeholders refer to window start (From).
+type Values struct {
+	Stack     string
+	From      time.Time
+	To        time.Time
+	ThingID   string
+	ThingName string
+	TagValue  string
+	Ext       string
+}
+
+// Template builds destination object keys from a template string, for example:
+// "{stack}/year={year}/month={month}/day={day}/hour={hour}/{from}.{ext}"
+type Template struct {
+	template string
+}
+
+// Parse accepts a preset name (default, hive) or a template string, rejecting unknown placeholders
+func Parse(template string) (*Template, error) {
+	template = strings.TrimSpace(template)
+	if template == "" {
+		template = PresetDefault
+	}
+	if preset, ok := presets[template]; ok {
+		template = preset
+	}
+	matches := placeholderRegexp.FindAllStringSubmatch(template, -1)
+	for _, match := range matches {
+		if !isPlaceholder(match[1]) {
+			return nil, fmt.Errorf("unknown key template placeholder: %s", match[0])
+		}
+	}
+	if strings.Count(template, "{") != len(matches) || strings.Count(template, "}") != len(matches) {
+		return nil, fmt.Errorf("malformed key template: %s", template)
+	}
+	if strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("key template cannot start with '/': %s", template)
+	}
+	return &Template{template: template}, nil
+}
+
+func isPlaceholder(name string) bool {
+	for _, p := range placeholders {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) String() string {
+	return t.template
+}
+
+// Uses reports if given placeholder is present in the template
+func (t *Template) Uses(placeholder string) bool {
+	return strings.Contains(t.template, "{"+placeholder+"}")
+}
+
+func (t *Template) Render(v Values) string {
+	from := v.From.UTC()
+	to := v.To.UTC()
+	return placeholderRegexp.ReplaceAllStringFunc(t.template, func(match string) string {
+		switch strings.Trim(match, "{}") {
+		case Stack:
+			return sanitize(v.Stack)
+		case Year:
+			return from.Format("2006")
+		case Month:
+			return from.Format("01")
+		case Day:
+			return from.Format("02")
+		case Hour:
+			return from.Format("15")
+		case Minute:
+			return from.Format("04")
+		case Date:
+			return from.Format("2006-01-02")
+		case From:
+			return from.Format("2006-01-02-15-04")
+		case To:
+			return to.Format("2006-01-02-15-04")
+		case ThingID:
+			return sanitize(v.ThingID)
+		case ThingName:
+			return sanitize(v.ThingName)
+		case TagValue:
+			return sanitize(v.TagValue)
+		case Ext:
+			return v.Ext
+		}
+		return match
+	})
+}
+
+// sanitize replaces characters that are not safe in object keys
+func sanitize(value string) string {
+	return unsafeKeyChars.ReplaceAllString(value, "_")
+}
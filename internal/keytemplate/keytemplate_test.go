@@ -0,0 +1,46 @@
+package keytemplate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testValues = Values{
+	Stack:     "prod",
+	From:      time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC),
+	To:        time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC),
+	ThingID:   "07846f3c-37ae-4722-a3f5-65d7b4449ad3",
+	ThingName: "H7 board/1",
+	Ext:       "csv.gz",
+}
+
+func TestTemplate_defaultPreset(t *testing.T) {
+	tpl, err := Parse("")
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-09-04/2024-09-04-11-00.csv.gz", tpl.Render(testValues))
+}
+
+func TestTemplate_hivePreset(t *testing.T) {
+	tpl, err := Parse(PresetHive)
+	assert.NoError(t, err)
+	assert.Equal(t, "year=2024/month=09/day=04/hour=11/2024-09-04-11-00.csv.gz", tpl.Render(testValues))
+}
+
+func TestTemplate_custom(t *testing.T) {
+	tpl, err := Parse("{stack}/{thing_name}/{from}_{to}.{ext}")
+	assert.NoError(t, err)
+	assert.True(t, tpl.Uses(ThingName))
+	assert.False(t, tpl.Uses(ThingID))
+	assert.Equal(t, "prod/H7_board_1/2024-09-04-11-00_2024-09-04-12-00.csv.gz", tpl.Render(testValues))
+}
+
+func TestTemplate_invalid(t *testing.T) {
+	_, err := Parse("{date}/{unknown}.{ext}")
+	assert.Error(t, err)
+	_, err = Parse("{date/{from}.{ext}")
+	assert.Error(t, err)
+	_, err = Parse("/{date}/{from}.{ext}")
+	assert.Error(t, err)
+}
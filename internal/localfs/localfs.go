@@ -0,0 +1,115 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/arduino/aws-s3-integration/internal/s3"
+)
+
+// Directory is a local destination implementing the same API of the S3 client.
+// Object keys are mapped to paths relative to the base directory.
+type Directory struct {
+	baseDir string
+}
+
+func NewDirectory(baseDir string) (*Directory, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory %s: %w", baseDir, err)
+	}
+	return &Directory{baseDir: baseDir}, nil
+}
+
+func (d *Directory) resolve(key string) (string, error) {
+	path := filepath.Join(d.baseDir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (d *Directory) WriteFile(ctx context.Context, key, filePath string) error {
+	inFile, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %s %w", filePath, err)
+	}
+	defer inFile.Close()
+
+	destPath, err := d.resolve(key)
+	if err != nil {
+		return err
+	}
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %s %w", destPath, err)
+	}
+	defer outFile.Close()
+
+	if _, err := io.Copy(outFile, inFile); err != nil {
+		return fmt.Errorf("failed to write file: %s %w", destPath, err)
+	}
+	return nil
+}
+
+// WriteStream writes content read from body to the destination path. On errors, partial file is removed.
+func (d *Directory) WriteStream(ctx context.Context, key string, body io.Reader) error {
+	destPath, err := d.resolve(key)
+	if err != nil {
+		return err
+	}
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %s %w", destPath, err)
+	}
+	_, err = io.Copy(outFile, body)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return fmt.Errorf("failed to write file: %s %w", destPath, err)
+	}
+	return nil
+}
+
+func (d *Directory) ReadObject(ctx context.Context, key string) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(d.baseDir, filepath.FromSlash(key)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, s3.ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+func (d *Directory) WriteObject(ctx context.Context, key string, content []byte) error {
+	destPath, err := d.resolve(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destPath, content, 0644)
+}
+
+func (d *Directory) DestinationBucket() string {
+	return d.baseDir
+}
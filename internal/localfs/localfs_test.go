@@ -0,0 +1,67 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arduino/aws-s3-integration/internal/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectory_objects(t *testing.T) {
+	ctx := context.Background()
+	dir, err := NewDirectory(filepath.Join(t.TempDir(), "out"))
+	assert.NoError(t, err)
+
+	_, err = dir.ReadObject(ctx, "state/watermark.json")
+	assert.ErrorIs(t, err, s3.ErrObjectNotFound)
+
+	assert.NoError(t, dir.WriteObject(ctx, "state/watermark.json", []byte("{}")))
+	content, err := dir.ReadObject(ctx, "state/watermark.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+}
+
+func TestDirectory_writeFile(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	dir, err := NewDirectory(base)
+	assert.NoError(t, err)
+
+	source := filepath.Join(t.TempDir(), "source.csv")
+	assert.NoError(t, os.WriteFile(source, []byte("a,b\n"), 0644))
+	assert.NoError(t, dir.WriteFile(ctx, "2024-09-04/2024-09-04-11-00.csv", source))
+
+	content, err := os.ReadFile(filepath.Join(base, "2024-09-04", "2024-09-04-11-00.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a,b\n", string(content))
+
+	assert.Error(t, dir.WriteFile(ctx, "missing.csv", filepath.Join(t.TempDir(), "missing.csv")))
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("upload aborted")
+}
+
+func TestDirectory_writeStream(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	dir, err := NewDirectory(base)
+	assert.NoError(t, err)
+
+	assert.NoError(t, dir.WriteStream(ctx, "a/b.jsonl", strings.NewReader("{}\n")))
+	content, err := os.ReadFile(filepath.Join(base, "a", "b.jsonl"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}\n", string(content))
+
+	// Partial files are removed on errors
+	assert.ErrorContains(t, dir.WriteStream(ctx, "a/c.jsonl", failingReader{}), "upload aborted")
+	_, err = os.Stat(filepath.Join(base, "a", "c.jsonl"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -17,26 +17,54 @@ package parameters
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
 )
 
 const StackName = "<stack-name>"
 
+// Value of parameters intentionally left empty, as SSM does not allow empty values
+const emptyValue = "<empty>"
+
+// DefaultCacheTTL is how long parameters loaded by path are reused, also across warm lambda invocations
+const DefaultCacheTTL = 5 * time.Minute
+
+type ssmAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+}
+
 type ParametersClient struct {
-	ssmcl *ssm.Client
+	ssmcl ssmAPI
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	paths map[string]*pathCache
 }
 
-func New() (*ParametersClient, error) {
+// pathCache holds parameters loaded from a path, by full name
+type pathCache struct {
+	values   map[string]string
+	loadedAt time.Time
+}
+
+// New creates a parameters client. Parameters loaded by path with Preload are cached for ttl. When ttl is 0,
+// every Preload loads parameters again, and they are served from memory until next Preload (for example, for a single lambda invocation).
+func New(ctx context.Context, ttl time.Duration) (*ParametersClient, error) {
 
 	awsOpts := []func(*config.LoadOptions) error{}
 
 	cfg, err := config.LoadDefaultConfig(
-		context.Background(),
+		ctx,
 		awsOpts...,
 	)
 	if err != nil {
@@ -45,54 +73,148 @@ func New() (*ParametersClient, error) {
 
 	cl := ssm.NewFromConfig(cfg)
 
+	return newClient(cl, ttl), nil
+}
+
+func newClient(ssmcl ssmAPI, ttl time.Duration) *ParametersClient {
 	return &ParametersClient{
-		ssmcl: cl,
-	}, nil
+		ssmcl: ssmcl,
+		ttl:   ttl,
+		now:   time.Now,
+		paths: map[string]*pathCache{},
+	}
 }
 
 func (c *ParametersClient) ResolveParameter(param, stack string) string {
+	return ResolveParameter(param, stack)
+}
+
+// ResolveParameter replaces the stack name placeholder of a per stack parameter
+func ResolveParameter(param, stack string) string {
 	return strings.ReplaceAll(param, StackName, stack)
 }
 
-func (c *ParametersClient) ReadConfigByStack(param, stack string) (*string, error) {
+// Preload reads all parameters under path (for example /arduino/s3-exporter/my-stack) with paged GetParametersByPath calls.
+// Following lookups of parameters under path are served from memory until cache expires.
+func (c *ParametersClient) Preload(ctx context.Context, path string) error {
+	path = strings.TrimSuffix(path, "/")
+	if c.ttl > 0 && c.cached(path) != nil {
+		return nil
+	}
+
+	values := map[string]string{}
+	paginator := ssm.NewGetParametersByPathPaginator(c.ssmcl, &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, param := range page.Parameters {
+			if param.Name == nil {
+				continue
+			}
+			values[*param.Name] = paramValue(param.Value)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths[path] = &pathCache{values: values, loadedAt: c.now()}
+	return nil
+}
+
+// cached returns the not expired cache of path, if any. Without ttl, cache lasts until path is loaded again.
+func (c *ParametersClient) cached(path string) *pathCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cache, ok := c.paths[path]
+	if !ok {
+		return nil
+	}
+	if c.ttl > 0 && c.now().Sub(cache.loadedAt) >= c.ttl {
+		delete(c.paths, path)
+		return nil
+	}
+	return cache
+}
+
+// lookupCached looks a parameter up in cached paths. Last return value reports whether param is under a cached path.
+func (c *ParametersClient) lookupCached(param string) (string, bool, bool) {
+	for path := param; ; {
+		idx := strings.LastIndex(path, "/")
+		if idx <= 0 {
+			return "", false, false
+		}
+		path = path[:idx]
+		if cache := c.cached(path); cache != nil {
+			value, found := cache.values[param]
+			return value, found, true
+		}
+	}
+}
+
+func (c *ParametersClient) ReadConfigByStack(ctx context.Context, param, stack string) (*string, error) {
 	param = c.ResolveParameter(param, stack)
-	return c.ReadConfig(param)
+	return c.ReadConfig(ctx, param)
+}
+
+func (c *ParametersClient) ReadConfig(ctx context.Context, param string) (*string, error) {
+	value, found, err := c.Lookup(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &types.ParameterNotFound{Message: aws.String("parameter not found: " + param)}
+	}
+	return &value, nil
 }
 
-func (c *ParametersClient) ReadConfig(param string) (*string, error) {
-	value, err := c.ssmcl.GetParameter(context.Background(), &ssm.GetParameterInput{
+// Lookup reads a parameter, reporting whether it exists. Parameters set to <empty> are returned as empty strings.
+// Parameters under a path loaded with Preload are served from cache.
+func (c *ParametersClient) Lookup(ctx context.Context, param string) (string, bool, error) {
+	if value, found, cached := c.lookupCached(param); cached {
+		return value, found, nil
+	}
+	value, err := c.ssmcl.GetParameter(ctx, &ssm.GetParameterInput{
 		Name:           aws.String(param),
 		WithDecryption: aws.Bool(true),
 	})
 	if err != nil {
-		return nil, err
+		var notFound *types.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return "", false, nil
+		}
+		return "", false, err
 	}
-	paramValue := value.Parameter.Value
-	if paramValue == nil || *paramValue == "<empty>" {
-		defaultValue := ""
-		return &defaultValue, nil
+	return paramValue(value.Parameter.Value), true, nil
+}
+
+func paramValue(value *string) string {
+	if value == nil || *value == emptyValue {
+		return ""
 	}
-	return paramValue, nil
+	return *value
 }
 
-func (c *ParametersClient) ReadIntConfigByStack(param, stack string) (*int, error) {
+func (c *ParametersClient) ReadIntConfigByStack(ctx context.Context, param, stack string) (*int, error) {
 	param = c.ResolveParameter(param, stack)
-	return c.ReadIntConfig(param)
+	return c.ReadIntConfig(ctx, param)
 }
 
-func (c *ParametersClient) ReadIntConfig(param string) (*int, error) {
-	value, err := c.ssmcl.GetParameter(context.Background(), &ssm.GetParameterInput{
-		Name:           aws.String(param),
-		WithDecryption: aws.Bool(true),
-	})
+func (c *ParametersClient) ReadIntConfig(ctx context.Context, param string) (*int, error) {
+	value, err := c.ReadConfig(ctx, param)
 	if err != nil {
 		return nil, err
 	}
-	if value.Parameter.Value == nil {
+	if *value == "" {
 		defaultValue := -1
 		return &defaultValue, nil
 	}
-	strconvValue, err := strconv.Atoi(*value.Parameter.Value)
+	strconvValue, err := strconv.Atoi(*value)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,142 @@
+package parameters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSM serves parameters from a map, returning one parameter per page of GetParametersByPath
+type fakeSSM struct {
+	params         map[string]string
+	names          []string
+	pathCalls      int
+	parameterCalls int
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	f.parameterCalls++
+	value, ok := f.params[*params.Name]
+	if !ok {
+		return nil, &types.ParameterNotFound{}
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Name: params.Name, Value: aws.String(value)}}, nil
+}
+
+func (f *fakeSSM) GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.pathCalls++
+	idx := 0
+	if params.NextToken != nil {
+		idx = int((*params.NextToken)[0] - '0')
+	}
+	out := &ssm.GetParametersByPathOutput{}
+	for ; idx < len(f.names); idx++ {
+		name := f.names[idx]
+		if len(name) > len(*params.Path) && name[:len(*params.Path)+1] == *params.Path+"/" {
+			out.Parameters = []types.Parameter{{Name: aws.String(name), Value: aws.String(f.params[name])}}
+			idx++
+			break
+		}
+	}
+	if idx < len(f.names) {
+		out.NextToken = aws.String(string(rune('0' + idx)))
+	}
+	return out, nil
+}
+
+func newFakeSSM() *fakeSSM {
+	return &fakeSSM{
+		params: map[string]string{
+			"/arduino/s3-exporter/stack/iot/api-key":        "key",
+			"/arduino/s3-exporter/stack/iot/filter/tags":    "<empty>",
+			"/arduino/s3-exporter/stack/destination-bucket": "bucket",
+			"/arduino/s3-exporter/other/iot/api-key":        "other-key",
+		},
+		names: []string{
+			"/arduino/s3-exporter/other/iot/api-key",
+			"/arduino/s3-exporter/stack/destination-bucket",
+			"/arduino/s3-exporter/stack/iot/api-key",
+			"/arduino/s3-exporter/stack/iot/filter/tags",
+		},
+	}
+}
+
+func TestPreload_pagedAndCached(t *testing.T) {
+	ctx := context.Background()
+	ssmcl := newFakeSSM()
+	now := time.Date(2024, 10, 1, 11, 0, 0, 0, time.UTC)
+	cl := newClient(ssmcl, time.Minute)
+	cl.now = func() time.Time { return now }
+
+	assert.NoError(t, cl.Preload(ctx, "/arduino/s3-exporter/stack/"))
+	assert.Equal(t, 3, ssmcl.pathCalls)
+
+	value, found, err := cl.Lookup(ctx, "/arduino/s3-exporter/stack/iot/api-key")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "key", value)
+	value, found, _ = cl.Lookup(ctx, "/arduino/s3-exporter/stack/iot/filter/tags")
+	assert.True(t, found)
+	assert.Equal(t, "", value)
+	_, found, _ = cl.Lookup(ctx, "/arduino/s3-exporter/stack/output_format")
+	assert.False(t, found)
+	assert.Equal(t, 0, ssmcl.parameterCalls)
+
+	// Parameters outside of loaded path are read one by one
+	value, _, _ = cl.Lookup(ctx, "/arduino/s3-exporter/other/iot/api-key")
+	assert.Equal(t, "other-key", value)
+	assert.Equal(t, 1, ssmcl.parameterCalls)
+
+	// A warm invocation reuses cache, until it expires
+	assert.NoError(t, cl.Preload(ctx, "/arduino/s3-exporter/stack"))
+	assert.Equal(t, 3, ssmcl.pathCalls)
+	now = now.Add(time.Minute)
+	_, _, _ = cl.Lookup(ctx, "/arduino/s3-exporter/stack/iot/api-key")
+	assert.Equal(t, 2, ssmcl.parameterCalls)
+	assert.NoError(t, cl.Preload(ctx, "/arduino/s3-exporter/stack"))
+	assert.Equal(t, 6, ssmcl.pathCalls)
+}
+
+func TestPreload_withoutTTL(t *testing.T) {
+	ctx := context.Background()
+	ssmcl := newFakeSSM()
+	cl := newClient(ssmcl, 0)
+
+	// Loaded parameters are served from memory within the invocation
+	assert.NoError(t, cl.Preload(ctx, "/arduino/s3-exporter/stack"))
+	assert.Equal(t, 3, ssmcl.pathCalls)
+	value, found, err := cl.Lookup(ctx, "/arduino/s3-exporter/stack/iot/api-key")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "key", value)
+	_, found, _ = cl.Lookup(ctx, "/arduino/s3-exporter/stack/output_format")
+	assert.False(t, found)
+	assert.Equal(t, 0, ssmcl.parameterCalls)
+
+	// Next invocation loads them again
+	ssmcl.params["/arduino/s3-exporter/stack/iot/api-key"] = "rotated-key"
+	assert.NoError(t, cl.Preload(ctx, "/arduino/s3-exporter/stack"))
+	assert.Equal(t, 6, ssmcl.pathCalls)
+	value, _, _ = cl.Lookup(ctx, "/arduino/s3-exporter/stack/iot/api-key")
+	assert.Equal(t, "rotated-key", value)
+	assert.Equal(t, 0, ssmcl.parameterCalls)
+}
+
+func TestPreload_canceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl := newClient(newFakeSSM(), time.Minute)
+	assert.ErrorIs(t, cl.Preload(ctx, "/arduino/s3-exporter/stack"), context.Canceled)
+
+	// Failed loads are not cached
+	_, _, cached := cl.lookupCached("/arduino/s3-exporter/stack/iot/api-key")
+	assert.False(t, cached)
+}
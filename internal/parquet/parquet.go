@@ -0,0 +1,169 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package parquet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/iot"
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	pq "github.com/parquet-go/parquet-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	baseTmpStorage = "/tmp"
+
+	// Bound memory usage: rows are buffered in memory until a row group is flushed
+	maxRowsPerRowGroup = 100000
+)
+
+// Row is the parquet schema of exported samples. Numeric properties are stored in the
+// 'value' column as DOUBLE, all other property types in 'value_string'.
+// Pages are always Snappy compressed, so parquet files are never gzipped.
+type Row struct {
+	Timestamp            time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ThingID              string    `parquet:"thing_id"`
+	ThingName            string    `parquet:"thing_name"`
+	PropertyID           string    `parquet:"property_id"`
+	PropertyName         string    `parquet:"property_name"`
+	PropertyType         string    `parquet:"property_type"`
+	Value                *float64  `parquet:"value,optional"`
+	ValueString          *string   `parquet:"value_string,optional"`
+	AggregationStatistic *string   `parquet:"aggregation_statistic,optional"`
+}
+
+func NewWriter(destinationHour time.Time, logger *logrus.Entry, isRawData bool) (*ParquetWriter, error) {
+	// Use a unique file name, as multiple files can be generated for the same time window
+	file, err := os.CreateTemp(baseTmpStorage, fmt.Sprintf("%s-*.parquet", destinationHour.Format("2006-01-02-15-04")))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating file: %w", err)
+	}
+	filePath := file.Name()
+
+	return &ParquetWriter{
+		outFile:       file,
+		logger:        logger,
+		parquetWriter: newGenericWriter(file),
+		filePath:      filePath,
+		isRawData:     isRawData,
+	}, nil
+}
+
+// NewStreamWriter returns a writer producing parquet content on the given stream, without any local file.
+// Stream is not closed by the writer.
+func NewStreamWriter(out io.Writer, logger *logrus.Entry, isRawData bool) (*ParquetWriter, error) {
+	return &ParquetWriter{
+		logger:        logger,
+		parquetWriter: newGenericWriter(out),
+		isRawData:     isRawData,
+	}, nil
+}
+
+func newGenericWriter(out io.Writer) *pq.GenericWriter[Row] {
+	return pq.NewGenericWriter[Row](out, pq.Compression(&pq.Snappy), pq.MaxRowsPerRowGroup(maxRowsPerRowGroup))
+}
+
+type ParquetWriter struct {
+	fileWriteLock sync.Mutex
+	outFile       *os.File
+	logger        *logrus.Entry
+	parquetWriter *pq.GenericWriter[Row]
+	filePath      string
+	isRawData     bool
+}
+
+func (p *ParquetWriter) Write(toWrite []samples.Sample) error {
+	rows := make([]Row, 0, len(toWrite))
+	for _, sample := range toWrite {
+		rows = append(rows, p.toRow(sample))
+	}
+
+	p.fileWriteLock.Lock()
+	defer p.fileWriteLock.Unlock()
+
+	if _, err := p.parquetWriter.Write(rows); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *ParquetWriter) toRow(sample samples.Sample) Row {
+	row := Row{
+		Timestamp:    sample.Time.UTC(),
+		ThingID:      sample.ThingID,
+		ThingName:    sample.ThingName,
+		PropertyID:   sample.PropertyID,
+		PropertyName: sample.PropertyName,
+		PropertyType: sample.PropertyType,
+	}
+
+	if iot.IsPropertyNumberType(row.PropertyType) {
+		if v, ok := samples.ValueToFloat(sample.Value); ok {
+			row.Value = &v
+		} else {
+			p.logger.Warnf("Unable to convert value %v of property %s to a number, storing it as string", sample.Value, row.PropertyID)
+		}
+	}
+	if row.Value == nil {
+		value := samples.ValueToString(sample.Value)
+		row.ValueString = &value
+	}
+
+	if !p.isRawData {
+		aggregation := sample.Aggregation
+		row.AggregationStatistic = &aggregation
+	}
+	return row
+}
+
+func (p *ParquetWriter) GetFilePath() string {
+	return p.filePath
+}
+
+func (p *ParquetWriter) Close() error {
+	if p.parquetWriter != nil && p.outFile == nil {
+		// Stream writer
+		err := p.parquetWriter.Close()
+		p.parquetWriter = nil
+		return err
+	}
+	if p.parquetWriter != nil && p.outFile != nil {
+		p.logger.Infoln("Closing ouput parquet file ", p.outFile.Name())
+		writerErr := p.parquetWriter.Close()
+		err := p.outFile.Close()
+		p.parquetWriter = nil
+		p.outFile = nil
+		if writerErr != nil {
+			return writerErr
+		}
+		return err
+	} else {
+		return errors.New("no file to close")
+	}
+}
+
+func (p *ParquetWriter) Delete() error {
+	if p.outFile != nil {
+		p.Close()
+	}
+	return os.Remove(p.filePath)
+}
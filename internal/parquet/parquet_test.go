@@ -0,0 +1,57 @@
+package parquet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/samples"
+	pq "github.com/parquet-go/parquet-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewStreamWriter(&out, logrus.NewEntry(logrus.New()), false)
+	assert.NoError(t, err)
+
+	ts := time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p1", PropertyName: "temperature", PropertyType: "FLOAT", Value: 21.5, Aggregation: "AVG"},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p2", PropertyName: "status", PropertyType: "CHARSTRING", Value: "on", Aggregation: "AVG"},
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p3", PropertyName: "counter", PropertyType: "INT", Value: "not a number", Aggregation: "AVG"},
+	}))
+	assert.NoError(t, writer.Close())
+	assert.Equal(t, "", writer.GetFilePath())
+
+	rows, err := pq.Read[Row](bytes.NewReader(out.Bytes()), int64(out.Len()))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, ts, rows[0].Timestamp.UTC())
+	assert.Equal(t, 21.5, *rows[0].Value)
+	assert.Nil(t, rows[0].ValueString)
+	assert.Equal(t, "AVG", *rows[0].AggregationStatistic)
+	assert.Nil(t, rows[1].Value)
+	assert.Equal(t, "on", *rows[1].ValueString)
+	// Numeric values that can't be converted are kept as strings
+	assert.Nil(t, rows[2].Value)
+	assert.Equal(t, "not a number", *rows[2].ValueString)
+}
+
+func TestWriter_rawFile(t *testing.T) {
+	ts := time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+	writer, err := NewWriter(ts, logrus.NewEntry(logrus.New()), true)
+	assert.NoError(t, err)
+	defer writer.Delete()
+	assert.NoError(t, writer.Write([]samples.Sample{
+		{Time: ts, ThingID: "th1", ThingName: "one", PropertyID: "p1", PropertyName: "temperature", PropertyType: "FLOAT", Value: 21.5},
+	}))
+	assert.NoError(t, writer.Close())
+	assert.Error(t, writer.Close())
+
+	rows, err := pq.ReadFile[Row](writer.GetFilePath())
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Nil(t, rows[0].AggregationStatistic)
+}
@@ -0,0 +1,74 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package pattern
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Pattern matches names against a wildcard pattern (for example debug_*) or,
+// when enclosed in slashes, a regular expression (for example /^dbg[0-9]+$/)
+type Pattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+func Parse(pattern string) (Pattern, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid name pattern %s: %w", pattern, err)
+		}
+		return Pattern{regexp: re}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return Pattern{}, fmt.Errorf("invalid name pattern %s: %w", pattern, err)
+	}
+	return Pattern{glob: pattern}, nil
+}
+
+func ParseList(patterns []string) ([]Pattern, error) {
+	parsed := make([]Pattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Parse(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+func (p Pattern) Match(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// MatchAny reports whether name matches at least one of the patterns
+func MatchAny(patterns []Pattern, name string) bool {
+	for _, p := range patterns {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,10 @@
-// Code generated by mockery v2.44.1. DO NOT EDIT.
+// Code generated by mockery v2.53.7. DO NOT EDIT.
 
 package mocks
 
 import (
 	context "context"
+	io "io"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -13,7 +14,7 @@ type API struct {
 	mock.Mock
 }
 
-// DestinationBucket provides a mock function with given fields:
+// DestinationBucket provides a mock function with no fields
 func (_m *API) DestinationBucket() string {
 	ret := _m.Called()
 
@@ -31,6 +32,36 @@ func (_m *API) DestinationBucket() string {
 	return r0
 }
 
+// ReadObject provides a mock function with given fields: ctx, key
+func (_m *API) ReadObject(ctx context.Context, key string) ([]byte, error) {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReadObject")
+	}
+
+	var r0 []byte
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]byte, error)); ok {
+		return rf(ctx, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // WriteFile provides a mock function with given fields: ctx, key, filePath
 func (_m *API) WriteFile(ctx context.Context, key string, filePath string) error {
 	ret := _m.Called(ctx, key, filePath)
@@ -49,6 +80,42 @@ func (_m *API) WriteFile(ctx context.Context, key string, filePath string) error
 	return r0
 }
 
+// WriteObject provides a mock function with given fields: ctx, key, content
+func (_m *API) WriteObject(ctx context.Context, key string, content []byte) error {
+	ret := _m.Called(ctx, key, content)
+
+	if len(ret) == 0 {
+		panic("no return value specified for WriteObject")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = rf(ctx, key, content)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WriteStream provides a mock function with given fields: ctx, key, body
+func (_m *API) WriteStream(ctx context.Context, key string, body io.Reader) error {
+	ret := _m.Called(ctx, key, body)
+
+	if len(ret) == 0 {
+		panic("no return value specified for WriteStream")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, io.Reader) error); ok {
+		r0 = rf(ctx, key, body)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewAPI creates a new instance of API. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewAPI(t interface {
@@ -0,0 +1,43 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// prefixedAPI writes and reads objects under a key prefix of the wrapped destination
+type prefixedAPI struct {
+	api    API
+	prefix string
+}
+
+// WithPrefix returns a destination storing objects of api under prefix (for example tenant-a/). Without prefix, api is returned.
+func WithPrefix(api API, prefix string) API {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return api
+	}
+	return &prefixedAPI{api: api, prefix: prefix + "/"}
+}
+
+func (p *prefixedAPI) WriteFile(ctx context.Context, key, filePath string) error {
+	return p.api.WriteFile(ctx, p.prefix+key, filePath)
+}
+
+func (p *prefixedAPI) WriteStream(ctx context.Context, key string, body io.Reader) error {
+	return p.api.WriteStream(ctx, p.prefix+key, body)
+}
+
+func (p *prefixedAPI) ReadObject(ctx context.Context, key string) ([]byte, error) {
+	return p.api.ReadObject(ctx, p.prefix+key)
+}
+
+func (p *prefixedAPI) WriteObject(ctx context.Context, key string, content []byte) error {
+	return p.api.WriteObject(ctx, p.prefix+key, content)
+}
+
+// DestinationBucket returns bucket and prefix, so that logged locations are complete
+func (p *prefixedAPI) DestinationBucket() string {
+	return p.api.DestinationBucket() + "/" + strings.TrimSuffix(p.prefix, "/")
+}
@@ -1,13 +1,16 @@
 package s3
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	awsS3 "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -15,9 +18,21 @@ import (
 //go:generate mockery --name API --filename s3_api.go
 type API interface {
 	WriteFile(ctx context.Context, key, filePath string) error
+	WriteStream(ctx context.Context, key string, body io.Reader) error
+	ReadObject(ctx context.Context, key string) ([]byte, error)
+	WriteObject(ctx context.Context, key string, content []byte) error
 	DestinationBucket() string
 }
 
+// Multipart upload settings used for streaming uploads: at most streamUploadConcurrency parts are buffered in memory
+const (
+	streamUploadPartSize    = 8 * 1024 * 1024
+	streamUploadConcurrency = 2
+)
+
+// ErrObjectNotFound is returned when requested object is not present in the bucket
+var ErrObjectNotFound = errors.New("object not found")
+
 type S3Client struct {
 	client     *awsS3.Client
 	bucketName string
@@ -32,24 +47,27 @@ func NewS3Client(bucketName string) (*S3Client, error) {
 	}
 	cl := awsS3.NewFromConfig(cfg)
 	// Check if we have permission to access the buckets
-	checkIfBucketExists(cl, bucketName)
+	if err := checkIfBucketExists(cl, bucketName); err != nil {
+		return nil, err
+	}
 	return &S3Client{
 		client:     cl,
 		bucketName: bucketName,
 	}, nil
 }
 
-func checkIfBucketExists(client *awsS3.Client, bucketName string) {
+func checkIfBucketExists(client *awsS3.Client, bucketName string) error {
 	params := awsS3.HeadBucketInput{
 		Bucket: aws.String(bucketName),
 	}
 	if _, err := client.HeadBucket(context.Background(), &params); err != nil {
 		var nsb *types.NoSuchBucket
 		if errors.As(err, &nsb) {
-			panic(fmt.Errorf("failed to verify that bucket %q exists: does not exist or it's not visible", bucketName))
+			return fmt.Errorf("failed to verify that bucket %q exists: does not exist or it's not visible", bucketName)
 		}
-		panic(fmt.Errorf("failed to verify that bucket %q exists: %w", bucketName, err))
+		return fmt.Errorf("failed to verify that bucket %q exists: %w", bucketName, err)
 	}
+	return nil
 }
 
 func (s *S3Client) WriteFile(ctx context.Context, key, filePath string) error {
@@ -70,6 +88,58 @@ func (s *S3Client) WriteFile(ctx context.Context, key, filePath string) error {
 	return nil
 }
 
+// WriteStream uploads content read from body via multipart upload, without requiring a local file.
+// If body returns an error, the multipart upload is aborted and no object is created.
+func (s *S3Client) WriteStream(ctx context.Context, key string, body io.Reader) error {
+	uploader := manager.NewUploader(s.client, func(u *manager.Uploader) {
+		u.PartSize = streamUploadPartSize
+		u.Concurrency = streamUploadConcurrency
+	})
+	params := awsS3.PutObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if _, err := uploader.Upload(ctx, &params); err != nil {
+		return fmt.Errorf("failed to stream object to S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Client) ReadObject(ctx context.Context, key string) ([]byte, error) {
+	params := awsS3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	}
+	out, err := s.client.GetObject(ctx, &params)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to read object from S3: %w", err)
+	}
+	defer out.Body.Close()
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object from S3: %w", err)
+	}
+	return content, nil
+}
+
+func (s *S3Client) WriteObject(ctx context.Context, key string, content []byte) error {
+	params := awsS3.PutObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	}
+	_, err := s.client.PutObject(ctx, &params)
+	if err != nil {
+		return fmt.Errorf("failed to write object to S3: %w", err)
+	}
+	return nil
+}
+
 func (s *S3Client) DestinationBucket() string {
 	return s.bucketName
 }
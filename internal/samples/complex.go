@@ -0,0 +1,171 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package samples
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/arduino/aws-s3-integration/internal/iot"
+)
+
+// Sub-fields of location values, renamed to explicit names
+var locationFields = map[string]struct {
+	name         string
+	propertyType iot.Type
+}{
+	"lat": {"latitude", iot.DegreesLatitude},
+	"lon": {"longitude", iot.DegreesLongitude},
+}
+
+// Known sub-fields of complex property types, in column order. Sub-fields of other complex types
+// (for example generic complex properties) are only available in the json encoded value.
+var complexTypeFields = []struct {
+	propertyType iot.Type
+	fields       []string
+}{
+	{iot.Location, []string{"lat", "lon"}},
+	{iot.ColorHSB, []string{"hue", "sat", "bri"}},
+	{iot.ColorRGB, []string{"hue", "sat", "bri"}},
+	{iot.HomeColoredLight, []string{"swi", "hue", "sat", "bri"}},
+	{iot.HomeDimmedLight, []string{"swi", "bri"}},
+	{iot.HomeTelevision, []string{"swi", "vol", "mut", "pbc", "inp", "cha"}},
+	{iot.Schedule, []string{"frm", "to", "len", "msk"}},
+}
+
+// SubFieldColumns returns the columns of known complex type sub-fields, used by layouts writing sub-fields
+// next to the complex value (for example latitude and longitude)
+func SubFieldColumns() []string {
+	columns := []string{}
+	for _, complexType := range complexTypeFields {
+		for _, field := range complexType.fields {
+			if column := subFieldColumn(string(complexType.propertyType), field); !slices.Contains(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+// SubFieldValues returns the sub-fields of a complex sample, keyed by column name (see SubFieldColumns)
+func SubFieldValues(sample Sample) map[string]any {
+	fields, ok := complexValue(sample)
+	if !ok {
+		return nil
+	}
+	values := make(map[string]any, len(fields))
+	for field, value := range fields {
+		values[subFieldColumn(sample.PropertyType, field)] = value
+	}
+	return values
+}
+
+func subFieldColumn(propertyType, field string) string {
+	if iot.IsPropertyLocation(propertyType) {
+		if location, ok := locationFields[field]; ok {
+			return location.name
+		}
+	}
+	return field
+}
+
+// SubFieldName returns the property name of a complex property sub-field, for example position.latitude
+func SubFieldName(propertyName, propertyType, field string) string {
+	return propertyName + "." + subFieldColumn(propertyType, field)
+}
+
+// ExpandComplexValue splits a sample with a complex value (for example a location or a colored light) into one sample
+// per sub-field, sorted by name. Sub-field samples are named after the property and the sub-field (for example position.latitude)
+// and typed after their value, so that they can be written as plain values. Samples of other property types are returned unchanged.
+func ExpandComplexValue(sample Sample) []Sample {
+	fields, ok := complexValue(sample)
+	if !ok || len(fields) == 0 {
+		return []Sample{sample}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expanded := make([]Sample, 0, len(fields))
+	for _, name := range names {
+		sub := sample
+		sub.PropertyName = SubFieldName(sample.PropertyName, sample.PropertyType, name)
+		sub.PropertyType = subFieldType(sample.PropertyType, name, fields[name])
+		sub.Value = fields[name]
+		expanded = append(expanded, sub)
+	}
+	return expanded
+}
+
+// complexValue returns the sub-fields of a complex property sample. Complex values can be returned by APIs as json encoded strings.
+func complexValue(sample Sample) (map[string]any, bool) {
+	if !iot.IsPropertyComplex(sample.PropertyType) {
+		return nil, false
+	}
+	switch v := sample.Value.(type) {
+	case map[string]any:
+		return v, true
+	case string:
+		if !strings.HasPrefix(strings.TrimSpace(v), "{") {
+			return nil, false
+		}
+		fields := map[string]any{}
+		if err := json.Unmarshal([]byte(v), &fields); err != nil {
+			return nil, false
+		}
+		return fields, true
+	}
+	return nil, false
+}
+
+func subFieldType(propertyType, field string, value any) string {
+	if iot.IsPropertyLocation(propertyType) {
+		if location, ok := locationFields[field]; ok {
+			return string(location.propertyType)
+		}
+	}
+	switch value.(type) {
+	case float64:
+		return string(iot.Float)
+	case bool:
+		return "BOOLEAN"
+	case string:
+		return string(iot.CharString)
+	}
+	return propertyType
+}
+
+// expandingSink expands complex values before pushing samples to the wrapped sink
+type expandingSink struct {
+	sink Sink
+}
+
+// ExpandComplexValues returns a sink expanding complex values in one sample per sub-field, before pushing them to sink
+func ExpandComplexValues(sink Sink) Sink {
+	return &expandingSink{sink: sink}
+}
+
+func (e *expandingSink) Write(toWrite []Sample) error {
+	expanded := make([]Sample, 0, len(toWrite))
+	for _, sample := range toWrite {
+		expanded = append(expanded, ExpandComplexValue(sample)...)
+	}
+	return e.sink.Write(expanded)
+}
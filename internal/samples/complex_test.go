@@ -0,0 +1,47 @@
+package samples
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandComplexValue(t *testing.T) {
+	ts := time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+	location := Sample{Time: ts, ThingID: "th1", PropertyName: "position", PropertyType: "LOCATION", Value: `{"lat":45.07,"lon":7.68}`}
+	expanded := ExpandComplexValue(location)
+	assert.Len(t, expanded, 2)
+	assert.Equal(t, "position.latitude", expanded[0].PropertyName)
+	assert.Equal(t, "DEGREES_LATITUDE", expanded[0].PropertyType)
+	assert.Equal(t, 45.07, expanded[0].Value)
+	assert.Equal(t, "position.longitude", expanded[1].PropertyName)
+
+	light := Sample{Time: ts, ThingID: "th1", PropertyName: "light", PropertyType: "HOME_DIMMED_LIGHT", Value: map[string]any{"swi": true, "bri": 80.0}}
+	expanded = ExpandComplexValue(light)
+	assert.Equal(t, []Sample{
+		{Time: ts, ThingID: "th1", PropertyName: "light.bri", PropertyType: "FLOAT", Value: 80.0},
+		{Time: ts, ThingID: "th1", PropertyName: "light.swi", PropertyType: "BOOLEAN", Value: true},
+	}, expanded)
+
+	// Colors are expanded in hue, saturation and brightness
+	for _, colorType := range []string{"COLOR_HSB", "COLOR_RGB"} {
+		color := Sample{Time: ts, ThingID: "th1", PropertyName: "color", PropertyType: colorType, Value: `{"hue":120,"sat":50,"bri":75}`}
+		assert.Equal(t, []Sample{
+			{Time: ts, ThingID: "th1", PropertyName: "color.bri", PropertyType: "FLOAT", Value: 75.0},
+			{Time: ts, ThingID: "th1", PropertyName: "color.hue", PropertyType: "FLOAT", Value: 120.0},
+			{Time: ts, ThingID: "th1", PropertyName: "color.sat", PropertyType: "FLOAT", Value: 50.0},
+		}, ExpandComplexValue(color), colorType)
+		assert.Equal(t, map[string]any{"hue": 120.0, "sat": 50.0, "bri": 75.0}, SubFieldValues(color), colorType)
+	}
+
+	// String properties holding json are not complex values
+	status := Sample{Time: ts, ThingID: "th1", PropertyName: "status", PropertyType: "CHARSTRING", Value: `{"lat":45.07}`}
+	assert.Equal(t, []Sample{status}, ExpandComplexValue(status))
+	assert.Nil(t, SubFieldValues(status))
+}
+
+func TestSubFieldValues(t *testing.T) {
+	assert.Equal(t, []string{"latitude", "longitude", "hue", "sat", "bri", "swi", "vol", "mut", "pbc", "inp", "cha", "frm", "to", "len", "msk"}, SubFieldColumns())
+	assert.Equal(t, map[string]any{"latitude": 45.07, "longitude": 7.68}, SubFieldValues(Sample{PropertyType: "LOCATION", Value: map[string]any{"lat": 45.07, "lon": 7.68}}))
+}
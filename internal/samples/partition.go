@@ -0,0 +1,116 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package samples
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// PartitionFunc returns the partition a sample belongs to
+type PartitionFunc func(sample Sample) string
+
+// WriterFactory creates the writer of a new partition
+type WriterFactory func(partition string) (Writer, error)
+
+// PartitionedWriter is a Sink that routes samples to a dedicated writer per partition
+// (for example, one file per thing). Writers are created on first sample of a partition.
+// Once closed, partitions do not accept samples anymore, while new partitions can still be written.
+type PartitionedWriter struct {
+	lock      sync.Mutex
+	partition PartitionFunc
+	factory   WriterFactory
+	writers   map[string]Writer
+	closed    map[string]bool
+}
+
+func NewPartitionedWriter(partition PartitionFunc, factory WriterFactory) *PartitionedWriter {
+	return &PartitionedWriter{
+		partition: partition,
+		factory:   factory,
+		writers:   make(map[string]Writer),
+		closed:    make(map[string]bool),
+	}
+}
+
+func (p *PartitionedWriter) Write(toWrite []Sample) error {
+	grouped := make(map[string][]Sample)
+	for _, sample := range toWrite {
+		key := p.partition(sample)
+		grouped[key] = append(grouped[key], sample)
+	}
+	for key, partitionSamples := range grouped {
+		writer, err := p.writer(key)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(partitionSamples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PartitionedWriter) writer(key string) (Writer, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed[key] {
+		return nil, fmt.Errorf("partition %s is already closed", key)
+	}
+	if writer, ok := p.writers[key]; ok {
+		return writer, nil
+	}
+	writer, err := p.factory(key)
+	if err != nil {
+		return nil, err
+	}
+	p.writers[key] = writer
+	return writer, nil
+}
+
+// Writers returns partition writers, indexed by partition
+func (p *PartitionedWriter) Writers() map[string]Writer {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	writers := make(map[string]Writer, len(p.writers))
+	for key, writer := range p.writers {
+		writers[key] = writer
+	}
+	return writers
+}
+
+// Close closes the writers of partitions not closed yet, releasing their files
+func (p *PartitionedWriter) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var errs []error
+	for key, writer := range p.writers {
+		if !p.closed[key] {
+			p.closed[key] = true
+			errs = append(errs, writer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *PartitionedWriter) Delete() error {
+	var errs []error
+	for _, writer := range p.Writers() {
+		errs = append(errs, writer.Delete())
+	}
+	return errors.Join(errs...)
+}
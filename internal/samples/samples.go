@@ -0,0 +1,93 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package samples
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Sample is a single time series sample extracted from Arduino Cloud.
+// Value keeps the type returned by the API (float64 for numeric series, string, bool or map[string]any otherwise).
+// Aggregation is empty for raw samples.
+type Sample struct {
+	Time         time.Time
+	ThingID      string
+	ThingName    string
+	PropertyID   string
+	PropertyName string
+	PropertyType string
+	Value        any
+	Aggregation  string
+}
+
+// Sink receives extracted samples. Implementations must be safe for concurrent use,
+// as samples of different things are pushed by concurrent extraction jobs.
+type Sink interface {
+	Write(samples []Sample) error
+}
+
+// Writer is a Sink backed by a local file, that can be uploaded once closed.
+type Writer interface {
+	Sink
+	GetFilePath() string
+	Close() error
+	Delete() error
+}
+
+// ValueToString returns the textual representation of a sample value.
+// Complex values (maps) are encoded as json.
+func ValueToString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// ValueToFloat returns the numeric representation of a sample value, if any.
+func ValueToFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
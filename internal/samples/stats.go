@@ -0,0 +1,99 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package samples
+
+import (
+	"slices"
+	"sync"
+)
+
+// Stats is a Sink keeping track of the samples written per thing and property
+type Stats struct {
+	lock   sync.Mutex
+	things map[string]*thingStats
+	// Samples of the same thing and timestamp are written in a single row
+	rowPerTimestamp bool
+}
+
+type thingStats struct {
+	rows       int
+	properties map[string]struct{}
+	timestamps map[int64]struct{}
+}
+
+func NewStats() *Stats {
+	return &Stats{things: make(map[string]*thingStats)}
+}
+
+// NewTimestampStats returns stats counting a row per thing and timestamp, as written by wide layouts
+func NewTimestampStats() *Stats {
+	return &Stats{things: make(map[string]*thingStats), rowPerTimestamp: true}
+}
+
+func (s *Stats) Write(toWrite []Sample) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sample := range toWrite {
+		stats, ok := s.things[sample.ThingID]
+		if !ok {
+			stats = &thingStats{properties: make(map[string]struct{}), timestamps: make(map[int64]struct{})}
+			s.things[sample.ThingID] = stats
+		}
+		stats.properties[sample.PropertyID] = struct{}{}
+		if !s.rowPerTimestamp {
+			stats.rows++
+			continue
+		}
+		if _, ok := stats.timestamps[sample.Time.Unix()]; !ok {
+			stats.timestamps[sample.Time.Unix()] = struct{}{}
+			stats.rows++
+		}
+	}
+	return nil
+}
+
+// Thing returns the number of rows written for the given thing and the sorted IDs of its properties
+func (s *Stats) Thing(thingID string) (int, []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats, ok := s.things[thingID]
+	if !ok {
+		return 0, nil
+	}
+	propertyIDs := make([]string, 0, len(stats.properties))
+	for id := range stats.properties {
+		propertyIDs = append(propertyIDs, id)
+	}
+	slices.Sort(propertyIDs)
+	return stats.rows, propertyIDs
+}
+
+// MultiSink returns a Sink writing samples to all the given sinks, in order.
+// Writing stops at first error.
+func MultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Write(toWrite []Sample) error {
+	for _, sink := range m {
+		if err := sink.Write(toWrite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+type SecretsClient struct {
+	smcl *secretsmanager.Client
+}
+
+func New(ctx context.Context) (*SecretsClient, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &SecretsClient{
+		smcl: secretsmanager.NewFromConfig(cfg),
+	}, nil
+}
+
+// ReadSecret returns the current value of a secret, by name or ARN
+func (c *SecretsClient) ReadSecret(ctx context.Context, secretID string) (string, error) {
+	value, err := c.smcl.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return "", err
+	}
+	if value.SecretString == nil {
+		return "", errors.New("secret " + secretID + " has no string value")
+	}
+	return *value.SecretString, nil
+}
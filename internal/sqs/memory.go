@@ -0,0 +1,51 @@
+package sqs
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// MemoryQueue is an in memory stand-in of an SQS queue, for tests and local executions
+type MemoryQueue struct {
+	mu       sync.Mutex
+	messages []events.SQSMessage
+	sent     int
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (q *MemoryQueue) SendMessages(_ context.Context, bodies []string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, body := range bodies {
+		q.sent++
+		q.messages = append(q.messages, events.SQSMessage{
+			MessageId:   strconv.Itoa(q.sent),
+			Body:        body,
+			EventSource: "aws:sqs",
+		})
+	}
+	return nil
+}
+
+// Receive removes and returns up to max queued messages, in the order they were sent, as delivered to lambda functions
+func (q *MemoryQueue) Receive(max int) []events.SQSMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := min(max, len(q.messages))
+	received := q.messages[:n:n]
+	q.messages = q.messages[n:]
+	return received
+}
+
+// Len returns the number of queued messages
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
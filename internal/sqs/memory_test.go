@@ -0,0 +1,42 @@
+package sqs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	q := NewMemoryQueue()
+	assert.NoError(t, q.SendMessages(context.Background(), []string{"a", "b", "c"}))
+	assert.Equal(t, 3, q.Len())
+
+	// Messages are received in the order they were sent, up to max
+	received := q.Receive(2)
+	assert.Len(t, received, 2)
+	assert.Equal(t, "a", received[0].Body)
+	assert.Equal(t, "b", received[1].Body)
+	assert.Equal(t, "aws:sqs", received[0].EventSource)
+	assert.Equal(t, 1, q.Len())
+
+	// Message IDs stay unique after messages are received
+	assert.NoError(t, q.SendMessages(context.Background(), []string{"d"}))
+	received = q.Receive(10)
+	assert.Len(t, received, 2)
+	assert.Equal(t, "c", received[0].Body)
+	assert.Equal(t, "d", received[1].Body)
+	assert.Equal(t, []string{"3", "4"}, []string{received[0].MessageId, received[1].MessageId})
+	assert.Equal(t, 0, q.Len())
+	assert.Empty(t, q.Receive(10))
+}
+
+func TestMemoryQueue_receivedMessagesAreNotShared(t *testing.T) {
+	q := NewMemoryQueue()
+	assert.NoError(t, q.SendMessages(context.Background(), []string{"a", "b"}))
+
+	// Appending to received messages must not overwrite queued ones
+	received := q.Receive(1)
+	_ = append(received, received[0])
+	assert.Equal(t, "b", q.Receive(1)[0].Body)
+}
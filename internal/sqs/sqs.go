@@ -0,0 +1,63 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awsSQS "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Max number of messages sent by a single SendMessageBatch request
+const sendBatchSize = 10
+
+type API interface {
+	SendMessages(ctx context.Context, bodies []string) error
+}
+
+type SQSClient struct {
+	client   *awsSQS.Client
+	queueURL string
+}
+
+// NewSQSClient returns a client sending messages to the given queue.
+// Endpoint can be overridden with AWS_ENDPOINT_URL_SQS, for example to use a local SQS compatible service.
+func NewSQSClient(ctx context.Context, queueURL string) (*SQSClient, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &SQSClient{
+		client:   awsSQS.NewFromConfig(cfg),
+		queueURL: queueURL,
+	}, nil
+}
+
+// SendMessages sends messages in batches, failing if any message is not accepted by the queue
+func (s *SQSClient) SendMessages(ctx context.Context, bodies []string) error {
+	for start := 0; start < len(bodies); start += sendBatchSize {
+		batch := bodies[start:min(start+sendBatchSize, len(bodies))]
+		entries := make([]types.SendMessageBatchRequestEntry, 0, len(batch))
+		for i, body := range batch {
+			entries = append(entries, types.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(body),
+			})
+		}
+		out, err := s.client.SendMessageBatch(ctx, &awsSQS.SendMessageBatchInput{
+			QueueUrl: aws.String(s.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send messages to SQS: %w", err)
+		}
+		if len(out.Failed) > 0 {
+			failed := out.Failed[0]
+			return fmt.Errorf("failed to send %d messages to SQS: %s", len(out.Failed), aws.ToString(failed.Message))
+		}
+	}
+	return nil
+}
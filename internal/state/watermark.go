@@ -0,0 +1,82 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/s3"
+)
+
+// Objects starting with '_' are ignored by Athena/Glue, so state never pollutes exported data
+const watermarkKeyFormat = "_arduino_export_state/%s/watermark.json"
+
+type watermark struct {
+	ExportedUpTo time.Time `json:"exported_up_to"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// WatermarkStore persists the end of the last exported time window as a small object in the destination bucket
+type WatermarkStore struct {
+	s3cl s3.API
+	key  string
+}
+
+func NewWatermarkStore(s3cl s3.API, stack string) *WatermarkStore {
+	if stack == "" {
+		stack = "default"
+	}
+	return &WatermarkStore{
+		s3cl: s3cl,
+		key:  fmt.Sprintf(watermarkKeyFormat, stack),
+	}
+}
+
+func (w *WatermarkStore) Key() string {
+	return w.key
+}
+
+// Load returns the stored watermark, or nil if no export has been recorded yet
+func (w *WatermarkStore) Load(ctx context.Context) (*time.Time, error) {
+	content, err := w.s3cl.ReadObject(ctx, w.key)
+	if err != nil {
+		if errors.Is(err, s3.ErrObjectNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var wm watermark
+	if err := json.Unmarshal(content, &wm); err != nil {
+		return nil, fmt.Errorf("invalid watermark object %s: %w", w.key, err)
+	}
+	exportedUpTo := wm.ExportedUpTo.UTC()
+	return &exportedUpTo, nil
+}
+
+func (w *WatermarkStore) Save(ctx context.Context, exportedUpTo time.Time) error {
+	content, err := json.Marshal(watermark{
+		ExportedUpTo: exportedUpTo.UTC(),
+		UpdatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return w.s3cl.WriteObject(ctx, w.key, content)
+}
@@ -0,0 +1,43 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arduino/aws-s3-integration/internal/s3"
+	s3Mocks "github.com/arduino/aws-s3-integration/internal/s3/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWatermark_notFound(t *testing.T) {
+	ctx := context.Background()
+	s3cl := s3Mocks.NewAPI(t)
+	s3cl.On("ReadObject", ctx, "_arduino_export_state/stack/watermark.json").Return(nil, s3.ErrObjectNotFound)
+
+	store := NewWatermarkStore(s3cl, "stack")
+	watermark, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, watermark)
+}
+
+func TestWatermark_saveAndLoad(t *testing.T) {
+	ctx := context.Background()
+	s3cl := s3Mocks.NewAPI(t)
+
+	var saved []byte
+	s3cl.On("WriteObject", ctx, "_arduino_export_state/stack/watermark.json", mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(2).([]byte)
+	}).Return(nil)
+
+	store := NewWatermarkStore(s3cl, "stack")
+	exportedUpTo := time.Date(2024, 9, 4, 11, 0, 0, 0, time.UTC)
+	assert.NoError(t, store.Save(ctx, exportedUpTo))
+	assert.Contains(t, string(saved), `"exported_up_to":"2024-09-04T11:00:00Z"`)
+
+	s3cl.On("ReadObject", ctx, "_arduino_export_state/stack/watermark.json").Return(saved, nil)
+	watermark, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, exportedUpTo, *watermark)
+}
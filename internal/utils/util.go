@@ -17,10 +17,11 @@ package utils
 
 import (
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
-	"strings"
 )
 
 func StringPointer(val string) *string {
@@ -31,22 +32,6 @@ func BoolPointer(val bool) *bool {
 	return &val
 }
 
-func ParseTags(tags *string) map[string]string {
-	tagsMap := make(map[string]string)
-	if tags == nil || *tags == "" {
-		println("No tags")
-		return tagsMap
-	}
-	tagsList := strings.Split(*tags, ",")
-	for _, tag := range tagsList {
-		parts := strings.Split(tag, "=")
-		if len(parts) == 2 && len(parts[0]) > 0 && len(parts[1]) > 0 {
-			tagsMap[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
-		}
-	}
-	return tagsMap
-}
-
 func GzipFileCompression(origFilePath string) (string, error) {
 	// Open the source file
 	src, err := os.Open(origFilePath)
@@ -75,3 +60,19 @@ func GzipFileCompression(origFilePath string) (string, error) {
 
 	return destFilePath, nil
 }
+
+// FileChecksum returns the hex encoded SHA-256 checksum and the size of the given file
+func FileChecksum(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
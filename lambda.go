@@ -18,267 +18,428 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/arduino/aws-s3-integration/app/config"
 	"github.com/arduino/aws-s3-integration/app/exporter"
+	"github.com/arduino/aws-s3-integration/business/thingselector"
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
 	"github.com/arduino/aws-s3-integration/internal/parameters"
+	"github.com/arduino/aws-s3-integration/internal/s3"
+	"github.com/arduino/aws-s3-integration/internal/secrets"
+	"github.com/arduino/aws-s3-integration/internal/sqs"
+	"github.com/arduino/aws-s3-integration/version"
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/sirupsen/logrus"
 )
 
 type AWSS3ImportTrigger struct {
 	Dev bool `json:"dev"`
+
+	// Backfill mode: when from/to are set, given time range is exported instead of last time window.
+	// Example: {"from": "2024-09-01T00:00:00Z", "to": "2024-10-01T00:00:00Z", "thing_ids": ["..."], "resolution": "15 minutes"}
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	ThingIDs   []string   `json:"thing_ids,omitempty"`
+	Resolution *string    `json:"resolution,omitempty"`
+
+	// Tenants to export, when multiple tenants are configured. All tenants are exported if not set.
+	Tenants []string `json:"tenants,omitempty"`
+
+	// Shard messages delivered by SQS to fan-out workers
+	Records []events.SQSMessage `json:"Records,omitempty"`
 }
 
-const (
-	GlobalArduinoPrefix = "/arduino/s3-importer"
-
-	// Parameters for backward compatibility
-	IoTApiKey           = GlobalArduinoPrefix + "/iot/api-key"
-	IoTApiSecret        = GlobalArduinoPrefix + "/iot/api-secret"
-	IoTApiOrgId         = GlobalArduinoPrefix + "/iot/org-id"
-	IoTApiTags          = GlobalArduinoPrefix + "/iot/filter/tags"
-	SamplesResoSec      = GlobalArduinoPrefix + "/iot/samples-resolution-seconds"
-	SamplesReso         = GlobalArduinoPrefix + "/iot/samples-resolution"
-	Scheduling          = GlobalArduinoPrefix + "/iot/scheduling"
-	DestinationS3Bucket = GlobalArduinoPrefix + "/destination-bucket"
-
-	// Per stack parameters
-	PerStackArduinoPrefix    = "/arduino/s3-exporter/" + parameters.StackName
-	IoTApiKeyStack           = PerStackArduinoPrefix + "/iot/api-key"
-	IoTApiSecretStack        = PerStackArduinoPrefix + "/iot/api-secret"
-	IoTApiOrgIdStack         = PerStackArduinoPrefix + "/iot/org-id"
-	IoTApiTagsStack          = PerStackArduinoPrefix + "/iot/filter/tags"
-	SamplesResoStack         = PerStackArduinoPrefix + "/iot/samples-resolution"
-	SchedulingStack          = PerStackArduinoPrefix + "/iot/scheduling"
-	DestinationS3BucketStack = PerStackArduinoPrefix + "/destination-bucket"
-	AggregationStatStack     = PerStackArduinoPrefix + "/iot/aggregation-statistic"
-	AlignWithTimeWindowStack = PerStackArduinoPrefix + "/iot/align_with_time_window"
-	EnableCompressionStack   = PerStackArduinoPrefix + "/enable_compression"
-
-	SamplesResolutionSeconds           = 300
-	DefaultTimeExtractionWindowMinutes = 60
-)
+func (t *AWSS3ImportTrigger) IsBackfill() bool {
+	return t.From != nil || t.To != nil
+}
+
+// selectTenants returns the configured tenants requested by the trigger
+func (t *AWSS3ImportTrigger) selectTenants(tenants []exporter.Tenant) ([]exporter.Tenant, error) {
+	if len(t.Tenants) == 0 {
+		return tenants, nil
+	}
+	byName := map[string]exporter.Tenant{}
+	for _, tenant := range tenants {
+		byName[tenant.Name] = tenant
+	}
+	selected := make([]exporter.Tenant, 0, len(t.Tenants))
+	for _, name := range t.Tenants {
+		tenant, ok := byName[name]
+		if !ok {
+			return nil, errors.New("unknown tenant: " + name)
+		}
+		selected = append(selected, tenant)
+	}
+	return selected, nil
+}
+
+func (t *AWSS3ImportTrigger) validateBackfill() error {
+	if t.From == nil || t.To == nil {
+		return errors.New("both from and to are required for backfill")
+	}
+	if !t.From.Before(*t.To) {
+		return errors.New("backfill from must be before to")
+	}
+	if t.To.After(time.Now()) {
+		return errors.New("backfill to cannot be in the future")
+	}
+	return nil
+}
 
-func HandleRequest(ctx context.Context, event *AWSS3ImportTrigger) (*string, error) {
+// AWSS3ImportResponse is the function response, reporting export status and failed things
+type AWSS3ImportResponse struct {
+	Message string `json:"message"`
+	*exporter.Report
+	// Report of each tenant, when multiple tenants are configured
+	Tenants []exporter.TenantReport `json:"tenants,omitempty"`
+	// Shard messages failed by fan-out workers, retried by SQS
+	BatchItemFailures []events.SQSBatchItemFailure `json:"batchItemFailures,omitempty"`
+}
+
+func newResponse(message string, report *exporter.Report) *AWSS3ImportResponse {
+	return &AWSS3ImportResponse{Message: message, Report: report}
+}
+
+// Parameters client is kept across warm invocations, reusing cached parameters
+var paramReader *parameters.ParametersClient
+
+func HandleRequest(ctx context.Context, event *AWSS3ImportTrigger) (*AWSS3ImportResponse, error) {
 
 	logger := logrus.NewEntry(logrus.New())
 
 	stackName := os.Getenv("STACK_NAME")
 
-	var apikey *string
-	var apiSecret *string
-	var destinationS3Bucket *string
-	var tags *string
-	var orgId *string
-	var err error
-	var aggregationStat *string
-	enabledCompression := false
-	enableAlignTimeWindow := false
-
-	logger.Infoln("------ Reading parameters from SSM")
-	paramReader, err := parameters.New()
+	if stackName != "" {
+		logger.Infoln("------ Configured stack: " + stackName)
+	}
+	provider, err := configProvider(ctx, logger, stackName)
 	if err != nil {
+		logger.Error(err)
 		return nil, err
 	}
+	cfg, err := config.Load(ctx, provider, stackName)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if cfg.DestinationDirectory != "" {
+		return nil, errors.New("destination directory is supported only by command line exporter, configure a destination bucket")
+	}
+	resolution := cfg.ResolutionSeconds
+	extractionWindowMinutes := cfg.TimeWindowMinutes
 
-	if stackName != "" {
-		logger.Infoln("------ Configured stack: " + stackName)
-		apikey, err = paramReader.ReadConfigByStack(IoTApiKeyStack, stackName)
-		if err != nil {
-			logger.Error("Error reading parameter "+paramReader.ResolveParameter(IoTApiKeyStack, stackName), err)
+	if event.IsBackfill() {
+		if err := event.validateBackfill(); err != nil {
+			return nil, err
 		}
-		apiSecret, err = paramReader.ReadConfigByStack(IoTApiSecretStack, stackName)
-		if err != nil {
-			logger.Error("Error reading parameter "+paramReader.ResolveParameter(IoTApiSecretStack, stackName), err)
-		}
-		destinationS3Bucket, err = paramReader.ReadConfigByStack(DestinationS3BucketStack, stackName)
-		if err != nil || destinationS3Bucket == nil || *destinationS3Bucket == "" {
-			logger.Error("Error reading parameter "+paramReader.ResolveParameter(DestinationS3BucketStack, stackName), err)
-		}
-		orgId, _ = paramReader.ReadConfigByStack(IoTApiOrgIdStack, stackName)
-		tagsParam, _ := paramReader.ReadConfigByStack(IoTApiTagsStack, stackName)
-		if tagsParam != nil {
-			tags = tagsParam
+		if event.Resolution != nil {
+			res, err := config.ParseResolution(*event.Resolution)
+			if err != nil {
+				return nil, errors.New("unsupported backfill resolution: " + *event.Resolution)
+			}
+			resolution = res
 		}
-		aggregationStat, _ = paramReader.ReadConfigByStack(AggregationStatStack, stackName)
+	}
 
-		alignTs, _ := paramReader.ReadConfigByStack(AlignWithTimeWindowStack, stackName)
-		if alignTs != nil && *alignTs == "true" {
-			enableAlignTimeWindow = true
+	if extractionWindowMinutes > 60 && resolution <= 60 {
+		if event.IsBackfill() {
+			// Keep requested resolution, splitting backfill in hourly windows
+			logger.Warn("Resolution must be greater than 60 seconds for time windows greater than 60 minutes. Backfill time window set to 60 minutes.")
+			extractionWindowMinutes = config.DefaultTimeWindowMinutes
+		} else {
+			logger.Warn("Resolution must be greater than 60 seconds for time windows greater than 60 minutes. Setting resolution to 5 minutes.")
+			resolution = config.DefaultResolutionSeconds
 		}
+	}
 
-		compression, _ := paramReader.ReadConfigByStack(EnableCompressionStack, stackName)
-		if compression != nil && *compression == "true" {
-			enabledCompression = true
-		}
+	if err := tsextractor.ValidateOutputFormat(cfg.OutputFormat, resolution, extractionWindowMinutes, cfg.AggregationStats, cfg.PropertyRules); err != nil {
+		return nil, err
+	}
 
-	} else {
-		apikey, err = paramReader.ReadConfig(IoTApiKey)
-		if err != nil {
-			logger.Error("Error reading parameter "+IoTApiKey, err)
-		}
-		apiSecret, err = paramReader.ReadConfig(IoTApiSecret)
-		if err != nil {
-			logger.Error("Error reading parameter "+IoTApiSecret, err)
-		}
-		destinationS3Bucket, err = paramReader.ReadConfig(DestinationS3Bucket)
-		if err != nil || destinationS3Bucket == nil || *destinationS3Bucket == "" {
-			logger.Error("Error reading parameter "+DestinationS3Bucket, err)
-		}
-		orgId, _ = paramReader.ReadConfig(IoTApiOrgId)
-		tagsParam, _ := paramReader.ReadConfig(IoTApiTags)
-		if tagsParam != nil {
-			tags = tagsParam
+	logger.Infoln("------ Running import")
+	logger.Infoln("exporter version:", version.Version())
+	if event.Dev || os.Getenv("DEV") == "true" {
+		logger.Infoln("Running in dev mode")
+		os.Setenv("IOT_API_URL", "https://api2.oniudra.cc")
+	}
+	if len(event.Records) > 0 {
+		return exportShards(ctx, logger, cfg, event.Records)
+	}
+	if len(cfg.Tenants) == 0 {
+		logger.Infoln("key:", cfg.ApiKey)
+		logger.Infoln("secret:", "*********")
+		if cfg.OrgID != "" {
+			logger.Infoln("organizationId:", cfg.OrgID)
+		} else {
+			logger.Infoln("organizationId: not set")
 		}
+		logger.Infoln("things selection:", cfg.Selector.String())
+	} else {
+		logger.Infoln("tenants:", len(cfg.Tenants))
 	}
+	if resolution <= 0 {
+		logger.Infoln("resolution: raw")
+	} else {
+		logger.Infoln("resolution:", resolution, "seconds")
+	}
+	logger.Infoln("aggregation statistics:", strings.Join(cfg.AggregationStats, ", "))
+	logger.Infoln("data extraction time window:", extractionWindowMinutes, "minutes")
+	logger.Infoln("file compression enabled:", cfg.Compress)
+	logger.Infoln("output format:", cfg.OutputFormat)
+	logger.Infoln("export watermark enabled:", cfg.Watermark)
+	logger.Infoln("destination key template:", cfg.KeyTemplate.String())
+	logger.Infoln("output split:", cfg.OutputSplit.String())
+	logger.Infoln("streaming upload enabled:", cfg.StreamUpload)
+	logger.Infoln("error policy:", cfg.ErrorPolicy.String())
+	logger.Infoln("property rules:", len(cfg.PropertyRules))
+	logger.Infoln("property filter:", cfg.PropertyFilter.String())
+	logger.Infoln("expand complex values:", cfg.ExpandComplexValues)
+	logger.Infoln("align time window:", cfg.AlignTimeWindow)
 
-	organizationId := ""
-	if orgId != nil {
-		organizationId = *orgId
+	run := exportRun{
+		event:             event,
+		resolution:        resolution,
+		timeWindowMinutes: extractionWindowMinutes,
+		aggregationStats:  cfg.AggregationStats,
 	}
-	if apikey == nil || apiSecret == nil {
-		return nil, errors.New("key and secret are required")
+	if cfg.FanOutQueueURL != "" {
+		logger.Infoln("fan-out queue:", cfg.FanOutQueueURL)
+		logger.Infoln("fan-out shard size:", cfg.FanOutShardSize, "things")
+		if run.queue, err = sqs.NewSQSClient(ctx, cfg.FanOutQueueURL); err != nil {
+			return nil, err
+		}
+		run.shardSize = cfg.FanOutShardSize
 	}
-	if aggregationStat == nil {
-		avgAggregation := "AVG"
-		aggregationStat = &avgAggregation
+	if len(cfg.Tenants) > 0 {
+		return exportTenants(ctx, logger, cfg, run)
 	}
 
-	// Resolve resolution
-	resolution, err := configureExtractionResolution(logger, paramReader, stackName)
+	s3cl, err := s3.NewS3Client(cfg.DestinationBucket)
 	if err != nil {
 		return nil, err
 	}
+	report, err := run.export(ctx, logger, "", cfg.ApiKey, cfg.ApiSecret, cfg.OrgID, cfg.Options, s3cl)
+	if err != nil {
+		return newResponse("Error detected during data export", report), err
+	}
+	if report.Status == exporter.StatusPartial {
+		return newResponse("Data exported partially", report), nil
+	}
 
-	// Resolve scheduling
-	extractionWindowMinutes, err := configureDataExtractionTimeWindow(logger, paramReader, stackName)
+	return newResponse("Data exported successfully", report), nil
+}
+
+// exportTenants exports each configured tenant, with its own credentials, things selection and destination
+func exportTenants(ctx context.Context, logger *logrus.Entry, cfg *config.Config, run exportRun) (*AWSS3ImportResponse, error) {
+	tenants, err := run.event.selectTenants(cfg.Tenants)
 	if err != nil {
 		return nil, err
 	}
+	report, err := exporter.ExportTenants(ctx, tenants, func(ctx context.Context, tenant exporter.Tenant, logger *logrus.Entry) (*exporter.Report, error) {
+		options, destination, err := tenantExport(cfg, tenant, logger)
+		if err != nil {
+			return nil, err
+		}
+		return run.export(ctx, logger, tenant.Name, tenant.ApiKey, tenant.ApiSecret, tenant.OrgID, options, destination)
+	}, logger)
 
-	if *extractionWindowMinutes > 60 && *resolution <= 60 {
-		logger.Warn("Resolution must be greater than 60 seconds for time windows greater than 60 minutes. Setting resolution to 5 minutes.")
-		defReso := SamplesResolutionSeconds
-		resolution = &defReso
+	response := &AWSS3ImportResponse{Report: report.Report, Tenants: report.Tenants}
+	switch {
+	case err != nil:
+		response.Message = "Error detected during data export"
+	case report.Status == exporter.StatusPartial:
+		response.Message = "Data exported partially"
+	default:
+		response.Message = "Data exported successfully"
 	}
+	return response, err
+}
 
-	logger.Infoln("------ Running import")
-	if event.Dev || os.Getenv("DEV") == "true" {
-		logger.Infoln("Running in dev mode")
-		os.Setenv("IOT_API_URL", "https://api2.oniudra.cc")
+// tenantExport returns export options and destination of a tenant
+func tenantExport(cfg *config.Config, tenant exporter.Tenant, logger *logrus.Entry) (exporter.Options, s3.API, error) {
+	options := cfg.Options
+	selector, err := thingselector.New("", "", "", tenant.Tags)
+	if err != nil {
+		return options, nil, err
 	}
-	logger.Infoln("key:", *apikey)
-	logger.Infoln("secret:", "*********")
-	if organizationId != "" {
-		logger.Infoln("organizationId:", organizationId)
-	} else {
-		logger.Infoln("organizationId: not set")
+	options.Selector = selector
+	bucket := tenant.DestinationBucket
+	if bucket == "" {
+		bucket = cfg.DestinationBucket
 	}
-	if tags != nil {
-		logger.Infoln("tags:", *tags)
+	logger.Infoln("key:", tenant.ApiKey)
+	logger.Infoln("things selection:", selector.String())
+	logger.Infoln("destination:", bucket+"/"+tenant.DestinationPrefix)
+	s3cl, err := s3.NewS3Client(bucket)
+	if err != nil {
+		return options, nil, err
 	}
-	if *resolution <= 0 {
-		logger.Infoln("resolution: raw")
-	} else {
-		logger.Infoln("resolution:", *resolution, "seconds")
+	return options, s3.WithPrefix(s3cl, tenant.DestinationPrefix), nil
+}
+
+// exportShards exports shards delivered by SQS to fan-out workers. Failed shards are reported to SQS,
+// that delivers them again, while the others are removed from the queue.
+func exportShards(ctx context.Context, logger *logrus.Entry, cfg *config.Config, records []events.SQSMessage) (*AWSS3ImportResponse, error) {
+	tenants := map[string]exporter.Tenant{}
+	for _, tenant := range cfg.Tenants {
+		tenants[tenant.Name] = tenant
 	}
-	logger.Infoln("aggregation statistic:", *aggregationStat)
-	logger.Infoln("data extraction time window:", *extractionWindowMinutes, "minutes")
-	logger.Infoln("file compression enabled:", enabledCompression)
-	logger.Infoln("align time window:", enableAlignTimeWindow)
 
-	tsExporter, err := exporter.New(*apikey, *apiSecret, organizationId, tags, enabledCompression, enableAlignTimeWindow, logger)
+	response := &AWSS3ImportResponse{Report: &exporter.Report{Status: exporter.StatusSuccess}}
+	for _, record := range records {
+		shardLogger := logger.WithField("message", record.MessageId)
+		report, err := exportShard(ctx, shardLogger, cfg, tenants, record.Body)
+		if report != nil {
+			response.Merge(report)
+		}
+		if err != nil {
+			shardLogger.Error("Error exporting shard: ", err)
+			response.Status = exporter.StatusPartial
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+
+	switch {
+	case len(response.BatchItemFailures) == len(records):
+		response.Status = exporter.StatusFailed
+		response.Message = "Error detected during data export"
+	case response.Status == exporter.StatusPartial:
+		response.Message = "Data exported partially"
+	default:
+		response.Message = "Data exported successfully"
+	}
+	// Failed shards are reported in the response: returning an error would make SQS deliver the whole batch again
+	return response, nil
+}
+
+// exportShard exports the shard requested by a message body
+func exportShard(ctx context.Context, logger *logrus.Entry, cfg *config.Config, tenants map[string]exporter.Tenant, body string) (*exporter.Report, error) {
+	message, err := exporter.ParseShardMessage(body)
 	if err != nil {
 		return nil, err
 	}
-	err = tsExporter.StartExporter(ctx, *resolution, *extractionWindowMinutes, *destinationS3Bucket, *aggregationStat)
+	logger.Infof("Exporting shard %d/%d of time window from %s to %s, %d things\n", message.Shard, message.Shards, message.From, message.To, len(message.ThingIDs))
+
+	key, secret, orgID, options := cfg.ApiKey, cfg.ApiSecret, cfg.OrgID, cfg.Options
+	var destination s3.API
+	if message.Tenant != "" {
+		tenant, ok := tenants[message.Tenant]
+		if !ok {
+			return nil, errors.New("unknown tenant: " + message.Tenant)
+		}
+		logger = logger.WithField("tenant", tenant.Name)
+		key, secret, orgID = tenant.ApiKey, tenant.ApiSecret, tenant.OrgID
+		if options, destination, err = tenantExport(cfg, tenant, logger); err != nil {
+			return nil, err
+		}
+	} else if destination, err = s3.NewS3Client(cfg.DestinationBucket); err != nil {
+		return nil, err
+	}
+
+	tsExporter, err := exporter.New(key, secret, orgID, options, logger)
 	if err != nil {
-		message := "Error detected during data export"
-		return &message, err
+		return nil, err
 	}
+	return tsExporter.ExportShard(ctx, message, destination, cfg.AggregationStats)
+}
+
+// exportRun holds the time range and resolution of an execution, common to all tenants
+type exportRun struct {
+	event             *AWSS3ImportTrigger
+	resolution        int
+	timeWindowMinutes int
+	aggregationStats  []string
+	// Fan-out queue and shard size, when fan-out is enabled
+	queue     sqs.API
+	shardSize int
+}
 
-	message := "Data exported successfully"
-	return &message, nil
+// export exports last time window, or the backfill time range, of the organization identified by credentials.
+// When fan-out is enabled, export of shards of things is enqueued instead.
+func (r exportRun) export(ctx context.Context, logger *logrus.Entry, tenant, key, secret, orgID string, options exporter.Options, destination s3.API) (*exporter.Report, error) {
+	tsExporter, err := exporter.New(key, secret, orgID, options, logger)
+	if err != nil {
+		return nil, err
+	}
+	if r.queue != nil {
+		fanOut := exporter.FanOut{Queue: r.queue, ShardSize: r.shardSize, Tenant: tenant}
+		if r.event.IsBackfill() {
+			logger.Infoln("backfill from:", *r.event.From, "to:", *r.event.To)
+			return tsExporter.StartBackfillCoordinator(ctx, *r.event.From, *r.event.To, r.event.ThingIDs, r.resolution, r.timeWindowMinutes, fanOut)
+		}
+		return tsExporter.StartCoordinator(ctx, r.resolution, r.timeWindowMinutes, destination, fanOut)
+	}
+	if r.event.IsBackfill() {
+		logger.Infoln("backfill from:", *r.event.From, "to:", *r.event.To)
+		return tsExporter.StartBackfill(ctx, *r.event.From, *r.event.To, r.event.ThingIDs, r.resolution, r.timeWindowMinutes, destination, r.aggregationStats)
+	}
+	return tsExporter.StartExporter(ctx, r.resolution, r.timeWindowMinutes, destination, r.aggregationStats)
 }
 
-func configureExtractionResolution(logger *logrus.Entry, paramReader *parameters.ParametersClient, stack string) (*int, error) {
-	var resolution *int
-	var res *string
-	var err error
-	if stack != "" {
-		res, err = paramReader.ReadConfigByStack(SamplesResoStack, stack)
+// configProvider returns the configuration provider, reading parameters with the following precedence:
+// environment variables, YAML file (ARDUINO_EXPORTER_CONFIG), Secrets Manager secret (ARDUINO_EXPORTER_CONFIG_SECRET),
+// SSM parameters of the stack (unless ARDUINO_EXPORTER_SSM_DISABLED is true).
+func configProvider(ctx context.Context, logger *logrus.Entry, stackName string) (config.Provider, error) {
+	providers := []config.Provider{config.NewEnvProvider(os.LookupEnv)}
+
+	if file := os.Getenv(config.EnvPrefix + "CONFIG"); file != "" {
+		logger.Infoln("------ Reading parameters from config file " + file)
+		fileProvider, err := config.NewFileProvider(file)
 		if err != nil {
-			logger.Error("Error reading parameter "+paramReader.ResolveParameter(SamplesResoStack, stack), err)
+			return nil, err
 		}
-	} else {
-		resolution, err = paramReader.ReadIntConfig(SamplesResoSec)
+		providers = append(providers, fileProvider)
+	}
+
+	if secretID := os.Getenv(config.EnvPrefix + "CONFIG_SECRET"); secretID != "" {
+		logger.Infoln("------ Reading parameters from Secrets Manager secret " + secretID)
+		secretsClient, err := secrets.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secretProvider, err := config.NewSecretProvider(ctx, secretsClient, secretID)
 		if err != nil {
-			// Possibly this parameter is not set. Try SamplesReso
-			res, err = paramReader.ReadConfig(SamplesReso)
+			return nil, err
+		}
+		providers = append(providers, secretProvider)
+	}
+
+	if os.Getenv(config.EnvPrefix+"SSM_DISABLED") != "true" {
+		logger.Infoln("------ Reading parameters from SSM")
+		if paramReader == nil {
+			cacheTTL, err := parametersCacheTTL()
 			if err != nil {
-				logger.Error("Error reading parameter "+SamplesReso, err)
 				return nil, err
 			}
-		} else {
-			return resolution, nil
+			if paramReader, err = parameters.New(ctx, cacheTTL); err != nil {
+				return nil, err
+			}
+		}
+		if err := paramReader.Preload(ctx, config.Namespace(stackName)); err != nil {
+			return nil, fmt.Errorf("reading parameters from SSM: %w", err)
 		}
+		providers = append(providers, config.NewSSMProvider(paramReader, stackName))
 	}
 
-	val := SamplesResolutionSeconds
-	switch *res {
-	case "raw":
-		val = -1
-	case "1 minute":
-		val = 60
-	case "5 minutes":
-		val = 300
-	case "15 minutes":
-		val = 900
-	case "1 hour":
-		val = 3600
-	}
-	resolution = &val
-	if *resolution > 3600 {
-		logger.Errorf("Resolution %d is invalid", *resolution)
-		return nil, errors.New("resolution must be between -1 and 3600")
-	}
-	return resolution, nil
+	return config.NewChain(providers...), nil
 }
 
-func configureDataExtractionTimeWindow(logger *logrus.Entry, paramReader *parameters.ParametersClient, stack string) (*int, error) {
-	var schedule *string
-	var err error
-	extractionWindowMinutes := DefaultTimeExtractionWindowMinutes
-
-	if stack != "" {
-		schedule, err = paramReader.ReadConfigByStack(SchedulingStack, stack)
-		if err != nil {
-			logger.Error("Error reading parameter "+paramReader.ResolveParameter(SchedulingStack, stack), err)
-		}
-	} else {
-		schedule, err = paramReader.ReadConfig(Scheduling)
-		if err != nil {
-			// In such case, scheduling is fixed to 1 hour
-			return &extractionWindowMinutes, nil
-		}
+// parametersCacheTTL returns how long SSM parameters are cached, configurable with PARAMETERS_CACHE_TTL env variable (for example 1m, 0 to load them on every invocation)
+func parametersCacheTTL() (time.Duration, error) {
+	ttl := os.Getenv("PARAMETERS_CACHE_TTL")
+	if ttl == "" {
+		return parameters.DefaultCacheTTL, nil
 	}
+	duration, err := time.ParseDuration(ttl)
 	if err != nil {
-		logger.Error("Error reading parameter "+Scheduling, err)
-		return nil, err
-	}
-
-	switch *schedule {
-	case "5 minutes":
-		extractionWindowMinutes = 5
-	case "15 minutes":
-		extractionWindowMinutes = 15
-	case "1 hour":
-		extractionWindowMinutes = 60
-	case "1 day":
-		extractionWindowMinutes = 24 * 60
+		return 0, errors.New("invalid PARAMETERS_CACHE_TTL: " + ttl)
 	}
-	return &extractionWindowMinutes, nil
+	return duration, nil
 }
 
 func main() {
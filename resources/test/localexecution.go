@@ -6,7 +6,11 @@ import (
 	"os"
 
 	"github.com/arduino/aws-s3-integration/app/exporter"
+	"github.com/arduino/aws-s3-integration/business/thingselector"
+	"github.com/arduino/aws-s3-integration/business/tsextractor"
+	"github.com/arduino/aws-s3-integration/internal/keytemplate"
 	"github.com/arduino/aws-s3-integration/internal/parameters"
+	"github.com/arduino/aws-s3-integration/internal/s3"
 	"github.com/sirupsen/logrus"
 )
 
@@ -33,23 +37,23 @@ func HandleRequest(ctx context.Context, dev bool) (*string, error) {
 	var tags *string
 
 	logger.Infoln("------ Reading parameters from SSM")
-	paramReader, err := parameters.New()
+	paramReader, err := parameters.New(ctx, 0)
 	if err != nil {
 		return nil, err
 	}
-	apikey, err := paramReader.ReadConfig(IoTApiKey)
+	apikey, err := paramReader.ReadConfig(ctx, IoTApiKey)
 	if err != nil {
 		logger.Error("Error reading parameter "+IoTApiKey, err)
 	}
-	apiSecret, err := paramReader.ReadConfig(IoTApiSecret)
+	apiSecret, err := paramReader.ReadConfig(ctx, IoTApiSecret)
 	if err != nil {
 		logger.Error("Error reading parameter "+IoTApiSecret, err)
 	}
-	destinationS3Bucket, err := paramReader.ReadConfig(DestinationS3Bucket)
+	destinationS3Bucket, err := paramReader.ReadConfig(ctx, DestinationS3Bucket)
 	if err != nil || destinationS3Bucket == nil || *destinationS3Bucket == "" {
 		logger.Error("Error reading parameter "+DestinationS3Bucket, err)
 	}
-	origId, _ := paramReader.ReadConfig(IoTApiOrgId)
+	origId, _ := paramReader.ReadConfig(ctx, IoTApiOrgId)
 	organizationId := ""
 	if origId != nil {
 		organizationId = *origId
@@ -57,11 +61,11 @@ func HandleRequest(ctx context.Context, dev bool) (*string, error) {
 	if apikey == nil || apiSecret == nil {
 		return nil, errors.New("key and secret are required")
 	}
-	tagsParam, _ := paramReader.ReadConfig(IoTApiTags)
+	tagsParam, _ := paramReader.ReadConfig(ctx, IoTApiTags)
 	if tagsParam != nil {
 		tags = tagsParam
 	}
-	resolution, err := paramReader.ReadIntConfig(SamplesResoSec)
+	resolution, err := paramReader.ReadIntConfig(ctx, SamplesResoSec)
 	if err != nil {
 		logger.Warn("Error reading parameter "+SamplesResoSec+". Set resolution to default value", err)
 		res := SamplesResolutionSeconds
@@ -89,11 +93,34 @@ func HandleRequest(ctx context.Context, dev bool) (*string, error) {
 		logger.Infoln("tags:", *tags)
 	}
 
-	tsExporter, err := exporter.New(*apikey, *apiSecret, organizationId, tags, true, true, logger)
+	keyTemplate, err := keytemplate.Parse(keytemplate.PresetDefault)
 	if err != nil {
 		return nil, err
 	}
-	err = tsExporter.StartExporter(ctx, *resolution, TimeExtractionWindowMinutes, *destinationS3Bucket, "MAX")
+	tagsFilter := ""
+	if tags != nil {
+		tagsFilter = *tags
+	}
+	selector, err := thingselector.New("", "", "", tagsFilter)
+	if err != nil {
+		return nil, err
+	}
+	tsExporter, err := exporter.New(*apikey, *apiSecret, organizationId, exporter.Options{
+		Selector:        selector,
+		Compress:        true,
+		AlignTimeWindow: true,
+		OutputFormat:    tsextractor.OutputFormatCSV,
+		KeyTemplate:     keyTemplate,
+		ErrorPolicy:     exporter.ErrorPolicy{Mode: exporter.ErrorPolicyFail},
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	s3cl, err := s3.NewS3Client(*destinationS3Bucket)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tsExporter.StartExporter(ctx, *resolution, TimeExtractionWindowMinutes, s3cl, []string{"MAX"})
 	if err != nil {
 		message := "Error detected during data export"
 		return &message, err
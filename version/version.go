@@ -0,0 +1,36 @@
+// This file is part of arduino aws-s3-integration.
+//
+// Copyright 2024 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the Mozilla Public License Version 2.0,
+// which covers the main part of aws-s3-integration.
+// The terms of this license can be found at:
+// https://www.mozilla.org/media/MPL/2.0/index.815ca599c9df.txt
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to
+// modify or otherwise use the software for commercial activities involving the
+// Arduino software without disclosing the source code of your own applications.
+// To purchase a commercial license, send an email to license@arduino.cc.
+
+package version
+
+// Values are set at build time with
+// -ldflags "-X github.com/arduino/aws-s3-integration/version.versionString=<version> -X github.com/arduino/aws-s3-integration/version.commit=<commit>"
+var (
+	defaultVersionString = "0.0.0-git"
+	versionString        = ""
+	commit               = ""
+)
+
+// Version returns the exporter version, including commit when available
+func Version() string {
+	version := versionString
+	if version == "" {
+		version = defaultVersionString
+	}
+	if commit != "" {
+		version += " (" + commit + ")"
+	}
+	return version
+}